@@ -0,0 +1,270 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package biometrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/jpeg"
+	"io"
+	"io/ioutil"
+
+	"pault.ag/go/cbeff"
+)
+
+const (
+	// CBEFF BDB Format Type of an INCITS 385 facial image record, as used
+	// on a PIV. The Format Owner is the same as for minutiae.
+	formatTypeFace uint16 = 0x0501
+
+	faceRecordFixed      = 14
+	faceInformationFixed = 20
+	featurePointLength   = 8
+	imageInformationSize = 12
+)
+
+// ImageDecoder decodes an encoded image into an image.Image.
+type ImageDecoder func([]byte) (image.Image, error)
+
+// JPEG2000Decoder is used by FaceImage.Image to decode JPEG 2000 images.
+// The Go standard library has no JPEG 2000 decoder, so this is nil unless
+// set by the program, for instance to pault.ag/go/cbeff/jpeg2000.Parse.
+var JPEG2000Decoder ImageDecoder
+
+// FaceImageType is the kind of photo a FaceImage is, which says how closely
+// it follows the rules for face pose, lighting and framing.
+type FaceImageType uint8
+
+const (
+	FaceImageBasic        FaceImageType = 0x00
+	FaceImageFullFrontal  FaceImageType = 0x01
+	FaceImageTokenFrontal FaceImageType = 0x02
+)
+
+// FaceImageDataType is the encoding of the FaceImage Data.
+type FaceImageDataType uint8
+
+const (
+	FaceImageJPEG     FaceImageDataType = 0x00
+	FaceImageJPEG2000 FaceImageDataType = 0x01
+)
+
+// FeaturePoint is a single landmark on the face, such as the center of an
+// eye, as defined by the MPEG-4 feature points.
+type FeaturePoint struct {
+	Type  uint8
+	Major uint8
+	Minor uint8
+
+	// Location of the FeaturePoint in the image, in pixels from the top
+	// left.
+	X, Y uint16
+}
+
+// PoseAngles are the yaw, pitch and roll of the face, in degrees. Each is
+// nil if not specified.
+type PoseAngles struct {
+	Yaw, Pitch, Roll *int
+}
+
+// FaceImage is a single image of the cardholder's face, from an INCITS 385
+// Facial Image Record, which is what is stored in the PIV facial image.
+type FaceImage struct {
+	BiographicalInformation cbeff.BiographicalInformation
+
+	// Expression of the face, such as 1 for neutral.
+	Expression uint16
+
+	// Angles of the face, and the uncertainty in each.
+	Pose            PoseAngles
+	PoseUncertainty PoseAngles
+
+	FeaturePoints []FeaturePoint
+
+	Type     FaceImageType
+	DataType FaceImageDataType
+
+	Width, Height uint16
+
+	ColorSpace uint8
+	SourceType uint8
+	DeviceType uint16
+	Quality    uint16
+
+	// Encoded image, in the given DataType.
+	Data []byte
+}
+
+// Image will decode the FaceImage Data. JPEG images are decoded using the
+// standard library, and JPEG 2000 images using the JPEG2000Decoder, if set.
+func (f FaceImage) Image() (image.Image, error) {
+	switch f.DataType {
+	case FaceImageJPEG:
+		return jpeg.Decode(bytes.NewReader(f.Data))
+	case FaceImageJPEG2000:
+		if JPEG2000Decoder == nil {
+			return nil, fmt.Errorf("cbeff: no JPEG 2000 decoder is set")
+		}
+		return JPEG2000Decoder(f.Data)
+	default:
+		return nil, fmt.Errorf("cbeff: unknown face image data type %d", f.DataType)
+	}
+}
+
+// ParseFaceImages will parse an INCITS 385 Facial Image Record, returning
+// each of the FaceImages it contains.
+func ParseFaceImages(data []byte) ([]FaceImage, error) {
+	if len(data) < faceRecordFixed {
+		return nil, fmt.Errorf("cbeff: facial record is too short")
+	}
+	if !bytes.Equal(data[:4], []byte{'F', 'A', 'C', 0x00}) {
+		return nil, fmt.Errorf("cbeff: facial record format isn't FAC\\0")
+	}
+	if !bytes.Equal(data[4:8], []byte{'0', '1', '0', 0x00}) {
+		return nil, fmt.Errorf("cbeff: facial record version isn't 010\\0")
+	}
+	if int(binary.BigEndian.Uint32(data[8:12])) != len(data) {
+		return nil, fmt.Errorf("cbeff: facial record length disagrees with the data")
+	}
+
+	faces := int(binary.BigEndian.Uint16(data[12:14]))
+	ret := []FaceImage{}
+
+	rest := data[faceRecordFixed:]
+	for i := 0; i < faces; i++ {
+		face, n, err := parseFaceImage(rest)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *face)
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cbeff: facial record has trailing data")
+	}
+
+	return ret, nil
+}
+
+// Parse a single Facial Record Data block, returning the number of bytes it
+// took up.
+func parseFaceImage(data []byte) (*FaceImage, int, error) {
+	if len(data) < faceInformationFixed {
+		return nil, 0, fmt.Errorf("cbeff: facial information is truncated")
+	}
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	points := int(binary.BigEndian.Uint16(data[4:6]))
+	if length > len(data) || length < faceInformationFixed+points*featurePointLength+imageInformationSize {
+		return nil, 0, fmt.Errorf("cbeff: facial information length is invalid")
+	}
+	data = data[:length]
+
+	face := FaceImage{
+		BiographicalInformation: cbeff.BiographicalInformation{
+			Gender:    cbeff.BiographicalInformationGender(data[6]),
+			EyeColor:  cbeff.BiographicalInformationEyeColor(data[7]),
+			HairColor: cbeff.BiographicalInformationHairColor(data[8]),
+		},
+		Expression: binary.BigEndian.Uint16(data[12:14]),
+		Pose: PoseAngles{
+			Yaw:   poseAngle(data[14]),
+			Pitch: poseAngle(data[15]),
+			Roll:  poseAngle(data[16]),
+		},
+		PoseUncertainty: PoseAngles{
+			Yaw:   poseUncertainty(data[17]),
+			Pitch: poseUncertainty(data[18]),
+			Roll:  poseUncertainty(data[19]),
+		},
+	}
+	copy(face.BiographicalInformation.Properties[:], data[9:12])
+
+	offset := faceInformationFixed
+	for i := 0; i < points; i++ {
+		el := data[offset : offset+featurePointLength]
+		face.FeaturePoints = append(face.FeaturePoints, FeaturePoint{
+			Type:  el[0],
+			Major: el[1] >> 4,
+			Minor: el[1] & 0x0F,
+			X:     binary.BigEndian.Uint16(el[2:4]),
+			Y:     binary.BigEndian.Uint16(el[4:6]),
+		})
+		offset += featurePointLength
+	}
+
+	el := data[offset : offset+imageInformationSize]
+	face.Type = FaceImageType(el[0])
+	face.DataType = FaceImageDataType(el[1])
+	face.Width = binary.BigEndian.Uint16(el[2:4])
+	face.Height = binary.BigEndian.Uint16(el[4:6])
+	face.ColorSpace = el[6]
+	face.SourceType = el[7]
+	face.DeviceType = binary.BigEndian.Uint16(el[8:10])
+	face.Quality = binary.BigEndian.Uint16(el[10:12])
+	face.Data = data[offset+imageInformationSize:]
+
+	return &face, length, nil
+}
+
+// Decode a pose angle, which is encoded in 2 degree steps from -180, offset
+// by one so that 0 means unspecified.
+func poseAngle(b byte) *int {
+	if b == 0 {
+		return nil
+	}
+	angle := (int(b)-1)*2 - 180
+	return &angle
+}
+
+// Decode a pose angle uncertainty, which is encoded in 2 degree steps from
+// 0, offset by one so that 0 means unspecified.
+func poseUncertainty(b byte) *int {
+	if b == 0 {
+		return nil
+	}
+	uncertainty := (int(b) - 1) * 2
+	return &uncertainty
+}
+
+// CBEFFFaceImages will read the INCITS 385 Facial Image Record out of a
+// facial CBEFF, such as the one returned by a Token's Facial. This consumes
+// the CBEFF's Reader.
+func CBEFFFaceImages(c *cbeff.CBEFF) ([]FaceImage, error) {
+	if !c.Header.BiometricType.Equal(cbeff.BiometricTypeFacial) {
+		return nil, fmt.Errorf("cbeff: Header.BiometricType isn't Facial")
+	}
+	if c.Header.BDBFormatOwner != formatOwnerINCITS || c.Header.BDBFormatType != formatTypeFace {
+		return nil, fmt.Errorf("cbeff: biometric data block isn't an INCITS 385 facial record")
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Reader, int64(c.Header.BDBLength)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(c.Header.BDBLength) {
+		return nil, fmt.Errorf("cbeff: biometric data block is truncated")
+	}
+	return ParseFaceImages(data)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,274 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package biometrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+
+	"pault.ag/go/cbeff"
+)
+
+var (
+	// The CBEFF file contains iris images.
+	BiometricTypeIris = cbeff.BiometricType{0x00, 0x00, 0x10}
+)
+
+const (
+	// CBEFF BDB Format Owner and Type of an ISO/IEC 19794-6 iris image
+	// record, as used on a PIV.
+	formatOwnerISO uint16 = 0x0101
+	formatTypeIris uint16 = 0x0009
+
+	irisRecordFixed         = 16
+	irisRepresentationFixed = 52
+	irisQualityBlockLength  = 5
+)
+
+// EyeLabel is which eye an IrisImage is of.
+type EyeLabel uint8
+
+const (
+	EyeUnknown EyeLabel = 0x00
+	EyeRight   EyeLabel = 0x01
+	EyeLeft    EyeLabel = 0x02
+)
+
+// IrisImageFormat is the encoding of the IrisImage Data.
+type IrisImageFormat uint8
+
+const (
+	IrisImageFormatMonoRaw      IrisImageFormat = 0x02
+	IrisImageFormatRGBRaw       IrisImageFormat = 0x04
+	IrisImageFormatMonoJPEG     IrisImageFormat = 0x06
+	IrisImageFormatRGBJPEG      IrisImageFormat = 0x08
+	IrisImageFormatMonoJPEG2000 IrisImageFormat = 0x0A
+	IrisImageFormatRGBJPEG2000  IrisImageFormat = 0x0C
+	IrisImageFormatMonoPNG      IrisImageFormat = 0x0E
+	IrisImageFormatRGBPNG       IrisImageFormat = 0x10
+)
+
+// IrisQuality is a single quality score of an IrisImage, and the algorithm
+// that computed it.
+type IrisQuality struct {
+	Score             uint8
+	AlgorithmVendorID uint16
+	AlgorithmID       uint16
+}
+
+// IrisImage is a single image of an eye.
+type IrisImage struct {
+	// Time the image was captured, or the zero time if unknown.
+	CaptureTime time.Time
+
+	CaptureDeviceTechnology uint8
+	CaptureDeviceVendorID   uint16
+	CaptureDeviceTypeID     uint16
+
+	Qualities []IrisQuality
+
+	RepresentationNumber uint16
+	Eye                  EyeLabel
+
+	// Image type from ISO/IEC 19794-6 Table 4, such as 7 for a cropped
+	// and masked image, which is what a PIV will have.
+	ImageType uint8
+
+	Format     IrisImageFormat
+	Properties uint8
+
+	Width, Height uint16
+	BitDepth      uint8
+
+	// Range of the image, roll angle of the eye and its uncertainty, and
+	// the bounds of the iris center and diameter, in the units defined by
+	// ISO/IEC 19794-6. These are 0 or 0xFFFF when undefined.
+	Range                uint16
+	RollAngle            uint16
+	RollAngleUncertainty uint16
+	IrisCenterSmallestX  uint16
+	IrisCenterLargestX   uint16
+	IrisCenterSmallestY  uint16
+	IrisCenterLargestY   uint16
+	IrisDiameterSmallest uint16
+	IrisDiameterLargest  uint16
+
+	// Encoded image, in the given Format.
+	Data []byte
+}
+
+// IrisImages is an ISO/IEC 19794-6:2011 Iris Image Record, which is what
+// is stored in the PIV iris biometric.
+type IrisImages struct {
+	// Number of distinct eyes in the Images.
+	Eyes uint8
+
+	Images []IrisImage
+}
+
+// ParseIrisImages will parse an ISO/IEC 19794-6:2011 Iris Image Record.
+func ParseIrisImages(data []byte) (*IrisImages, error) {
+	if len(data) < irisRecordFixed {
+		return nil, fmt.Errorf("cbeff: iris record is too short")
+	}
+	if !bytes.Equal(data[:4], []byte{'I', 'I', 'R', 0x00}) {
+		return nil, fmt.Errorf("cbeff: iris record format isn't IIR\\0")
+	}
+	if !bytes.Equal(data[4:8], []byte{'0', '2', '0', 0x00}) {
+		return nil, fmt.Errorf("cbeff: iris record version isn't 020\\0")
+	}
+	if int(binary.BigEndian.Uint32(data[8:12])) != len(data) {
+		return nil, fmt.Errorf("cbeff: iris record length disagrees with the data")
+	}
+	if data[14] != 0x00 {
+		return nil, fmt.Errorf("cbeff: iris record is certified, which isn't supported")
+	}
+
+	representations := int(binary.BigEndian.Uint16(data[12:14]))
+	ret := IrisImages{Eyes: data[15]}
+
+	rest := data[irisRecordFixed:]
+	for i := 0; i < representations; i++ {
+		image, n, err := parseIrisImage(rest)
+		if err != nil {
+			return nil, err
+		}
+		ret.Images = append(ret.Images, *image)
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cbeff: iris record has trailing data")
+	}
+
+	return &ret, nil
+}
+
+// Parse a single Iris Representation, returning the number of bytes it
+// took up.
+func parseIrisImage(data []byte) (*IrisImage, int, error) {
+	if len(data) < 4 {
+		return nil, 0, fmt.Errorf("cbeff: iris representation is truncated")
+	}
+	length := int(binary.BigEndian.Uint32(data[0:4]))
+	if length < irisRepresentationFixed || length > len(data) {
+		return nil, 0, fmt.Errorf("cbeff: iris representation length is invalid")
+	}
+	data = data[:length]
+
+	image := IrisImage{
+		CaptureTime:             irisCaptureTime(data[4:13]),
+		CaptureDeviceTechnology: data[13],
+		CaptureDeviceVendorID:   binary.BigEndian.Uint16(data[14:16]),
+		CaptureDeviceTypeID:     binary.BigEndian.Uint16(data[16:18]),
+	}
+
+	qualities := int(data[18])
+	offset := 19
+	if length < irisRepresentationFixed+qualities*irisQualityBlockLength {
+		return nil, 0, fmt.Errorf("cbeff: iris representation is truncated")
+	}
+	for i := 0; i < qualities; i++ {
+		el := data[offset : offset+irisQualityBlockLength]
+		image.Qualities = append(image.Qualities, IrisQuality{
+			Score:             el[0],
+			AlgorithmVendorID: binary.BigEndian.Uint16(el[1:3]),
+			AlgorithmID:       binary.BigEndian.Uint16(el[3:5]),
+		})
+		offset += irisQualityBlockLength
+	}
+
+	el := data[offset:]
+	image.RepresentationNumber = binary.BigEndian.Uint16(el[0:2])
+	image.Eye = EyeLabel(el[2])
+	image.ImageType = el[3]
+	image.Format = IrisImageFormat(el[4])
+	image.Properties = el[5]
+	image.Width = binary.BigEndian.Uint16(el[6:8])
+	image.Height = binary.BigEndian.Uint16(el[8:10])
+	image.BitDepth = el[10]
+	image.Range = binary.BigEndian.Uint16(el[11:13])
+	image.RollAngle = binary.BigEndian.Uint16(el[13:15])
+	image.RollAngleUncertainty = binary.BigEndian.Uint16(el[15:17])
+	image.IrisCenterSmallestX = binary.BigEndian.Uint16(el[17:19])
+	image.IrisCenterLargestX = binary.BigEndian.Uint16(el[19:21])
+	image.IrisCenterSmallestY = binary.BigEndian.Uint16(el[21:23])
+	image.IrisCenterLargestY = binary.BigEndian.Uint16(el[23:25])
+	image.IrisDiameterSmallest = binary.BigEndian.Uint16(el[25:27])
+	image.IrisDiameterLargest = binary.BigEndian.Uint16(el[27:29])
+
+	imageLength := int(binary.BigEndian.Uint32(el[29:33]))
+	if imageLength != len(el)-33 {
+		return nil, 0, fmt.Errorf("cbeff: iris image length disagrees with the representation length")
+	}
+	image.Data = el[33:]
+
+	return &image, length, nil
+}
+
+// Decode the 9 byte capture date and time of an Iris Representation. Any
+// unset field means the time is unknown.
+func irisCaptureTime(data []byte) time.Time {
+	year := binary.BigEndian.Uint16(data[0:2])
+	millisecond := binary.BigEndian.Uint16(data[7:9])
+	if year == 0xFFFF || data[2] == 0xFF || data[3] == 0xFF {
+		return time.Time{}
+	}
+	for _, el := range data[4:7] {
+		if el == 0xFF {
+			return time.Date(int(year), time.Month(data[2]), int(data[3]), 0, 0, 0, 0, time.UTC)
+		}
+	}
+	if millisecond == 0xFFFF {
+		millisecond = 0
+	}
+	return time.Date(
+		int(year), time.Month(data[2]), int(data[3]),
+		int(data[4]), int(data[5]), int(data[6]),
+		int(millisecond)*int(time.Millisecond), time.UTC,
+	)
+}
+
+// CBEFFIrisImages will read the ISO/IEC 19794-6 Iris Image Record out of an
+// iris CBEFF, such as the one returned by a Token's Iris. This consumes the
+// CBEFF's Reader.
+func CBEFFIrisImages(c *cbeff.CBEFF) (*IrisImages, error) {
+	if !c.Header.BiometricType.Equal(BiometricTypeIris) {
+		return nil, fmt.Errorf("cbeff: Header.BiometricType isn't Iris")
+	}
+	if c.Header.BDBFormatOwner != formatOwnerISO || c.Header.BDBFormatType != formatTypeIris {
+		return nil, fmt.Errorf("cbeff: biometric data block isn't an ISO/IEC 19794-6 iris record")
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Reader, int64(c.Header.BDBLength)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(c.Header.BDBLength) {
+		return nil, fmt.Errorf("cbeff: biometric data block is truncated")
+	}
+	return ParseIrisImages(data)
+}
+
+// vim: foldmethod=marker
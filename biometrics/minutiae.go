@@ -0,0 +1,225 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package biometrics
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+
+	"pault.ag/go/cbeff"
+)
+
+const (
+	// CBEFF BDB Format Owner and Type of an INCITS 378 minutiae record,
+	// as used on a PIV.
+	formatOwnerINCITS   uint16 = 0x001B
+	formatTypeMinutiae  uint16 = 0x0201
+	minutiaeRecordFixed        = 26
+	minutiaeViewFixed          = 4
+	minutiaLength              = 6
+)
+
+// MinutiaType is the kind of ridge feature a Minutia marks.
+type MinutiaType uint8
+
+const (
+	MinutiaOther       MinutiaType = 0x00
+	MinutiaRidgeEnding MinutiaType = 0x01
+	MinutiaBifurcation MinutiaType = 0x02
+)
+
+// Minutia is a single ridge feature of a finger.
+type Minutia struct {
+	Type MinutiaType
+
+	// Location of the Minutia in the image, in pixels from the top left.
+	X, Y uint16
+
+	// Angle of the Minutia, in degrees counter-clockwise from horizontal.
+	Angle int
+
+	// Quality of the Minutia from 1 to 100, or 0 if not reported.
+	Quality uint8
+}
+
+// FingerView is the set of Minutiae from a single image of a finger.
+type FingerView struct {
+	// Finger position code, from INCITS 378 Table 5, such as 2 for the
+	// right index finger.
+	Position uint8
+
+	ViewNumber     uint8
+	ImpressionType uint8
+
+	// Quality of the finger image from 0 to 100.
+	Quality uint8
+
+	Minutiae []Minutia
+
+	// Raw extended data, such as ridge counts or core and delta data. This
+	// is nil if there is none.
+	ExtendedData []byte
+}
+
+// Minutiae is an INCITS 378 Finger Minutiae Record, which is what is
+// stored in the PIV fingerprint biometric.
+type Minutiae struct {
+	// CBEFF Product Identifier of the product that created the record.
+	ProductOwner uint16
+	ProductType  uint16
+
+	// Compliance bits and ID of the capture equipment.
+	CaptureEquipmentCompliance uint8
+	CaptureEquipmentID         uint16
+
+	// Size of the image the Minutiae were extracted from, in pixels, and
+	// its resolution, in pixels per centimeter.
+	Width, Height            uint16
+	XResolution, YResolution uint16
+
+	Views []FingerView
+}
+
+// ParseMinutiae will parse an INCITS 378 Finger Minutiae Record.
+func ParseMinutiae(data []byte) (*Minutiae, error) {
+	if len(data) < minutiaeRecordFixed {
+		return nil, fmt.Errorf("cbeff: minutiae record is too short")
+	}
+	if !bytes.Equal(data[:4], []byte{'F', 'M', 'R', 0x00}) {
+		return nil, fmt.Errorf("cbeff: minutiae record format isn't FMR\\0")
+	}
+	if !bytes.Equal(data[4:8], []byte{' ', '2', '0', 0x00}) {
+		return nil, fmt.Errorf("cbeff: minutiae record version isn't 20\\0")
+	}
+
+	// Records longer than 0xFFFF bytes have a zero length, followed by
+	// a 4 byte length, shifting everything after it.
+	length := int(binary.BigEndian.Uint16(data[8:10]))
+	offset := 10
+	if length == 0 {
+		if len(data) < minutiaeRecordFixed+4 {
+			return nil, fmt.Errorf("cbeff: minutiae record is too short")
+		}
+		length = int(binary.BigEndian.Uint32(data[10:14]))
+		offset = 14
+	}
+	if length != len(data) {
+		return nil, fmt.Errorf("cbeff: minutiae record length disagrees with the data")
+	}
+
+	header := data[offset:]
+	ret := Minutiae{
+		ProductOwner:               binary.BigEndian.Uint16(header[0:2]),
+		ProductType:                binary.BigEndian.Uint16(header[2:4]),
+		CaptureEquipmentCompliance: header[4] >> 4,
+		CaptureEquipmentID:         binary.BigEndian.Uint16(header[4:6]) & 0x0FFF,
+		Width:                      binary.BigEndian.Uint16(header[6:8]),
+		Height:                     binary.BigEndian.Uint16(header[8:10]),
+		XResolution:                binary.BigEndian.Uint16(header[10:12]),
+		YResolution:                binary.BigEndian.Uint16(header[12:14]),
+	}
+	views := int(header[14])
+
+	rest := header[16:]
+	for i := 0; i < views; i++ {
+		view, n, err := parseFingerView(rest)
+		if err != nil {
+			return nil, err
+		}
+		ret.Views = append(ret.Views, *view)
+		rest = rest[n:]
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cbeff: minutiae record has trailing data")
+	}
+
+	return &ret, nil
+}
+
+// Parse a single Finger View Record, returning the number of bytes it
+// took up.
+func parseFingerView(data []byte) (*FingerView, int, error) {
+	if len(data) < minutiaeViewFixed {
+		return nil, 0, fmt.Errorf("cbeff: finger view is truncated")
+	}
+
+	view := FingerView{
+		Position:       data[0],
+		ViewNumber:     data[1] >> 4,
+		ImpressionType: data[1] & 0x0F,
+		Quality:        data[2],
+	}
+	count := int(data[3])
+	offset := minutiaeViewFixed
+
+	if len(data) < offset+count*minutiaLength+2 {
+		return nil, 0, fmt.Errorf("cbeff: finger view is truncated")
+	}
+	for i := 0; i < count; i++ {
+		el := data[offset : offset+minutiaLength]
+		view.Minutiae = append(view.Minutiae, Minutia{
+			Type:    MinutiaType(el[0] >> 6),
+			X:       binary.BigEndian.Uint16(el[0:2]) & 0x3FFF,
+			Y:       binary.BigEndian.Uint16(el[2:4]) & 0x3FFF,
+			Angle:   int(el[4]) * 2,
+			Quality: el[5],
+		})
+		offset += minutiaLength
+	}
+
+	extended := int(binary.BigEndian.Uint16(data[offset : offset+2]))
+	offset += 2
+	if extended != 0 {
+		if len(data) < offset+extended {
+			return nil, 0, fmt.Errorf("cbeff: finger view extended data is truncated")
+		}
+		view.ExtendedData = data[offset : offset+extended]
+		offset += extended
+	}
+
+	return &view, offset, nil
+}
+
+// CBEFFMinutiae will read the INCITS 378 Finger Minutiae Record out of a
+// fingerprint CBEFF, such as the one returned by a Token's Fingerprints.
+// This consumes the CBEFF's Reader.
+func CBEFFMinutiae(c *cbeff.CBEFF) (*Minutiae, error) {
+	if !c.Header.BiometricType.Equal(cbeff.BiometricTypeFingerprint) {
+		return nil, fmt.Errorf("cbeff: Header.BiometricType isn't Fingerprint")
+	}
+	if c.Header.BDBFormatOwner != formatOwnerINCITS || c.Header.BDBFormatType != formatTypeMinutiae {
+		return nil, fmt.Errorf("cbeff: biometric data block isn't an INCITS 378 minutiae record")
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(c.Reader, int64(c.Header.BDBLength)))
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != int(c.Header.BDBLength) {
+		return nil, fmt.Errorf("cbeff: biometric data block is truncated")
+	}
+	return ParseMinutiae(data)
+}
+
+// vim: foldmethod=marker
@@ -22,34 +22,51 @@ package biometrics // import "pault.ag/go/piv/biometrics"
 
 import (
 	"bytes"
-	"encoding/asn1"
 	"fmt"
 
 	"pault.ag/go/cbeff"
+	"pault.ag/go/piv/tlv"
+)
+
+const (
+	// Tag of the container wrapping data objects read off a PIV.
+	tagContainer tlv.Tag = 0x53
+
+	// Tag of the biometric data block within the container.
+	tagBiometric tlv.Tag = 0xBC
 )
 
 // ParseTLVCBEFF will consume CBEFF, as read from a PIV. This format is wrapped
-// in an ASN.1 "TLV" structure, unique to PIV, not CBEFF.
+// in a BER-TLV structure, unique to PIV, not CBEFF.
 // This will unwrap the data inside the structure, and attempt to parse
-// the underlying CBEFF data.
+// the underlying CBEFF data. This does not check the CBEFF signature
+// block, which is done by VerifyTLVCBEFF.
 func ParseTLVCBEFF(data []byte) (*cbeff.CBEFF, error) {
-	rv := asn1.RawValue{}
-	rest, err := asn1.Unmarshal(data, &rv)
+	biometric, err := unwrapTLVCBEFF(data)
 	if err != nil {
 		return nil, err
 	}
+	return cbeff.Parse(bytes.NewReader(biometric))
+}
 
-	if len(rest) != 0 {
-		return nil, fmt.Errorf("cbeff: ASN.1 has trailing data after the CBEFF")
+// unwrapTLVCBEFF will return the raw CBEFF inside the PIV BER-TLV
+// structure.
+func unwrapTLVCBEFF(data []byte) ([]byte, error) {
+	body, err := tlv.Unwrap(data, tagContainer, tlv.Lenient)
+	if err != nil {
+		return nil, fmt.Errorf("cbeff: %w", err)
 	}
 
-	rvN := asn1.RawValue{}
-	_, err = asn1.Unmarshal(rv.Bytes, &rvN)
+	elements, err := tlv.DecodeMode(body, tlv.Lenient)
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("cbeff: container: %w", err)
 	}
 
-	return cbeff.Parse(bytes.NewReader(rvN.Bytes))
+	biometric := elements.Find(tagBiometric)
+	if biometric == nil {
+		return nil, fmt.Errorf("cbeff: container has no biometric data block (tag %s)", tagBiometric)
+	}
+	return biometric.Value, nil
 }
 
 // vim: foldmethod=marker
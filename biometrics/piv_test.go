@@ -0,0 +1,57 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package biometrics_test
+
+import (
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv/biometrics"
+	"pault.ag/go/piv/tlv"
+)
+
+func TestParseTLVCBEFFSyntaxError(t *testing.T) {
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		offset int
+	}{
+		{"wrong tag", []byte{0x7E, 0x00}, 0},
+		{"trailing data", []byte{0x53, 0x00, 0x53, 0x00}, 2},
+		{"truncated block", []byte{0x53, 0x02, 0xBC, 0x05}, 2},
+	} {
+		_, err := biometrics.ParseTLVCBEFF(test.data)
+		var syntax tlv.SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%s: got %v, want a tlv.SyntaxError", test.name, err)
+			continue
+		}
+		if syntax.Offset != test.offset {
+			t.Errorf("%s: got offset %d, want %d", test.name, syntax.Offset, test.offset)
+		}
+	}
+
+	if _, err := biometrics.ParseTLVCBEFF([]byte{0x53, 0x02, 0xFE, 0x00}); err == nil {
+		t.Error("container without a biometric data block was accepted")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,89 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package biometrics
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+
+	"pault.ag/go/cbeff"
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/internal/cms"
+)
+
+var (
+	oidPIVBiometricObject = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 2}
+)
+
+const (
+	// Value of the CBEFF SBH Security Options for a signed, unencrypted
+	// biometric, from SP 800-76-2, Table 7.
+	securityOptionsSigned uint8 = 0x0D
+)
+
+// VerifyTLVCBEFF will check the CBEFF signature block of a biometric, as
+// read from a PIV, and ensure the signer is a PIV content signer, returning
+// the signer's Certificate.
+//
+// The signature block is a detached CMS SignedData over the CBEFF header and
+// biometric data block, as defined in SP 800-76-2.
+func VerifyTLVCBEFF(data []byte, opts piv.VerifyOptions) (*piv.Certificate, error) {
+	biometric, err := unwrapTLVCBEFF(data)
+	if err != nil {
+		return nil, err
+	}
+
+	header := cbeff.Header{}
+	if err := binary.Read(bytes.NewReader(biometric), binary.BigEndian, &header); err != nil {
+		return nil, err
+	}
+	if err := header.Validate(); err != nil {
+		return nil, err
+	}
+
+	headerLength := binary.Size(header)
+	signedLength := headerLength + int(header.BDBLength)
+	if len(biometric) != signedLength+int(header.SBLength) {
+		return nil, fmt.Errorf("cbeff: biometric length disagrees with CBEFF lengths")
+	}
+	if header.SBLength == 0 || header.SBHSecurityOptions != securityOptionsSigned {
+		return nil, fmt.Errorf("cbeff: biometric isn't signed")
+	}
+
+	sd, err := cms.Parse(biometric[signedLength:])
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentType.Equal(oidPIVBiometricObject) {
+		return nil, fmt.Errorf("cbeff: signature isn't over a PIV biometric")
+	}
+
+	cert, err := sd.Verify(biometric[:signedLength], nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return piv.VerifyContentSigner(cert, opts)
+}
+
+// vim: foldmethod=marker
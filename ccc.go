@@ -0,0 +1,192 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"fmt"
+
+	"pault.ag/go/piv/tlv"
+)
+
+// CardCapabilityContainer, or CCC, is a mandatory PIV data object that
+// describes the card's data model and capabilities. This is a holdover from
+// the GSC-IS, and is mostly useful for figuring out who made a card, and
+// how it was put together.
+type CardCapabilityContainer struct {
+	// Entire CCC, as read from the card, without the outer container TLV
+	// wrapper.
+	Raw []byte
+
+	// 21 byte Card Identifier, made up of the GSC-RID, the manufacturer
+	// ID, the card type and a 14 byte card ID.
+	CardIdentifier []byte
+
+	// Version of the Capability Container, and of the Capability Grammar
+	// used to write it.
+	CapabilityContainerVersion byte
+	CapabilityGrammarVersion   byte
+
+	// Applications CardURLs, each pointing at an application on the card.
+	// This may be present more than once, and empty elements are skipped.
+	ApplicationsCardURLs [][]byte
+
+	// Non-zero if the card supports PKCS #15.
+	PKCS15 byte
+
+	// Registered Data Model number. PIV Cards use 0x10.
+	RegisteredDataModelNumber byte
+
+	// Access Control Rule Table.
+	AccessControlRuleTable []byte
+
+	// Optional elements, which are usually present but empty.
+	CardAPDUs        []byte
+	RedirectionTag   []byte
+	CapabilityTuples []byte
+	StatusTuples     []byte
+	NextCCC          []byte
+
+	// Optional Extended Application CardURLs. This may be present more
+	// than once.
+	ExtendedApplicationCardURLs [][]byte
+
+	// Optional Security Object Buffer.
+	SecurityObjectBuffer []byte
+}
+
+// Tags of the data elements in the CCC, as defined in SP 800-73-4.
+const (
+	cccTagCardIdentifier             tlv.Tag = 0xF0
+	cccTagContainerVersion           tlv.Tag = 0xF1
+	cccTagGrammarVersion             tlv.Tag = 0xF2
+	cccTagApplicationsCardURL        tlv.Tag = 0xF3
+	cccTagPKCS15                     tlv.Tag = 0xF4
+	cccTagRegisteredDataModelNumber  tlv.Tag = 0xF5
+	cccTagAccessControlRuleTable     tlv.Tag = 0xF6
+	cccTagCardAPDUs                  tlv.Tag = 0xF7
+	cccTagRedirectionTag             tlv.Tag = 0xFA
+	cccTagCapabilityTuples           tlv.Tag = 0xFB
+	cccTagStatusTuples               tlv.Tag = 0xFC
+	cccTagNextCCC                    tlv.Tag = 0xFD
+	cccTagExtendedApplicationCardURL tlv.Tag = 0xE3
+	cccTagSecurityObjectBuffer       tlv.Tag = 0xB4
+	cccTagErrorDetectionCode         tlv.Tag = 0xFE
+)
+
+// cccByte will return the single byte value of a CCC element.
+func cccByte(el tlv.TLV, name string) (byte, error) {
+	if len(el.Value) != 1 {
+		return 0, fmt.Errorf("piv: CCC %s isn't 1 byte", name)
+	}
+	return el.Value[0], nil
+}
+
+// ParseCardCapabilityContainer will parse the CCC data object, as read from
+// the card.
+func ParseCardCapabilityContainer(data []byte) (*CardCapabilityContainer, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := CardCapabilityContainer{Raw: data}
+	var seenIdentifier, seenContainerVersion, seenGrammarVersion, seenDataModel bool
+
+	for i, el := range elements {
+		switch el.Tag {
+		case cccTagCardIdentifier:
+			if len(el.Value) != 21 {
+				return nil, fmt.Errorf("piv: CCC card identifier isn't 21 bytes")
+			}
+			ret.CardIdentifier = el.Value
+			seenIdentifier = true
+		case cccTagContainerVersion:
+			ret.CapabilityContainerVersion, err = cccByte(el, "capability container version")
+			if err != nil {
+				return nil, err
+			}
+			seenContainerVersion = true
+		case cccTagGrammarVersion:
+			ret.CapabilityGrammarVersion, err = cccByte(el, "capability grammar version")
+			if err != nil {
+				return nil, err
+			}
+			seenGrammarVersion = true
+		case cccTagApplicationsCardURL:
+			if len(el.Value) != 0 {
+				ret.ApplicationsCardURLs = append(ret.ApplicationsCardURLs, el.Value)
+			}
+		case cccTagPKCS15:
+			ret.PKCS15, err = cccByte(el, "PKCS #15 flag")
+			if err != nil {
+				return nil, err
+			}
+		case cccTagRegisteredDataModelNumber:
+			ret.RegisteredDataModelNumber, err = cccByte(el, "registered data model number")
+			if err != nil {
+				return nil, err
+			}
+			seenDataModel = true
+		case cccTagAccessControlRuleTable:
+			ret.AccessControlRuleTable = el.Value
+		case cccTagCardAPDUs:
+			ret.CardAPDUs = el.Value
+		case cccTagRedirectionTag:
+			ret.RedirectionTag = el.Value
+		case cccTagCapabilityTuples:
+			ret.CapabilityTuples = el.Value
+		case cccTagStatusTuples:
+			ret.StatusTuples = el.Value
+		case cccTagNextCCC:
+			ret.NextCCC = el.Value
+		case cccTagExtendedApplicationCardURL:
+			ret.ExtendedApplicationCardURLs = append(ret.ExtendedApplicationCardURLs, el.Value)
+		case cccTagSecurityObjectBuffer:
+			ret.SecurityObjectBuffer = el.Value
+		case cccTagErrorDetectionCode:
+			if i != len(elements)-1 {
+				return nil, fmt.Errorf("piv: CCC has trailing data after the error detection code")
+			}
+		default:
+			return nil, fmt.Errorf("piv: CCC has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenIdentifier:
+		return nil, fmt.Errorf("piv: CCC is missing the card identifier")
+	case !seenContainerVersion:
+		return nil, fmt.Errorf("piv: CCC is missing the capability container version")
+	case !seenGrammarVersion:
+		return nil, fmt.Errorf("piv: CCC is missing the capability grammar version")
+	case !seenDataModel:
+		return nil, fmt.Errorf("piv: CCC is missing the registered data model number")
+	}
+
+	return &ret, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,56 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"bytes"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestParseCardCapabilityContainer(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{UUID: testUUID})
+
+	ccc, err := token.CardCapabilityContainer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(ccc.CardIdentifier) != 21 || !bytes.HasSuffix(ccc.CardIdentifier, testUUID[:14]) {
+		t.Fatalf("got Card Identifier %x", ccc.CardIdentifier)
+	}
+	if ccc.RegisteredDataModelNumber != 0x10 {
+		t.Fatalf("got data model %#x", ccc.RegisteredDataModelNumber)
+	}
+	if ccc.CapabilityContainerVersion != 0x21 || ccc.CapabilityGrammarVersion != 0x21 {
+		t.Fatalf("got versions %#x, %#x", ccc.CapabilityContainerVersion, ccc.CapabilityGrammarVersion)
+	}
+	if len(ccc.ApplicationsCardURLs) != 0 {
+		t.Fatalf("got %d empty Applications CardURLs", len(ccc.ApplicationsCardURLs))
+	}
+
+	if _, err := piv.ParseCardCapabilityContainer([]byte{0xF5, 0x02, 0x10, 0x10}); err == nil {
+		t.Fatal("two byte data model number was parsed")
+	}
+}
+
+// vim: foldmethod=marker
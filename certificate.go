@@ -23,6 +23,7 @@ package piv
 import (
 	"crypto/x509"
 	"crypto/x509/pkix"
+	"fmt"
 
 	"pault.ag/go/fasc"
 	"pault.ag/go/othername"
@@ -41,7 +42,8 @@ type Name struct {
 // Certificate is an extension of the built-in crypto/x509.Certificate type.
 // This contains the Certificate as an anonymous member of the piv.Certificate
 // struct, as well as some PIV peculiar OID values, such as the Microsoft UPN
-// (used for smartcard login), FASCs and if the cardholder has a completed NACI.
+// (used for smartcard login), FASCs, the Card UUID and if the cardholder has a
+// completed NACI.
 type Certificate struct {
 	*x509.Certificate
 
@@ -70,30 +72,80 @@ type Certificate struct {
 	// but still written to new Certificates.
 	FASCs []fasc.FASC
 
+	// Card UUID of the card this Certificate was issued to, taken from the
+	// urn:uuid URI in the SAN. This is required in the PIV Authentication
+	// and Card Authentication Certificates, and is the preferred identifier
+	// for the card, in place of the FASC. This is nil if not present.
+	CardUUID *UUID
+
 	// Standards applied to the issuance of this Certificate, such as the
 	// amount of checking into a Person's identity, if this was even a Person,
 	// or if the key is stored on a hardware token.
+	//
+	// These are the policies asserted by the Certificate, and have not been
+	// validated or mapped into any particular trust domain. See Verify and
+	// EffectivePolicies.
 	Policies Policies
+
+	// Any PIV peculiar fields above that couldn't be parsed, since the
+	// extension or SAN they're taken from was malformed. These fields are
+	// left unset, and the rest of the Certificate is parsed as usual.
+	ParseWarnings []ParseWarning
+}
+
+// ParseWarning describes a PIV peculiar field of a Certificate that was left
+// unset by NewCertificate, since it couldn't be parsed.
+type ParseWarning struct {
+	// Name of the Certificate field that was left unset, such as
+	// "CompletedNACI".
+	Field string
+
+	// Error from parsing the field.
+	Err error
+}
+
+// Error implements the error interface.
+func (w ParseWarning) Error() string {
+	return fmt.Sprintf("piv: malformed %s: %s", w.Field, w.Err)
+}
+
+// Unwrap returns the Error from parsing the field.
+func (w ParseWarning) Unwrap() error {
+	return w.Err
 }
 
 // NewCertificate will create a piv.Certificate from a standard
 // crypto/x509.Certificate, parsing the various PIV peculiar fields.
+//
+// Certificates in the wild aren't always well formed, so a PIV peculiar
+// field that can't be parsed is left unset, and recorded in ParseWarnings,
+// rather than failing the whole Certificate.
 func NewCertificate(cert *x509.Certificate) (*Certificate, error) {
 	ret := Certificate{Certificate: cert}
 	var err error
 
-	ret.CompletedNACI = HasNACI(cert)
+	warn := func(field string, err error) {
+		ret.ParseWarnings = append(ret.ParseWarnings, ParseWarning{Field: field, Err: err})
+	}
+
+	if ret.CompletedNACI, err = HasNACI(cert); err != nil {
+		warn("CompletedNACI", err)
+	}
 
 	ret.Subject = Name{Name: cert.Subject}
 	ret.Subject.UserID = UserIDs(cert.Subject)
 
-	ret.PrincipalNames, err = othername.UPNs(cert)
-	if err != nil {
-		return nil, err
+	if ret.PrincipalNames, err = othername.UPNs(cert); err != nil {
+		ret.PrincipalNames = nil
+		warn("PrincipalNames", err)
 	}
-	ret.FASCs, err = othername.FASCs(cert)
-	if err != nil {
-		return nil, err
+	if ret.FASCs, err = othername.FASCs(cert); err != nil {
+		ret.FASCs = nil
+		warn("FASCs", err)
+	}
+	if ret.CardUUID, err = cardUUID(cert); err != nil {
+		ret.CardUUID = nil
+		warn("CardUUID", err)
 	}
 
 	ret.Policies = ParsePolicies(ret.PolicyIdentifiers)
@@ -0,0 +1,106 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+)
+
+var oidNACI = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 9, 1}
+
+// selfSigned creates a throwaway self signed Certificate carrying the
+// extensions.
+func selfSigned(t *testing.T, extensions ...pkix.Extension) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:    big.NewInt(1),
+		Subject:         pkix.Name{CommonName: "Test"},
+		NotBefore:       time.Now().Add(-time.Hour),
+		NotAfter:        time.Now().Add(time.Hour),
+		ExtraExtensions: extensions,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestNACI(t *testing.T) {
+	cert, err := piv.NewCertificate(selfSigned(t, pkix.Extension{Id: oidNACI, Value: []byte{0x01, 0x01, 0xFF}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CompletedNACI == nil || !*cert.CompletedNACI || len(cert.ParseWarnings) != 0 {
+		t.Fatalf("got %v, %v", cert.CompletedNACI, cert.ParseWarnings)
+	}
+
+	cert, err = piv.NewCertificate(selfSigned(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CompletedNACI != nil {
+		t.Fatal("NACI set without the extension")
+	}
+}
+
+func TestMalformedNACI(t *testing.T) {
+	cert, err := piv.NewCertificate(selfSigned(t, pkix.Extension{Id: oidNACI, Value: []byte{0x04, 0x00}}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cert.CompletedNACI != nil {
+		t.Fatal("malformed NACI was parsed")
+	}
+	if len(cert.ParseWarnings) != 1 || cert.ParseWarnings[0].Field != "CompletedNACI" {
+		t.Fatalf("got warnings %v", cert.ParseWarnings)
+	}
+
+	var structural asn1.StructuralError
+	if !errors.As(cert.ParseWarnings[0], &structural) {
+		t.Fatalf("got %v, want an asn1.StructuralError", cert.ParseWarnings[0])
+	}
+
+	if _, err := piv.HasNACI(selfSigned(t, pkix.Extension{Id: oidNACI, Value: []byte{0x01, 0x01, 0xFF, 0x00}})); err == nil {
+		t.Fatal("trailing data after the NACI extension was accepted")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,241 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"encoding/asn1"
+	"fmt"
+	"time"
+
+	"pault.ag/go/fasc"
+	"pault.ag/go/piv/internal/cms"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	oidCHUIDSecurityObject = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 1}
+)
+
+// CHUID, or the Card Holder Unique Identifier, is a mandatory PIV data
+// object that uniquely identifies the card, and by extension, the
+// cardholder. This is most commonly read by Physical Access Control
+// Systems, since it may be read without the PIN.
+type CHUID struct {
+	// Entire CHUID, as read from the card, without the outer container
+	// TLV wrapper.
+	Raw []byte
+
+	// Bytes of the CHUID covered by the IssuerAsymmetricSignature, which
+	// is every element preceding the signature itself.
+	RawContent []byte
+
+	// Optional, deprecated length of the CHUID buffer, used by older Physical
+	// Access Control Systems.
+	BufferLength *uint16
+
+	// Raw FASC-N, as stored on the card.
+	RawFASCN []byte
+
+	// Parsed FASC-N of this card. Just like the FASC-N in the certificates,
+	// this is a legacy identifier.
+	FASC fasc.FASC
+
+	// Optional, deprecated, Organizational Identifier of the issuer.
+	OrganizationalIdentifier []byte
+
+	// Optional, deprecated, DUNS of the issuer.
+	DUNS []byte
+
+	// Card UUID. This must be the same UUID as the urn:uuid in the PIV
+	// Authentication and Card Authentication certificates.
+	GUID UUID
+
+	// Date after which this card is no longer valid. This is day precision,
+	// so the card is valid through the entire day.
+	Expiration time.Time
+
+	// Optional Cardholder UUID, uniquely identifying the cardholder across
+	// any cards they may be issued.
+	CardholderUUID *UUID
+
+	// CMS SignedData over the RawContent, signed by the card issuer.
+	IssuerAsymmetricSignature []byte
+}
+
+// Tags of the data elements in the CHUID, as defined in SP 800-73-4.
+const (
+	chuidTagBufferLength             tlv.Tag = 0xEE
+	chuidTagFASCN                    tlv.Tag = 0x30
+	chuidTagOrganizationalIdentifier tlv.Tag = 0x32
+	chuidTagDUNS                     tlv.Tag = 0x33
+	chuidTagGUID                     tlv.Tag = 0x34
+	chuidTagExpiration               tlv.Tag = 0x35
+	chuidTagCardholderUUID           tlv.Tag = 0x36
+	chuidTagIssuerSignature          tlv.Tag = 0x3E
+	chuidTagErrorDetectionCode       tlv.Tag = 0xFE
+
+	// Tag of the container wrapping data objects read via GET DATA.
+	containerTag tlv.Tag = 0x53
+)
+
+// unwrapContainer will remove the outer container TLV from a PIV data
+// object, if it's present. Depending on the backend, data objects may or
+// may not have this wrapper.
+func unwrapContainer(data []byte) ([]byte, error) {
+	if len(data) == 0 || tlv.Tag(data[0]) != containerTag {
+		return data, nil
+	}
+	return tlv.Unwrap(data, containerTag, tlv.Lenient)
+}
+
+// ParseCHUID will parse the CHUID data object, as read from the card.
+func ParseCHUID(data []byte) (*CHUID, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := CHUID{Raw: data}
+	var seenFASCN, seenGUID, seenExpiration, seenSignature bool
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	for i, el := range elements {
+		switch el.Tag {
+		case chuidTagBufferLength:
+			if len(el.Value) != 2 {
+				return nil, fmt.Errorf("piv: CHUID buffer length isn't 2 bytes")
+			}
+			length := uint16(el.Value[0])<<8 | uint16(el.Value[1])
+			ret.BufferLength = &length
+		case chuidTagFASCN:
+			f, err := fasc.Parse(el.Value)
+			if err != nil {
+				return nil, err
+			}
+			ret.RawFASCN = el.Value
+			ret.FASC = *f
+			seenFASCN = true
+		case chuidTagOrganizationalIdentifier:
+			ret.OrganizationalIdentifier = el.Value
+		case chuidTagDUNS:
+			ret.DUNS = el.Value
+		case chuidTagGUID:
+			if len(el.Value) != 16 {
+				return nil, fmt.Errorf("piv: CHUID GUID isn't 16 bytes")
+			}
+			copy(ret.GUID[:], el.Value)
+			seenGUID = true
+		case chuidTagExpiration:
+			ret.Expiration, err = time.Parse("20060102", string(el.Value))
+			if err != nil {
+				return nil, err
+			}
+			seenExpiration = true
+		case chuidTagCardholderUUID:
+			if len(el.Value) != 16 {
+				return nil, fmt.Errorf("piv: CHUID Cardholder UUID isn't 16 bytes")
+			}
+			uuid := UUID{}
+			copy(uuid[:], el.Value)
+			ret.CardholderUUID = &uuid
+		case chuidTagIssuerSignature:
+			ret.RawContent = data[:el.Offset]
+			ret.IssuerAsymmetricSignature = el.Value
+			seenSignature = true
+		case chuidTagErrorDetectionCode:
+			if i != len(elements)-1 {
+				return nil, fmt.Errorf("piv: CHUID has trailing data after the error detection code")
+			}
+		default:
+			return nil, fmt.Errorf("piv: CHUID has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenFASCN:
+		return nil, fmt.Errorf("piv: CHUID is missing the FASC-N")
+	case !seenGUID:
+		return nil, fmt.Errorf("piv: CHUID is missing the GUID")
+	case !seenExpiration:
+		return nil, fmt.Errorf("piv: CHUID is missing the expiration date")
+	case !seenSignature:
+		return nil, fmt.Errorf("piv: CHUID is missing the issuer asymmetric signature")
+	}
+
+	return &ret, nil
+}
+
+// Verify will check the IssuerAsymmetricSignature over the CHUID, and
+// ensure that it was signed by a PIV content signer that chains to one of
+// the trusted roots. If the signature is valid, the content signer's
+// Certificate is returned.
+//
+// Any intermediates needed to build a path from the content signer to the
+// trusted roots must be in the VerifyOptions, since only the content
+// signer's own Certificate is carried in the signature.
+func (c CHUID) Verify(opts VerifyOptions) (*Certificate, error) {
+	sd, err := cms.Parse(c.IssuerAsymmetricSignature)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sd.ContentType.Equal(oidCHUIDSecurityObject) {
+		return nil, fmt.Errorf("piv: CHUID signature isn't over a CHUID")
+	}
+
+	cert, err := sd.Verify(c.RawContent, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return VerifyContentSigner(cert, opts)
+}
+
+// CheckCertificate will ensure the given Certificate was issued for the
+// card this CHUID was read from, by comparing the card UUID against the
+// urn:uuid SAN, and the FASC-N against any FASC-N SANs.
+//
+// This is only meaningful for the PIV Authentication and Card
+// Authentication certificates, which are required to contain the card
+// UUID. This does not check the CHUID signature, so unless the CHUID has
+// been otherwise verified, this says nothing about the card being genuine.
+func (c CHUID) CheckCertificate(cert *Certificate) error {
+	if cert.CardUUID == nil {
+		return fmt.Errorf("piv: Certificate has no urn:uuid SAN")
+	}
+	if *cert.CardUUID != c.GUID {
+		return fmt.Errorf("piv: Certificate urn:uuid doesn't match the CHUID GUID")
+	}
+
+	for _, f := range cert.FASCs {
+		if f != c.FASC {
+			return fmt.Errorf("piv: Certificate FASC-N doesn't match the CHUID FASC-N")
+		}
+	}
+
+	return nil
+}
+
+// vim: foldmethod=marker
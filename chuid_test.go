@@ -0,0 +1,164 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+// newSoftToken creates a software Token with small keys, since generating
+// 2048 bit keys for every slot makes the tests slow.
+func newSoftToken(t *testing.T, config softtoken.Config) *softtoken.Token {
+	t.Helper()
+	ca, err := softtoken.NewCA(softtoken.CAConfig{Bits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CA = ca
+	config.Bits = 1024
+	token, err := softtoken.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+var (
+	testUUID     = piv.UUID{0x01, 0x23, 0x45, 0x67, 0x89, 0xab, 0x4c, 0xde, 0x8f}
+	testNotAfter = time.Date(2030, time.June, 1, 12, 0, 0, 0, time.UTC)
+)
+
+func TestParseCHUID(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{UUID: testUUID, NotAfter: testNotAfter})
+
+	chuid, err := token.CHUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chuid.GUID != testUUID {
+		t.Fatalf("got GUID %s, want %s", chuid.GUID, testUUID)
+	}
+	if !chuid.Expiration.Equal(time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got expiration %s", chuid.Expiration)
+	}
+	if len(chuid.RawFASCN) != 25 || chuid.CardholderUUID != nil || chuid.BufferLength != nil {
+		t.Fatalf("got FASC-N %x, cardholder UUID %v, buffer length %v",
+			chuid.RawFASCN, chuid.CardholderUUID, chuid.BufferLength)
+	}
+	if !bytes.HasSuffix(chuid.Raw, []byte{0xFE, 0x00}) {
+		t.Fatal("Raw doesn't end with the error detection code")
+	}
+	if !bytes.HasPrefix(chuid.Raw, chuid.RawContent) || len(chuid.IssuerAsymmetricSignature) == 0 {
+		t.Fatal("RawContent isn't the start of the CHUID")
+	}
+
+	// The outer container is optional.
+	again, err := piv.ParseCHUID(chuid.Raw)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.GUID != chuid.GUID {
+		t.Fatal("CHUID without the container parsed differently")
+	}
+}
+
+func TestMalformedCHUID(t *testing.T) {
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"empty", []byte{}},
+		{"truncated", []byte{0x53, 0x05, 0x30, 0x19}},
+		{"unknown tag", []byte{0x99, 0x00}},
+		{"short GUID", []byte{0x34, 0x01, 0x00}},
+		{"bad expiration", []byte{0x35, 0x02, 'n', 'o'}},
+		{"trailing data", []byte{0xFE, 0x00, 0x34, 0x00}},
+	} {
+		if _, err := piv.ParseCHUID(test.data); err == nil {
+			t.Errorf("%s: parsed", test.name)
+		}
+	}
+}
+
+func TestCHUIDCheckCertificate(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{})
+	other := newSoftToken(t, softtoken.Config{})
+
+	chuid, err := token.CHUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := token.AuthenticationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chuid.CheckCertificate(auth); err != nil {
+		t.Fatal(err)
+	}
+	otherAuth, err := other.AuthenticationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := chuid.CheckCertificate(otherAuth); err == nil {
+		t.Fatal("Certificate from another card matched the CHUID")
+	}
+}
+
+func TestCHUIDVerify(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{})
+	chuid, err := token.CHUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	signer, err := chuid.Verify(piv.VerifyOptions{Roots: token.CA().Pool()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signer.Subject.CommonName != "Synthetic PIV Content Signer" {
+		t.Fatalf("got signer %s", signer.Subject)
+	}
+
+	other := newSoftToken(t, softtoken.Config{})
+	if _, err := chuid.Verify(piv.VerifyOptions{Roots: other.CA().Pool()}); err == nil {
+		t.Fatal("CHUID verified against the wrong CA")
+	}
+
+	tampered := *chuid
+	tampered.RawContent = append([]byte{}, chuid.RawContent...)
+	tampered.RawContent[len(tampered.RawContent)-1] ^= 0xFF
+	if _, err := tampered.Verify(piv.VerifyOptions{Roots: token.CA().Pool()}); err == nil {
+		t.Fatal("tampered CHUID verified")
+	}
+
+	tampered = *chuid
+	tampered.IssuerAsymmetricSignature = []byte{0x30, 0x00}
+	if _, err := tampered.Verify(piv.VerifyOptions{Roots: token.CA().Pool()}); err == nil {
+		t.Fatal("CHUID with a malformed signature verified")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,186 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"fmt"
+
+	"pault.ag/go/piv/tlv"
+)
+
+// PINUsagePolicy is the two byte PIN Usage Policy from the Discovery Object,
+// as defined in SP 800-73-4 Part 1, Section 3.3.2. This describes which
+// PINs (or other verification methods) the card accepts, and which PIN the
+// cardholder should be prompted for.
+type PINUsagePolicy [2]byte
+
+// PINType is a PIN that may be presented to the card.
+type PINType int
+
+const (
+	// ApplicationPIN is the PIV Card Application PIN.
+	ApplicationPIN PINType = iota
+
+	// GlobalPIN is the card's Global PIN, shared with other applications
+	// on the card.
+	GlobalPIN
+)
+
+// String returns a human readable name of the PINType.
+func (p PINType) String() string {
+	switch p {
+	case ApplicationPIN:
+		return "PIV Card Application PIN"
+	case GlobalPIN:
+		return "Global PIN"
+	default:
+		return "unknown PIN"
+	}
+}
+
+// Bits of the PIN Usage Policy.
+const (
+	pinPolicyApplicationPIN    = 0x40
+	pinPolicyGlobalPIN         = 0x20
+	pinPolicyOnCardComparison  = 0x10
+	pinPolicyVCI               = 0x08
+	pinPolicyVCIWithoutPairing = 0x04
+	pinPolicyGlobalPINPrimary  = 0x20
+)
+
+// ApplicationPIN returns true if the PIV Card Application PIN satisfies the
+// card's access control rules.
+func (p PINUsagePolicy) ApplicationPIN() bool {
+	return p[0]&pinPolicyApplicationPIN != 0
+}
+
+// GlobalPIN returns true if the Global PIN satisfies the card's access
+// control rules.
+func (p PINUsagePolicy) GlobalPIN() bool {
+	return p[0]&pinPolicyGlobalPIN != 0
+}
+
+// OnCardComparison returns true if On-Card Biometric Comparison (OCC)
+// satisfies the card's access control rules.
+func (p PINUsagePolicy) OnCardComparison() bool {
+	return p[0]&pinPolicyOnCardComparison != 0
+}
+
+// VirtualContactInterface returns true if the card implements the Virtual
+// Contact Interface (VCI), allowing contact-only operations over a secure
+// messaging session on the contactless interface.
+func (p PINUsagePolicy) VirtualContactInterface() bool {
+	return p[0]&pinPolicyVCI != 0
+}
+
+// PairingCodeRequired returns true if the card implements the Virtual
+// Contact Interface, and requires the Pairing Code to establish it.
+func (p PINUsagePolicy) PairingCodeRequired() bool {
+	return p.VirtualContactInterface() && p[0]&pinPolicyVCIWithoutPairing == 0
+}
+
+// Preferred returns the PIN the cardholder should be prompted for. If the
+// card accepts both PINs, the second byte of the policy decides; otherwise
+// it's whichever PIN the card accepts.
+func (p PINUsagePolicy) Preferred() PINType {
+	if !p.GlobalPIN() {
+		return ApplicationPIN
+	}
+	if !p.ApplicationPIN() || p[1] == pinPolicyGlobalPINPrimary {
+		return GlobalPIN
+	}
+	return ApplicationPIN
+}
+
+// DiscoveryObject is an optional PIV data object, readable without the PIN,
+// that advertises the PIV Card Application and the card's PIN Usage Policy.
+type DiscoveryObject struct {
+	// Entire Discovery Object, as read from the card, without the outer
+	// 0x7E TLV wrapper.
+	Raw []byte
+
+	// Application Identifier of the PIV Card Application, including the
+	// version.
+	AID []byte
+
+	// PIN Usage Policy of the card.
+	PINUsagePolicy PINUsagePolicy
+}
+
+// Tags of the data elements in the Discovery Object, as defined in
+// SP 800-73-4.
+const (
+	discoveryTag               tlv.Tag = 0x7E
+	discoveryTagAID            tlv.Tag = 0x4F
+	discoveryTagPINUsagePolicy tlv.Tag = 0x5F2F
+)
+
+// ParseDiscoveryObject will parse the Discovery Object, as read from the
+// card. Unlike the other data objects, the Discovery Object is wrapped in a
+// 0x7E TLV, rather than the usual 0x53 container; either (or both) are
+// accepted.
+func ParseDiscoveryObject(data []byte) (*DiscoveryObject, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(data) != 0 && tlv.Tag(data[0]) == discoveryTag {
+		data, err = tlv.Unwrap(data, discoveryTag, tlv.Lenient)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := DiscoveryObject{Raw: data}
+	var seenAID, seenPolicy bool
+
+	for _, el := range elements {
+		switch el.Tag {
+		case discoveryTagAID:
+			ret.AID = el.Value
+			seenAID = true
+		case discoveryTagPINUsagePolicy:
+			if len(el.Value) != 2 {
+				return nil, fmt.Errorf("piv: Discovery Object PIN usage policy isn't 2 bytes")
+			}
+			copy(ret.PINUsagePolicy[:], el.Value)
+			seenPolicy = true
+		default:
+			return nil, fmt.Errorf("piv: Discovery Object has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenAID:
+		return nil, fmt.Errorf("piv: Discovery Object is missing the AID")
+	case !seenPolicy:
+		return nil, fmt.Errorf("piv: Discovery Object is missing the PIN usage policy")
+	}
+
+	return &ret, nil
+}
+
+// vim: foldmethod=marker
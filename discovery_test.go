@@ -0,0 +1,60 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"bytes"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestParseDiscoveryObject(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{})
+	discovery, err := token.DiscoveryObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy := discovery.PINUsagePolicy
+	if !policy.ApplicationPIN() || policy.GlobalPIN() || policy.Preferred() != piv.ApplicationPIN {
+		t.Fatalf("got PIN Usage Policy %x", policy)
+	}
+	if !bytes.HasPrefix(discovery.AID, []byte{0xA0, 0x00, 0x00, 0x03, 0x08}) {
+		t.Fatalf("got AID %x", discovery.AID)
+	}
+
+	token = newSoftToken(t, softtoken.Config{GlobalPIN: "87654321"})
+	discovery, err = token.DiscoveryObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	policy = discovery.PINUsagePolicy
+	if !policy.ApplicationPIN() || !policy.GlobalPIN() || policy.Preferred() != piv.ApplicationPIN {
+		t.Fatalf("got PIN Usage Policy %x", policy)
+	}
+
+	if _, err := piv.ParseDiscoveryObject([]byte{0x7E, 0x03, 0x5F, 0x2F, 0x00}); err == nil {
+		t.Fatal("Discovery Object without a PIN Usage Policy was parsed")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,92 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"errors"
+)
+
+// Errors that each of the Token backends map their own errors into, so
+// that callers may check for them with errors.Is regardless of the backend
+// in use.
+var (
+	// ErrNotFound is returned when the requested data object, key or
+	// Certificate isn't on the card.
+	ErrNotFound = errors.New("piv: not found")
+
+	// ErrAmbiguous is returned when more than one object matches where
+	// only one was expected.
+	ErrAmbiguous = errors.New("piv: more than one object matched")
+
+	// ErrAuthRequired is returned when an operation needs the PIN to have
+	// been verified, and it hasn't been.
+	ErrAuthRequired = errors.New("piv: PIN verification required")
+
+	// ErrSecurityStatus is returned when the card refuses an operation
+	// because its security conditions aren't met, which usually means the
+	// PIN must be verified first.
+	ErrSecurityStatus = errors.New("piv: security status not satisfied")
+
+	// ErrPINBlocked is returned when the PIN may no longer be used, since
+	// there are no tries left. An Application PIN may be unblocked with
+	// the PUK by ResetRetryCounter.
+	ErrPINBlocked = errors.New("piv: PIN is blocked")
+
+	// ErrCardRemoved is returned when the card was removed from the
+	// reader, or is otherwise no longer present.
+	ErrCardRemoved = errors.New("piv: card removed")
+
+	// ErrCommunication is returned when talking to the card or device
+	// failed for some other reason.
+	ErrCommunication = errors.New("piv: error communicating with the card")
+
+	// ErrNotSupported is returned when the card or backend doesn't support
+	// the requested operation or algorithm.
+	ErrNotSupported = errors.New("piv: not supported")
+)
+
+// Error is an error from a Token backend, classified as one of the errors
+// above. errors.Is will match the Kind, and errors.As may be used to get at
+// the backend's own error, such as a pkcs11.Error.
+type Error struct {
+	// One of the piv Err errors, such as ErrNotFound.
+	Kind error
+
+	// Underlying error from the backend.
+	Err error
+}
+
+// Error implements the error interface, returning the backend's message.
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+// Unwrap returns the backend's own error.
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is reports if the target is the Kind of this Error.
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// vim: foldmethod=marker
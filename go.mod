@@ -3,6 +3,8 @@ module pault.ag/go/piv
 go 1.15
 
 require (
+	github.com/miekg/pkcs11 v1.1.2
+	golang.org/x/crypto v0.0.0-20211117183948-ae814b36b871
 	pault.ag/go/cbeff v0.0.0-20190316174414-b3ea38156a4c
 	pault.ag/go/fasc v0.0.0-20190505145209-c337c3c0bbf0
 	pault.ag/go/othername v0.0.0-20190316144542-859caba4369b
@@ -0,0 +1,104 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package cancel lets blocking calls into a device library, such as a
+// PKCS#11 module or libykpiv, be abandoned when a context.Context is
+// cancelled or its deadline passes.
+package cancel // import "pault.ag/go/piv/internal/cancel"
+
+import (
+	"context"
+)
+
+// Run runs f on its own goroutine, returning the error from f, or the
+// error from the context if it's done first. f is always run, even if the
+// context is already done.
+//
+// The device library can't be interrupted, so f keeps on running after
+// the context is done. Anything f holds, such as a Lock or a session, must
+// be given back by f itself once the call returns.
+func Run(ctx context.Context, f func() error) error {
+	done := make(chan error, 1)
+	go func() {
+		done <- f()
+	}()
+
+	select {
+	case err := <-done:
+		return err
+	case <-ctx.Done():
+		// f may have finished at the same time, in which case its result
+		// is still worth having.
+		select {
+		case err := <-done:
+			return err
+		default:
+			return ctx.Err()
+		}
+	}
+}
+
+// Lock serializes calls into a device library that can't safely be called
+// from more than one goroutine at a time. A nil Lock does no serialization.
+type Lock chan struct{}
+
+// NewLock returns a new, unlocked, Lock.
+func NewLock() Lock {
+	return make(Lock, 1)
+}
+
+// Acquire waits for the Lock, returning the error from the context if it's
+// done first.
+func (l Lock) Acquire(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if l == nil {
+		return nil
+	}
+	select {
+	case l <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// Release gives back a Lock taken by Acquire.
+func (l Lock) Release() {
+	if l != nil {
+		<-l
+	}
+}
+
+// Do runs f with Run while holding the Lock. Since an abandoned f keeps
+// running, the Lock is held until it returns; calls made in the meantime
+// wait for it, and may themselves give up when their own context is done.
+func (l Lock) Do(ctx context.Context, f func() error) error {
+	if err := l.Acquire(ctx); err != nil {
+		return err
+	}
+	return Run(ctx, func() error {
+		defer l.Release()
+		return f()
+	})
+}
+
+// vim: foldmethod=marker
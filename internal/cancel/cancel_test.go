@@ -0,0 +1,91 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package cancel_test
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv/internal/cancel"
+)
+
+func TestDo(t *testing.T) {
+	lock := cancel.NewLock()
+	want := errors.New("done")
+	if err := lock.Do(context.Background(), func() error { return want }); err != want {
+		t.Fatalf("got %v, want %v", err, want)
+	}
+
+	cancelled, cancelFunc := context.WithCancel(context.Background())
+	cancelFunc()
+	ran := false
+	if err := lock.Do(cancelled, func() error { ran = true; return nil }); err != context.Canceled || ran {
+		t.Fatalf("got %v, ran %t, with a cancelled context", err, ran)
+	}
+}
+
+func TestDoDeadline(t *testing.T) {
+	lock := cancel.NewLock()
+	release := make(chan struct{})
+	var finished int32
+
+	ctx, cancelFunc := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFunc()
+	err := lock.Do(ctx, func() error {
+		<-release
+		atomic.StoreInt32(&finished, 1)
+		return nil
+	})
+	if err != context.DeadlineExceeded {
+		t.Fatalf("got %v, want context.DeadlineExceeded", err)
+	}
+
+	// The abandoned call still holds the Lock.
+	ctx, cancelFunc = context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancelFunc()
+	if err := lock.Do(ctx, func() error { return nil }); err != context.DeadlineExceeded {
+		t.Fatalf("got %v while the Lock was held", err)
+	}
+
+	close(release)
+	if err := lock.Do(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Fatal("abandoned call didn't finish before the Lock was released")
+	}
+}
+
+func TestNilLock(t *testing.T) {
+	var lock cancel.Lock
+	if err := lock.Do(context.Background(), func() error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+	if err := lock.Acquire(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	lock.Release()
+}
+
+// vim: foldmethod=marker
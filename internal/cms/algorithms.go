@@ -0,0 +1,197 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package cms
+
+import (
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+
+	_ "crypto/sha1" // for crypto.SHA1
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+)
+
+var (
+	oidDigestSHA1   = asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}
+	oidDigestSHA224 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 4}
+	oidDigestSHA256 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}
+	oidDigestSHA384 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}
+	oidDigestSHA512 = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}
+
+	oidRSAEncryption = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidRSAPSS        = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 10}
+	oidMGF1          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 8}
+	oidSHA1WithRSA   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 5}
+	oidSHA256WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 11}
+	oidSHA384WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 12}
+	oidSHA512WithRSA = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 13}
+
+	oidECPublicKey     = asn1.ObjectIdentifier{1, 2, 840, 10045, 2, 1}
+	oidECDSAWithSHA1   = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 1}
+	oidECDSAWithSHA256 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}
+	oidECDSAWithSHA384 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 3}
+	oidECDSAWithSHA512 = asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 4}
+)
+
+var digestAlgorithms = []struct {
+	id   asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{oidDigestSHA1, crypto.SHA1},
+	{oidDigestSHA224, crypto.SHA224},
+	{oidDigestSHA256, crypto.SHA256},
+	{oidDigestSHA384, crypto.SHA384},
+	{oidDigestSHA512, crypto.SHA512},
+}
+
+// DigestAlgorithm maps a digest AlgorithmIdentifier OID to the Go
+// crypto.Hash.
+func DigestAlgorithm(id asn1.ObjectIdentifier) (crypto.Hash, error) {
+	for _, el := range digestAlgorithms {
+		if el.id.Equal(id) {
+			return el.hash, nil
+		}
+	}
+	return 0, fmt.Errorf("cms: unsupported digest algorithm %s", id)
+}
+
+// DigestAlgorithmID maps a crypto.Hash to the digest AlgorithmIdentifier
+// OID.
+func DigestAlgorithmID(hash crypto.Hash) (asn1.ObjectIdentifier, error) {
+	for _, el := range digestAlgorithms {
+		if el.hash == hash {
+			return el.id, nil
+		}
+	}
+	return nil, fmt.Errorf("cms: unsupported digest algorithm %s", hash)
+}
+
+var signatureAlgorithms = []struct {
+	id        asn1.ObjectIdentifier
+	hash      crypto.Hash
+	algorithm x509.SignatureAlgorithm
+}{
+	{oidSHA1WithRSA, crypto.SHA1, x509.SHA1WithRSA},
+	{oidSHA256WithRSA, crypto.SHA256, x509.SHA256WithRSA},
+	{oidSHA384WithRSA, crypto.SHA384, x509.SHA384WithRSA},
+	{oidSHA512WithRSA, crypto.SHA512, x509.SHA512WithRSA},
+	{oidRSAPSS, crypto.SHA256, x509.SHA256WithRSAPSS},
+	{oidRSAPSS, crypto.SHA384, x509.SHA384WithRSAPSS},
+	{oidRSAPSS, crypto.SHA512, x509.SHA512WithRSAPSS},
+	{oidECDSAWithSHA1, crypto.SHA1, x509.ECDSAWithSHA1},
+	{oidECDSAWithSHA256, crypto.SHA256, x509.ECDSAWithSHA256},
+	{oidECDSAWithSHA384, crypto.SHA384, x509.ECDSAWithSHA384},
+	{oidECDSAWithSHA512, crypto.SHA512, x509.ECDSAWithSHA512},
+}
+
+// Map a SignerInfo signatureAlgorithm, along with the digest algorithm used,
+// to the x509.SignatureAlgorithm used to check the signature. CMS allows the
+// bare public key algorithm to be given here, in which case the digest
+// algorithm is used to pick the signature algorithm.
+func signatureAlgorithm(signature pkix.AlgorithmIdentifier, digest asn1.ObjectIdentifier) (x509.SignatureAlgorithm, error) {
+	hash, err := DigestAlgorithm(digest)
+	if err != nil {
+		return x509.UnknownSignatureAlgorithm, err
+	}
+
+	id := signature.Algorithm
+	switch {
+	case id.Equal(oidRSAEncryption):
+		id = map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA1: oidSHA1WithRSA, crypto.SHA256: oidSHA256WithRSA,
+			crypto.SHA384: oidSHA384WithRSA, crypto.SHA512: oidSHA512WithRSA,
+		}[hash]
+	case id.Equal(oidECPublicKey):
+		id = map[crypto.Hash]asn1.ObjectIdentifier{
+			crypto.SHA1: oidECDSAWithSHA1, crypto.SHA256: oidECDSAWithSHA256,
+			crypto.SHA384: oidECDSAWithSHA384, crypto.SHA512: oidECDSAWithSHA512,
+		}[hash]
+	case id.Equal(oidRSAPSS):
+		if err := checkPSSParameters(signature.Parameters, digest, hash); err != nil {
+			return x509.UnknownSignatureAlgorithm, err
+		}
+	}
+
+	for _, el := range signatureAlgorithms {
+		if el.id.Equal(id) && el.hash == hash {
+			return el.algorithm, nil
+		}
+	}
+	return x509.UnknownSignatureAlgorithm, fmt.Errorf(
+		"cms: unsupported signature algorithm %s with digest algorithm %s",
+		signature.Algorithm, digest,
+	)
+}
+
+// pssParameters is RSASSA-PSS-params, from RFC 4055, Section 3.1.
+type pssParameters struct {
+	Hash         pkix.AlgorithmIdentifier `asn1:"explicit,tag:0"`
+	MGF          pkix.AlgorithmIdentifier `asn1:"explicit,tag:1"`
+	SaltLength   int                      `asn1:"explicit,tag:2"`
+	TrailerField int                      `asn1:"optional,explicit,tag:3,default:1"`
+}
+
+// Check that the RSASSA-PSS parameters are the only ones x509 is able to
+// verify: the digest algorithm as the PSS hash, MGF1 with that same hash,
+// and a salt as long as the hash. The SHA-1 defaults implied by absent
+// parameters are not supported.
+func checkPSSParameters(params asn1.RawValue, digest asn1.ObjectIdentifier, hash crypto.Hash) error {
+	pss := pssParameters{}
+	if rest, err := asn1.Unmarshal(params.FullBytes, &pss); err != nil {
+		return fmt.Errorf("cms: invalid RSASSA-PSS parameters: %w", err)
+	} else if len(rest) != 0 {
+		return fmt.Errorf("cms: trailing data after the RSASSA-PSS parameters")
+	}
+
+	if !pss.Hash.Algorithm.Equal(digest) {
+		return fmt.Errorf(
+			"cms: RSASSA-PSS hash %s doesn't match digest algorithm %s",
+			pss.Hash.Algorithm, digest,
+		)
+	}
+
+	mgfHash := pkix.AlgorithmIdentifier{}
+	if !pss.MGF.Algorithm.Equal(oidMGF1) {
+		return fmt.Errorf("cms: unsupported RSASSA-PSS mask generation function %s", pss.MGF.Algorithm)
+	}
+	if _, err := asn1.Unmarshal(pss.MGF.Parameters.FullBytes, &mgfHash); err != nil {
+		return fmt.Errorf("cms: invalid RSASSA-PSS MGF1 parameters: %w", err)
+	}
+	if !mgfHash.Algorithm.Equal(digest) {
+		return fmt.Errorf(
+			"cms: RSASSA-PSS MGF1 hash %s doesn't match digest algorithm %s",
+			mgfHash.Algorithm, digest,
+		)
+	}
+
+	if pss.SaltLength != hash.Size() {
+		return fmt.Errorf("cms: unsupported RSASSA-PSS salt length %d", pss.SaltLength)
+	}
+	if pss.TrailerField != 1 {
+		return fmt.Errorf("cms: unsupported RSASSA-PSS trailer field %d", pss.TrailerField)
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
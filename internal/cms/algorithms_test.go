@@ -0,0 +1,110 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package cms
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"strings"
+	"testing"
+)
+
+// pss will encode RSASSA-PSS parameters for the hash and MGF1 hash OIDs.
+func pss(t *testing.T, hash, mgfHash asn1.ObjectIdentifier, saltLength int) pkix.AlgorithmIdentifier {
+	t.Helper()
+	mgfParams, err := asn1.Marshal(pkix.AlgorithmIdentifier{Algorithm: mgfHash})
+	if err != nil {
+		t.Fatal(err)
+	}
+	params, err := asn1.Marshal(pssParameters{
+		Hash:         pkix.AlgorithmIdentifier{Algorithm: hash},
+		MGF:          pkix.AlgorithmIdentifier{Algorithm: oidMGF1, Parameters: asn1.RawValue{FullBytes: mgfParams}},
+		SaltLength:   saltLength,
+		TrailerField: 1,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.AlgorithmIdentifier{Algorithm: oidRSAPSS, Parameters: asn1.RawValue{FullBytes: params}}
+}
+
+func TestSignatureAlgorithm(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		signature pkix.AlgorithmIdentifier
+		digest    asn1.ObjectIdentifier
+		want      x509.SignatureAlgorithm
+	}{
+		{"rsaEncryption", pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption}, oidDigestSHA256, x509.SHA256WithRSA},
+		{"ecPublicKey", pkix.AlgorithmIdentifier{Algorithm: oidECPublicKey}, oidDigestSHA384, x509.ECDSAWithSHA384},
+		{"ecdsa-with-SHA256", pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256}, oidDigestSHA256, x509.ECDSAWithSHA256},
+		{"RSASSA-PSS", pss(t, oidDigestSHA256, oidDigestSHA256, 32), oidDigestSHA256, x509.SHA256WithRSAPSS},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			got, err := signatureAlgorithm(test.signature, test.digest)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != test.want {
+				t.Fatalf("got %s, want %s", got, test.want)
+			}
+		})
+	}
+}
+
+func TestSignatureAlgorithmErrors(t *testing.T) {
+	for _, test := range []struct {
+		name      string
+		signature pkix.AlgorithmIdentifier
+		digest    asn1.ObjectIdentifier
+		contains  string
+	}{
+		{
+			"unknown", pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 3, 4}},
+			oidDigestSHA256, "1.2.3.4 with digest algorithm 2.16.840.1.101.3.4.2.1",
+		},
+		{
+			"mismatched hash", pkix.AlgorithmIdentifier{Algorithm: oidECDSAWithSHA256},
+			oidDigestSHA384, "1.2.840.10045.4.3.2 with digest algorithm 2.16.840.1.101.3.4.2.2",
+		},
+		{
+			"unsupported digest", pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption},
+			oidDigestSHA224, "1.2.840.113549.1.1.1 with digest algorithm 2.16.840.1.101.3.4.2.4",
+		},
+		{"PSS without parameters", pkix.AlgorithmIdentifier{Algorithm: oidRSAPSS}, oidDigestSHA256, "RSASSA-PSS"},
+		{"PSS hash", pss(t, oidDigestSHA384, oidDigestSHA384, 48), oidDigestSHA256, "RSASSA-PSS hash"},
+		{"PSS MGF1 hash", pss(t, oidDigestSHA256, oidDigestSHA1, 32), oidDigestSHA256, "MGF1 hash"},
+		{"PSS salt length", pss(t, oidDigestSHA256, oidDigestSHA256, 20), oidDigestSHA256, "salt length 20"},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := signatureAlgorithm(test.signature, test.digest)
+			if err == nil {
+				t.Fatal("signature algorithm was accepted")
+			}
+			if !strings.Contains(err.Error(), test.contains) {
+				t.Fatalf("got %q, want it to mention %q", err, test.contains)
+			}
+		})
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,271 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package cms implements just enough of RFC 5652 Cryptographic Message
+// Syntax SignedData to verify (and, for testing, create) the signatures
+// found on PIV data objects, such as the CHUID issuer asymmetric signature.
+package cms // import "pault.ag/go/piv/internal/cms"
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidSignedData = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+
+	oidAttributeContentType   = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidAttributeMessageDigest = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+)
+
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,tag:0"`
+}
+
+type encapsulatedContentInfo struct {
+	EContentType asn1.ObjectIdentifier
+	EContent     []byte `asn1:"explicit,optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	EncapContentInfo encapsulatedContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerialNumber struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values []asn1.RawValue `asn1:"set"`
+}
+
+type signerInfo struct {
+	Version            int
+	SID                asn1.RawValue
+	DigestAlgorithm    pkix.AlgorithmIdentifier
+	SignedAttrs        asn1.RawValue `asn1:"optional,tag:0"`
+	SignatureAlgorithm pkix.AlgorithmIdentifier
+	Signature          []byte
+	UnsignedAttrs      asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+// SignedData is a parsed CMS SignedData, with a single signer.
+type SignedData struct {
+	// Type of the content that was signed.
+	ContentType asn1.ObjectIdentifier
+
+	// Encapsulated content, or nil if the content is detached.
+	Content []byte
+
+	// Any certificates that came along with the signature. This usually
+	// includes the signer's certificate, but may not.
+	Certificates []*x509.Certificate
+
+	signer signerInfo
+}
+
+// Parse a DER encoded CMS ContentInfo containing a SignedData.
+func Parse(der []byte) (*SignedData, error) {
+	ci := contentInfo{}
+	rest, err := asn1.Unmarshal(der, &ci)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cms: trailing data after the ContentInfo")
+	}
+	if !ci.ContentType.Equal(oidSignedData) {
+		return nil, fmt.Errorf("cms: ContentInfo isn't SignedData")
+	}
+
+	sd := signedData{}
+	rest, err = asn1.Unmarshal(ci.Content.Bytes, &sd)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("cms: trailing data after the SignedData")
+	}
+	if len(sd.SignerInfos) != 1 {
+		return nil, fmt.Errorf("cms: SignedData has %d signers, expected 1", len(sd.SignerInfos))
+	}
+
+	certs := []*x509.Certificate{}
+	if len(sd.Certificates.Bytes) != 0 {
+		certs, err = x509.ParseCertificates(sd.Certificates.Bytes)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return &SignedData{
+		ContentType:  sd.EncapContentInfo.EContentType,
+		Content:      sd.EncapContentInfo.EContent,
+		Certificates: certs,
+		signer:       sd.SignerInfos[0],
+	}, nil
+}
+
+// Figure out which certificate (if any) the SignerInfo refers to.
+func (sd SignedData) findSigner(certs []*x509.Certificate) (*x509.Certificate, error) {
+	sid := sd.signer.SID
+
+	switch {
+	case sid.Class == asn1.ClassUniversal && sid.Tag == asn1.TagSequence:
+		ias := issuerAndSerialNumber{}
+		if _, err := asn1.Unmarshal(sid.FullBytes, &ias); err != nil {
+			return nil, err
+		}
+		for _, cert := range certs {
+			if cert.SerialNumber.Cmp(ias.SerialNumber) == 0 &&
+				bytes.Equal(cert.RawIssuer, ias.Issuer.FullBytes) {
+				return cert, nil
+			}
+		}
+	case sid.Class == asn1.ClassContextSpecific && sid.Tag == 0:
+		for _, cert := range certs {
+			if bytes.Equal(cert.SubjectKeyId, sid.Bytes) {
+				return cert, nil
+			}
+		}
+	default:
+		return nil, fmt.Errorf("cms: unknown SignerIdentifier")
+	}
+
+	return nil, fmt.Errorf("cms: no certificate for the signer")
+}
+
+// Return the signed attribute of the given type, which must have exactly
+// one value.
+func signedAttribute(attributes []attribute, id asn1.ObjectIdentifier) (*asn1.RawValue, error) {
+	var ret *asn1.RawValue
+	for _, attr := range attributes {
+		if !attr.Type.Equal(id) {
+			continue
+		}
+		if ret != nil || len(attr.Values) != 1 {
+			return nil, fmt.Errorf("cms: signed attribute %s isn't single valued", id)
+		}
+		ret = &attr.Values[0]
+	}
+	if ret == nil {
+		return nil, fmt.Errorf("cms: missing signed attribute %s", id)
+	}
+	return ret, nil
+}
+
+// Verify the signature over the content, returning the certificate of the
+// signer. If the content was encapsulated, content must be nil. Any
+// certificates passed in will be considered as possible signers, in
+// addition to the certificates in the SignedData.
+//
+// This only checks the signature itself, it's up to the caller to
+// determine if the signer's certificate is trustworthy.
+func (sd SignedData) Verify(content []byte, certs []*x509.Certificate) (*x509.Certificate, error) {
+	if content == nil {
+		content = sd.Content
+	} else if sd.Content != nil {
+		return nil, fmt.Errorf("cms: content given, but SignedData isn't detached")
+	}
+	if content == nil {
+		return nil, fmt.Errorf("cms: no content to verify")
+	}
+
+	signer, err := sd.findSigner(append(append([]*x509.Certificate{}, certs...), sd.Certificates...))
+	if err != nil {
+		return nil, err
+	}
+
+	hash, err := DigestAlgorithm(sd.signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+	algorithm, err := signatureAlgorithm(sd.signer.SignatureAlgorithm, sd.signer.DigestAlgorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	signed := content
+	if len(sd.signer.SignedAttrs.Bytes) != 0 {
+		signed, err = sd.verifySignedAttributes(content, hash)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if err := signer.CheckSignature(algorithm, signed, sd.signer.Signature); err != nil {
+		return nil, err
+	}
+	return signer, nil
+}
+
+// Check the content type and message digest signed attributes against the
+// content, returning the bytes that were actually signed.
+func (sd SignedData) verifySignedAttributes(content []byte, hash crypto.Hash) ([]byte, error) {
+	attributes := []attribute{}
+	// The signature is over the SET OF encoding, not the implicit tag.
+	signed := append([]byte{0x31}, sd.signer.SignedAttrs.FullBytes[1:]...)
+	if _, err := asn1.UnmarshalWithParams(signed, &attributes, "set"); err != nil {
+		return nil, err
+	}
+
+	contentType, err := signedAttribute(attributes, oidAttributeContentType)
+	if err != nil {
+		return nil, err
+	}
+	id := asn1.ObjectIdentifier{}
+	if _, err := asn1.Unmarshal(contentType.FullBytes, &id); err != nil {
+		return nil, err
+	}
+	if !id.Equal(sd.ContentType) {
+		return nil, fmt.Errorf("cms: content type attribute doesn't match the content type")
+	}
+
+	messageDigest, err := signedAttribute(attributes, oidAttributeMessageDigest)
+	if err != nil {
+		return nil, err
+	}
+	digest := []byte{}
+	if _, err := asn1.Unmarshal(messageDigest.FullBytes, &digest); err != nil {
+		return nil, err
+	}
+	h := hash.New()
+	h.Write(content)
+	if !bytes.Equal(h.Sum(nil), digest) {
+		return nil, fmt.Errorf("cms: message digest doesn't match the content")
+	}
+
+	return signed, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,171 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package cms
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"sort"
+)
+
+// Sign will create a DER encoded CMS ContentInfo containing a SignedData
+// over the content, with the content type and message digest signed
+// attributes. If detached is true, the content will not be included.
+//
+// This exists to create signatures for synthetic PIV cards, and does not
+// attempt to support every option CMS has to offer.
+func Sign(
+	contentType asn1.ObjectIdentifier,
+	content []byte,
+	detached bool,
+	cert *x509.Certificate,
+	key crypto.Signer,
+	hash crypto.Hash,
+) ([]byte, error) {
+	digestID, err := DigestAlgorithmID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var signatureID asn1.ObjectIdentifier
+	switch key.Public().(type) {
+	case *rsa.PublicKey:
+		signatureID = oidRSAEncryption
+	case *ecdsa.PublicKey:
+		signatureID = oidECPublicKey
+	default:
+		return nil, fmt.Errorf("cms: unsupported key type %T", key.Public())
+	}
+
+	h := hash.New()
+	h.Write(content)
+
+	attributes, err := marshalAttributes([]attributeValue{
+		{Type: oidAttributeContentType, Value: contentType},
+		{Type: oidAttributeMessageDigest, Value: h.Sum(nil)},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	h = hash.New()
+	h.Write(attributes)
+	signature, err := key.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	sid, err := asn1.Marshal(issuerAndSerialNumber{
+		Issuer:       asn1.RawValue{FullBytes: cert.RawIssuer},
+		SerialNumber: cert.SerialNumber,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// Swap the SET OF tag for the implicit [0] the SignerInfo uses.
+	signedAttrs := append([]byte{0xA0}, attributes[1:]...)
+
+	eci := encapsulatedContentInfo{EContentType: contentType}
+	if !detached {
+		eci.EContent = content
+	}
+
+	sd, err := asn1.Marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{{Algorithm: digestID}},
+		EncapContentInfo: eci,
+		Certificates: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      cert.Raw,
+		},
+		SignerInfos: []signerInfo{{
+			Version:            1,
+			SID:                asn1.RawValue{FullBytes: sid},
+			DigestAlgorithm:    pkix.AlgorithmIdentifier{Algorithm: digestID},
+			SignedAttrs:        asn1.RawValue{FullBytes: signedAttrs},
+			SignatureAlgorithm: pkix.AlgorithmIdentifier{Algorithm: signatureID},
+			Signature:          signature,
+		}},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	// encoding/asn1 won't add the explicit tag to a RawValue, so it has to
+	// be written out by hand.
+	return asn1.Marshal(contentInfo{
+		ContentType: oidSignedData,
+		Content: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        0,
+			IsCompound: true,
+			Bytes:      sd,
+		},
+	})
+}
+
+// attributeValue is a single valued signed attribute, prior to encoding.
+type attributeValue struct {
+	Type  asn1.ObjectIdentifier
+	Value interface{}
+}
+
+// Encode the attributes as a DER SET OF, which requires the elements to be
+// sorted by their encoding.
+func marshalAttributes(attributes []attributeValue) ([]byte, error) {
+	encoded := [][]byte{}
+	for _, el := range attributes {
+		value, err := asn1.Marshal(el.Value)
+		if err != nil {
+			return nil, err
+		}
+		attr, err := asn1.Marshal(attribute{
+			Type:   el.Type,
+			Values: []asn1.RawValue{{FullBytes: value}},
+		})
+		if err != nil {
+			return nil, err
+		}
+		encoded = append(encoded, attr)
+	}
+	sort.Slice(encoded, func(i, j int) bool {
+		return bytes.Compare(encoded[i], encoded[j]) < 0
+	})
+
+	return asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassUniversal,
+		Tag:        asn1.TagSet,
+		IsCompound: true,
+		Bytes:      bytes.Join(encoded, nil),
+	})
+}
+
+// vim: foldmethod=marker
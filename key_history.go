@@ -0,0 +1,120 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"fmt"
+
+	"pault.ag/go/piv/tlv"
+)
+
+// RetiredKeyManagementSlots is the number of retired Key Management key
+// slots on a PIV card, using key references 0x82 through 0x95.
+const RetiredKeyManagementSlots = 20
+
+// KeyHistory is the Key History Object, which records how many retired Key
+// Management keys are on the card, and where their Certificates may be
+// found.
+//
+// Retired keys with their Certificate on the card come first, starting at
+// the first retired slot (key reference 0x82), followed by any retired keys
+// with their Certificate stored off the card.
+type KeyHistory struct {
+	// Entire Key History Object, as read from the card, without the outer
+	// container TLV wrapper.
+	Raw []byte
+
+	// Number of retired keys with their Certificate stored on the card.
+	KeysWithOnCardCerts int
+
+	// Number of retired keys with their Certificate stored off the card,
+	// at the OffCardCertURL.
+	KeysWithOffCardCerts int
+
+	// URL of the file holding the Certificates stored off the card. This
+	// is only present if KeysWithOffCardCerts is non-zero.
+	OffCardCertURL string
+}
+
+// Tags of the data elements in the Key History Object, as defined in
+// SP 800-73-4.
+const (
+	keyHistoryTagOnCardCerts        tlv.Tag = 0xC1
+	keyHistoryTagOffCardCerts       tlv.Tag = 0xC2
+	keyHistoryTagOffCardCertURL     tlv.Tag = 0xF3
+	keyHistoryTagErrorDetectionCode tlv.Tag = 0xFE
+)
+
+// ParseKeyHistory will parse the Key History Object, as read from the card.
+func ParseKeyHistory(data []byte) (*KeyHistory, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := KeyHistory{Raw: data}
+	var seenOnCard, seenOffCard bool
+
+	for i, el := range elements {
+		switch el.Tag {
+		case keyHistoryTagOnCardCerts:
+			if len(el.Value) != 1 {
+				return nil, fmt.Errorf("piv: Key History on card certificate count isn't 1 byte")
+			}
+			ret.KeysWithOnCardCerts = int(el.Value[0])
+			seenOnCard = true
+		case keyHistoryTagOffCardCerts:
+			if len(el.Value) != 1 {
+				return nil, fmt.Errorf("piv: Key History off card certificate count isn't 1 byte")
+			}
+			ret.KeysWithOffCardCerts = int(el.Value[0])
+			seenOffCard = true
+		case keyHistoryTagOffCardCertURL:
+			ret.OffCardCertURL = string(el.Value)
+		case keyHistoryTagErrorDetectionCode:
+			if i != len(elements)-1 {
+				return nil, fmt.Errorf("piv: Key History has trailing data after the error detection code")
+			}
+		default:
+			return nil, fmt.Errorf("piv: Key History has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenOnCard:
+		return nil, fmt.Errorf("piv: Key History is missing the on card certificate count")
+	case !seenOffCard:
+		return nil, fmt.Errorf("piv: Key History is missing the off card certificate count")
+	case ret.KeysWithOnCardCerts+ret.KeysWithOffCardCerts > RetiredKeyManagementSlots:
+		return nil, fmt.Errorf("piv: Key History has more keys than there are retired slots")
+	case ret.KeysWithOffCardCerts != 0 && ret.OffCardCertURL == "":
+		return nil, fmt.Errorf("piv: Key History has off card certificates, but no URL")
+	}
+
+	return &ret, nil
+}
+
+// vim: foldmethod=marker
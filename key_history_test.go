@@ -0,0 +1,68 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"errors"
+	"strings"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestParseKeyHistory(t *testing.T) {
+	const base = "http://pki.example.gov/keyhistory/"
+	token := newSoftToken(t, softtoken.Config{
+		RetiredKeys:        2,
+		OffCardRetiredKeys: 1,
+		OffCardCertURLBase: base,
+	})
+	history, err := token.KeyHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if history.KeysWithOnCardCerts != 2 || history.KeysWithOffCardCerts != 1 {
+		t.Fatalf("got %d on card and %d off card", history.KeysWithOnCardCerts, history.KeysWithOffCardCerts)
+	}
+	if len(history.OffCardCertURL) != len(base)+64 || !strings.HasPrefix(history.OffCardCertURL, base) {
+		t.Fatalf("got offCardCertURL %q", history.OffCardCertURL)
+	}
+
+	certs, err := token.RetiredKeyManagementCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != history.KeysWithOnCardCerts {
+		t.Fatalf("got %d retired Certificates, want %d", len(certs), history.KeysWithOnCardCerts)
+	}
+
+	token = newSoftToken(t, softtoken.Config{})
+	if _, err := token.KeyHistory(); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("got %v, want piv.ErrNotFound without retired keys", err)
+	}
+
+	if _, err := piv.ParseKeyHistory([]byte{0xC1, 0x01, 0x00, 0xC2, 0x01, 0x01, 0xFE, 0x00}); err == nil {
+		t.Fatal("Key History with off card keys but no URL was parsed")
+	}
+}
+
+// vim: foldmethod=marker
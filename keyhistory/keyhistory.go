@@ -0,0 +1,223 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package keyhistory fetches the Certificates of retired Key Management keys
+// that are stored off the card, as described by the card's Key History
+// Object.
+//
+// SP 800-73-4 Part 1, Section 3.3.3 defines the offCardCertURL as pointing
+// to a file named after the hex encoded SHA-256 hash of its own contents,
+// holding a DER encoded OffCardKeyHistoryFile:
+//
+//	OffCardKeyHistoryFile ::= SEQUENCE SIZE (1..MAX) OF CertContainer
+//	CertContainer ::= SEQUENCE {
+//	    keyReference OCTET STRING (SIZE(1)),
+//	    cert Certificate }
+package keyhistory // import "pault.ag/go/piv/keyhistory"
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"path"
+	"sort"
+
+	"pault.ag/go/piv"
+)
+
+// maxFileSize is the largest OffCardKeyHistoryFile that will be downloaded.
+const maxFileSize = 4 * 1024 * 1024
+
+// Key reference of the first retired Key Management slot.
+const firstRetiredKeyReference = 0x82
+
+// HTTPClient is the interface used to fetch the OffCardKeyHistoryFile. The
+// standard *http.Client implements this interface, but any stand-in (such
+// as one talking to an httptest.Server) may be provided.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// CertContainer is a single retired Key Management Certificate from the
+// OffCardKeyHistoryFile, along with the key reference of the slot holding
+// its private key.
+type CertContainer struct {
+	KeyReference byte
+	Certificate  *piv.Certificate
+}
+
+type rawCertContainer struct {
+	KeyReference []byte
+	Cert         asn1.RawValue
+}
+
+// Parse will parse a DER encoded OffCardKeyHistoryFile.
+func Parse(data []byte) ([]CertContainer, error) {
+	raw := []rawCertContainer{}
+	rest, err := asn1.Unmarshal(data, &raw)
+	if err != nil {
+		return nil, err
+	}
+	if len(rest) != 0 {
+		return nil, fmt.Errorf("piv: keyhistory: Trailing data after the key history file")
+	}
+	if len(raw) == 0 {
+		return nil, fmt.Errorf("piv: keyhistory: Key history file is empty")
+	}
+
+	ret := []CertContainer{}
+	for _, container := range raw {
+		if len(container.KeyReference) != 1 {
+			return nil, fmt.Errorf("piv: keyhistory: Key reference isn't 1 byte")
+		}
+		cert, err := piv.ParseCertificate(container.Cert.FullBytes)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, CertContainer{
+			KeyReference: container.KeyReference[0],
+			Certificate:  cert,
+		})
+	}
+	return ret, nil
+}
+
+// Fetcher downloads the OffCardKeyHistoryFile named by a Key History Object.
+type Fetcher struct {
+	// Client is used to download the file. If nil, http.DefaultClient
+	// will be used.
+	Client HTTPClient
+}
+
+// NewFetcher will create a new Fetcher that downloads files using the
+// provided HTTPClient.
+func NewFetcher(client HTTPClient) *Fetcher {
+	return &Fetcher{Client: client}
+}
+
+// Fetch will download the OffCardKeyHistoryFile named by the Key History
+// Object, check that its contents match the SHA-256 hash it's named after,
+// and return the Certificates it holds.
+//
+// The Certificates are returned in slot order. Since the keys with off card
+// Certificates follow those with on card Certificates, the first
+// Certificate is for key reference 0x82 + KeysWithOnCardCerts. If the Key
+// History Object has no off card Certificates, an empty list is returned.
+func (f *Fetcher) Fetch(history *piv.KeyHistory) ([]*piv.Certificate, error) {
+	if history.KeysWithOffCardCerts == 0 {
+		return []*piv.Certificate{}, nil
+	}
+
+	expected, err := fileHash(history.OffCardCertURL)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := f.download(history.OffCardCertURL)
+	if err != nil {
+		return nil, err
+	}
+
+	actual := sha256.Sum256(data)
+	if !bytes.Equal(actual[:], expected) {
+		return nil, fmt.Errorf("piv: keyhistory: Key history file doesn't match its hash")
+	}
+
+	containers, err := Parse(data)
+	if err != nil {
+		return nil, err
+	}
+
+	first := firstRetiredKeyReference + history.KeysWithOnCardCerts
+	last := first + history.KeysWithOffCardCerts - 1
+	seen := map[byte]bool{}
+	for _, container := range containers {
+		reference := int(container.KeyReference)
+		if reference < first || reference > last {
+			return nil, fmt.Errorf("piv: keyhistory: Key reference %02X isn't an off card slot", reference)
+		}
+		if seen[container.KeyReference] {
+			return nil, fmt.Errorf("piv: keyhistory: Key reference %02X is listed twice", reference)
+		}
+		seen[container.KeyReference] = true
+	}
+	if len(containers) != history.KeysWithOffCardCerts {
+		return nil, fmt.Errorf(
+			"piv: keyhistory: Key history file has %d certificates, expected %d",
+			len(containers), history.KeysWithOffCardCerts,
+		)
+	}
+
+	sort.Slice(containers, func(i, j int) bool {
+		return containers[i].KeyReference < containers[j].KeyReference
+	})
+	certs := []*piv.Certificate{}
+	for _, container := range containers {
+		certs = append(certs, container.Certificate)
+	}
+	return certs, nil
+}
+
+// fileHash returns the SHA-256 hash the file at the URL is named after.
+func fileHash(offCardCertURL string) ([]byte, error) {
+	u, err := url.Parse(offCardCertURL)
+	if err != nil {
+		return nil, err
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return nil, fmt.Errorf("piv: keyhistory: Unsupported URL scheme %q", u.Scheme)
+	}
+
+	hash, err := hex.DecodeString(path.Base(u.Path))
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("piv: keyhistory: URL isn't named after a SHA-256 hash")
+	}
+	return hash, nil
+}
+
+// download will fetch the file at the URL.
+func (f *Fetcher) download(uri string) ([]byte, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := f.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("piv: keyhistory: Fetching %s returned %s", uri, resp.Status)
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxFileSize))
+}
+
+// vim: foldmethod=marker
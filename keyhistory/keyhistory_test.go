@@ -0,0 +1,228 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package keyhistory_test
+
+import (
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/keyhistory"
+	"pault.ag/go/piv/softtoken"
+)
+
+// server is an httptest.Server serving files by path.
+type server struct {
+	*httptest.Server
+
+	lock  sync.Mutex
+	files map[string][]byte
+}
+
+func newServer(t *testing.T) *server {
+	t.Helper()
+	s := &server{files: map[string][]byte{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ServeHTTP answers with the file at the path.
+func (s *server) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	data, ok := s.files[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Write(data)
+}
+
+// serve will publish the data at the URL's path.
+func (s *server) serve(uri string, data []byte) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.files[strings.TrimPrefix(uri, s.URL)] = data
+}
+
+// hashURL returns the URL on the server named after the data's hash.
+func (s *server) hashURL(data []byte) string {
+	hash := sha256.Sum256(data)
+	return s.URL + "/" + hex.EncodeToString(hash[:])
+}
+
+// newToken will create a software token with one retired key on the card,
+// and two off it, served from the server.
+func newToken(t *testing.T, s *server) *softtoken.Token {
+	t.Helper()
+	ca, err := softtoken.NewCA(softtoken.CAConfig{Bits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := softtoken.New(softtoken.Config{
+		CA:                 ca,
+		Bits:               1024,
+		RetiredKeys:        1,
+		OffCardRetiredKeys: 2,
+		OffCardCertURLBase: s.URL,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestFetch(t *testing.T) {
+	s := newServer(t)
+	token := newToken(t, s)
+	history, err := token.KeyHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.serve(history.OffCardCertURL, token.OffCardKeyHistoryFile())
+
+	certs, err := keyhistory.NewFetcher(s.Client()).Fetch(history)
+	if err != nil {
+		t.Fatal(err)
+	}
+	containers, err := keyhistory.Parse(token.OffCardKeyHistoryFile())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || len(containers) != 2 {
+		t.Fatalf("got %d certificates and %d containers, want 2", len(certs), len(containers))
+	}
+	for i, container := range containers {
+		if container.KeyReference != byte(0x83+i) {
+			t.Errorf("container %d has key reference %02X, want %02X", i, container.KeyReference, 0x83+i)
+		}
+		if !certs[i].Equal(container.Certificate.Certificate) {
+			t.Errorf("certificate %d doesn't match the file", i)
+		}
+	}
+
+	none, err := keyhistory.NewFetcher(s.Client()).Fetch(&piv.KeyHistory{KeysWithOnCardCerts: 1})
+	if err != nil || len(none) != 0 {
+		t.Fatalf("got %d certificates and %v without off card keys", len(none), err)
+	}
+}
+
+func TestFetchHashMismatch(t *testing.T) {
+	s := newServer(t)
+	token := newToken(t, s)
+	history, err := token.KeyHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// A well formed file, but not the one the URL is named after.
+	other := newToken(t, s)
+	s.serve(history.OffCardCertURL, other.OffCardKeyHistoryFile())
+
+	if _, err := keyhistory.NewFetcher(s.Client()).Fetch(history); err == nil {
+		t.Fatal("key history file not matching its hash was accepted")
+	}
+
+	history.OffCardCertURL = s.URL + "/not-a-hash"
+	if _, err := keyhistory.NewFetcher(s.Client()).Fetch(history); err == nil {
+		t.Fatal("URL not named after a hash was accepted")
+	}
+}
+
+func TestFetchMalformed(t *testing.T) {
+	s := newServer(t)
+	token := newToken(t, s)
+	history, err := token.KeyHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	type container struct {
+		KeyReference []byte
+		Cert         asn1.RawValue
+	}
+	file := []container{}
+	if _, err := asn1.Unmarshal(token.OffCardKeyHistoryFile(), &file); err != nil {
+		t.Fatal(err)
+	}
+	cert := file[0].Cert
+
+	marshal := func(value interface{}) []byte {
+		t.Helper()
+		data, err := asn1.Marshal(value)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return data
+	}
+
+	for _, test := range []struct {
+		name string
+		data []byte
+	}{
+		{"not a sequence", []byte{0x04, 0x01, 0x83}},
+		{"empty", marshal([]container{})},
+		{"trailing data", append(token.OffCardKeyHistoryFile(), 0x00)},
+		{"long key reference", marshal([]container{{KeyReference: []byte{0x00, 0x83}, Cert: cert}})},
+		{"not a certificate", marshal([]container{{KeyReference: []byte{0x83}, Cert: asn1.RawValue{FullBytes: []byte{0x05, 0x00}}}})},
+		{"on card slot", marshal([]container{
+			{KeyReference: []byte{0x82}, Cert: cert},
+			{KeyReference: []byte{0x84}, Cert: cert},
+		})},
+		{"listed twice", marshal([]container{
+			{KeyReference: []byte{0x83}, Cert: cert},
+			{KeyReference: []byte{0x83}, Cert: cert},
+		})},
+		{"missing", marshal([]container{{KeyReference: []byte{0x83}, Cert: cert}})},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			history.OffCardCertURL = s.hashURL(test.data)
+			s.serve(history.OffCardCertURL, test.data)
+			if _, err := keyhistory.NewFetcher(s.Client()).Fetch(history); err == nil {
+				t.Fatal("malformed key history file was accepted")
+			}
+		})
+	}
+}
+
+func TestFetchStatus(t *testing.T) {
+	s := newServer(t)
+	token := newToken(t, s)
+	history, err := token.KeyHistory()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Nothing is being served at the URL.
+	_, err = keyhistory.NewFetcher(s.Client()).Fetch(history)
+	if err == nil || !strings.Contains(err.Error(), "404") {
+		t.Fatalf("got %v, want a 404 error", err)
+	}
+}
+
+// vim: foldmethod=marker
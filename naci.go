@@ -23,6 +23,7 @@ package piv
 import (
 	"crypto/x509"
 	"encoding/asn1"
+	"fmt"
 )
 
 var (
@@ -36,17 +37,24 @@ var (
 // This is sometimes wrong (usually in saying 'no' when someone actually has
 // had a NACI -- or better than NACI check!), so please ensure this is never
 // used as an authoritative source of truth for someone's level of vetting.
-func HasNACI(cert *x509.Certificate) *bool {
+//
+// If the extension isn't present, this returns nil. If it's present, but
+// isn't a DER encoded BOOLEAN, an error is returned.
+func HasNACI(cert *x509.Certificate) (*bool, error) {
 	for _, extension := range cert.Extensions {
 		if extension.Id.Equal(oidNACI) {
 			var hasNACI bool
-			if _, err := asn1.Unmarshal(extension.Value, &hasNACI); err != nil {
-				panic(err)
+			rest, err := asn1.Unmarshal(extension.Value, &hasNACI)
+			if err != nil {
+				return nil, fmt.Errorf("piv: id-piv-NACI extension isn't a BOOLEAN: %w", err)
+			}
+			if len(rest) != 0 {
+				return nil, fmt.Errorf("piv: id-piv-NACI extension has trailing data")
 			}
-			return &hasNACI
+			return &hasNACI, nil
 		}
 	}
-	return nil
+	return nil, nil
 }
 
 // vim: foldmethod=marker
@@ -0,0 +1,157 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package pcsc
+
+import (
+	"fmt"
+
+	"pault.ag/go/piv"
+)
+
+var (
+	// NotFound is returned when the requested data object or key is not
+	// present on the card. This matches piv.ErrNotFound with errors.Is.
+	NotFound error = &piv.Error{Kind: piv.ErrNotFound, Err: fmt.Errorf("piv: pcsc: Not Found")}
+)
+
+// Transport sends a single raw APDU to the card, and returns the raw
+// response, including the trailing two byte status word.
+//
+// This is the same shape as the Transmit method on *scard.Card from
+// github.com/ebfe/scard, so a connected card may be used directly. Any
+// other implementation, such as a recorded session or a simulated card, may
+// be used in its place.
+type Transport interface {
+	Transmit(command []byte) ([]byte, error)
+}
+
+// StatusError is returned when the card responds to a command with a status
+// word other than 0x9000.
+type StatusError uint16
+
+// Error implements the error interface.
+func (s StatusError) Error() string {
+	return fmt.Sprintf("piv: pcsc: Card returned status %04X", uint16(s))
+}
+
+// Is reports if the status word is one of the piv errors, so that it may be
+// checked for with errors.Is.
+func (s StatusError) Is(target error) bool {
+	switch uint16(s) {
+	case swSecurityStatusNotSatisfied:
+		return target == piv.ErrSecurityStatus || target == piv.ErrAuthRequired
+	case swAuthenticationBlocked:
+		return target == piv.ErrPINBlocked
+	case swNotFound:
+		return target == piv.ErrNotFound
+	case swInstructionNotSupported:
+		return target == piv.ErrNotSupported
+	}
+	return false
+}
+
+// command is a single ISO/IEC 7816-4 command APDU.
+type command struct {
+	cla, ins, p1, p2 byte
+	data             []byte
+
+	// If the card is expected to return data, in which case Le is sent.
+	// Commands that only return a status word, such as VERIFY, are sent
+	// without Le, since some cards reject them otherwise.
+	response bool
+}
+
+// transmit will send the command to the card, splitting the data across
+// multiple APDUs using command chaining if needed, and collecting the full
+// response by issuing GET RESPONSE for as long as the card has more to say.
+func transmit(transport Transport, cmd command) ([]byte, error) {
+	data := cmd.data
+	for len(data) > maxShortCommandData {
+		chained := cmd
+		chained.cla |= claChaining
+		chained.data = data[:maxShortCommandData]
+		chained.response = false
+		if _, err := transmitOne(transport, chained); err != nil {
+			return nil, err
+		}
+		data = data[maxShortCommandData:]
+	}
+	last := cmd
+	last.data = data
+	return transmitOne(transport, last)
+}
+
+// transmitOne will send a single command APDU, and collect the full
+// response. If the card rejects Le with 6Cxx, the command is sent again
+// with the Le the card asked for. No more than maxResponseData is collected
+// with GET RESPONSE.
+func transmitOne(transport Transport, cmd command) ([]byte, error) {
+	apdu := []byte{cmd.cla, cmd.ins, cmd.p1, cmd.p2}
+	if len(cmd.data) != 0 {
+		apdu = append(apdu, byte(len(cmd.data)))
+		apdu = append(apdu, cmd.data...)
+	}
+	hasLe := cmd.response
+	if hasLe {
+		apdu = append(apdu, 0x00)
+	}
+
+	response := []byte{}
+	getResponses := 0
+	for {
+		resp, err := transport.Transmit(apdu)
+		if err != nil {
+			return nil, err
+		}
+		if len(resp) < 2 {
+			return nil, fmt.Errorf("piv: pcsc: Response is missing the status word")
+		}
+
+		sw1, sw2 := resp[len(resp)-2], resp[len(resp)-1]
+		if sw1 == swWrongLe && hasLe && apdu[len(apdu)-1] != sw2 {
+			apdu[len(apdu)-1] = sw2
+			continue
+		}
+		response = append(response, resp[:len(resp)-2]...)
+
+		if sw1 == swMoreData {
+			getResponses++
+			if len(response) > maxResponseData || getResponses > maxResponseData/maxShortResponseData {
+				return nil, fmt.Errorf("piv: pcsc: Response is too large")
+			}
+			apdu = []byte{0x00, insGetResponse, 0x00, 0x00, sw2}
+			hasLe = true
+			continue
+		}
+
+		sw := uint16(sw1)<<8 | uint16(sw2)
+		switch sw {
+		case swSuccess:
+			return response, nil
+		case swNotFound:
+			return nil, NotFound
+		default:
+			return nil, StatusError(sw)
+		}
+	}
+}
+
+// vim: foldmethod=marker
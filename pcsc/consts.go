@@ -0,0 +1,150 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package pcsc
+
+import (
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	// Application Identifier of the PIV Card Application, from SP 800-73-4
+	// Part 1, Section 2.2, without the version number.
+	pivAID = []byte{0xA0, 0x00, 0x00, 0x03, 0x08, 0x00, 0x00, 0x10, 0x00}
+)
+
+// Instructions used by this package, from SP 800-73-4 Part 2, Section 3.
+const (
+	insSelect              byte = 0xA4
+	insGetData             byte = 0xCB
+	insVerify              byte = 0x20
+	insChangeReference     byte = 0x24
+	insResetRetryCounter   byte = 0x2C
+	insGeneralAuthenticate byte = 0x87
+	insGetResponse         byte = 0xC0
+
+	// Bit set in the CLA of every command in a chain but the last.
+	claChaining byte = 0x10
+)
+
+// Limits of short form APDUs, from ISO/IEC 7816-4.
+const (
+	maxShortCommandData  = 0xFF
+	maxShortResponseData = 0x100
+
+	// Largest response that will be collected using GET RESPONSE, which
+	// is far more than the biggest PIV data object. A card that keeps
+	// answering 61xx past this is treated as broken.
+	maxResponseData = 0x10000
+)
+
+// PIN handling, from SP 800-73-4 Part 2, Section 3.2.1.
+const (
+	pinReferenceApplication byte = 0x80
+	pinReferenceGlobal      byte = 0x00
+	pinLength                    = 8
+	pinPadding              byte = 0xFF
+)
+
+// Key References, from SP 800-78-4, Table 4-1.
+const (
+	keyAuthentication     byte = 0x9A
+	keyDigitalSignature   byte = 0x9C
+	keyManagement         byte = 0x9D
+	keyCardAuthentication byte = 0x9E
+
+	// First of the retired Key Management keys. The rest follow one after
+	// another.
+	keyRetiredKeyManagement byte = 0x82
+)
+
+// Cryptographic Algorithm Identifiers, from SP 800-78-4, Table 6-2.
+const (
+	algRSA1024 byte = 0x06
+	algRSA2048 byte = 0x07
+	algECCP256 byte = 0x11
+	algECCP384 byte = 0x14
+)
+
+// BER-TLV Tags of the PIV Data Objects, from SP 800-73-4 Part 1, Table 3.
+const (
+	tagCardAuthenticationCertificate tlv.Tag = 0x5FC101
+	tagCHUID                         tlv.Tag = 0x5FC102
+	tagFingerprints                  tlv.Tag = 0x5FC103
+	tagAuthenticationCertificate     tlv.Tag = 0x5FC105
+	tagSecurityObject                tlv.Tag = 0x5FC106
+	tagCCC                           tlv.Tag = 0x5FC107
+	tagFacial                        tlv.Tag = 0x5FC108
+	tagPrintedInformation            tlv.Tag = 0x5FC109
+	tagDigitalSignatureCertificate   tlv.Tag = 0x5FC10A
+	tagKeyManagementCertificate      tlv.Tag = 0x5FC10B
+	tagKeyHistory                    tlv.Tag = 0x5FC10C
+	tagIris                          tlv.Tag = 0x5FC121
+	tagDiscoveryObject               tlv.Tag = 0x7E
+
+	// First of the retired Key Management Certificates. The rest follow
+	// one after another.
+	tagRetiredKeyManagementCertificate tlv.Tag = 0x5FC10D
+)
+
+// BER-TLV Tags of the PIV Data Objects that may be covered by the Security
+// Object, by ContainerID.
+var containerTags = map[piv.ContainerID]tlv.Tag{
+	piv.ContainerCCC:                           tagCCC,
+	piv.ContainerCHUID:                         tagCHUID,
+	piv.ContainerAuthenticationCertificate:     tagAuthenticationCertificate,
+	piv.ContainerFingerprints:                  tagFingerprints,
+	piv.ContainerFacial:                        tagFacial,
+	piv.ContainerPrintedInformation:            tagPrintedInformation,
+	piv.ContainerDigitalSignatureCertificate:   tagDigitalSignatureCertificate,
+	piv.ContainerKeyManagementCertificate:      tagKeyManagementCertificate,
+	piv.ContainerCardAuthenticationCertificate: tagCardAuthenticationCertificate,
+	piv.ContainerDiscoveryObject:               tagDiscoveryObject,
+	piv.ContainerKeyHistory:                    tagKeyHistory,
+	piv.ContainerIris:                          tagIris,
+}
+
+// Tags used within the data objects and commands.
+const (
+	tagContainer           tlv.Tag = 0x53
+	tagTagList             tlv.Tag = 0x5C
+	tagCertificate         tlv.Tag = 0x70
+	tagCertInfo            tlv.Tag = 0x71
+	tagDynamicAuthTemplate tlv.Tag = 0x7C
+	tagChallenge           tlv.Tag = 0x81
+	tagResponse            tlv.Tag = 0x82
+
+	certInfoCompressed byte = 0x01
+)
+
+// Status Words, from SP 800-73-4 Part 2, Table 6.
+const (
+	swSuccess                    uint16 = 0x9000
+	swMoreData                   byte   = 0x61
+	swWrongLe                    byte   = 0x6C
+	swVerificationFailed         byte   = 0x63
+	swSecurityStatusNotSatisfied uint16 = 0x6982
+	swAuthenticationBlocked      uint16 = 0x6983
+	swNotFound                   uint16 = 0x6A82
+	swInstructionNotSupported    uint16 = 0x6D00
+)
+
+// vim: foldmethod=marker
@@ -0,0 +1,267 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package pcsc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/subtle"
+	"fmt"
+	"io"
+
+	"pault.ag/go/piv/tlv"
+)
+
+// PrivateKey is a private key held on the card, used by sending GENERAL
+// AUTHENTICATE commands. This implements both crypto.Signer and
+// crypto.Decrypter, although only RSA keys are able to Decrypt.
+type PrivateKey struct {
+	token     Token
+	reference byte
+	algorithm byte
+	public    crypto.PublicKey
+}
+
+// algorithm returns the Cryptographic Algorithm Identifier of the key that
+// corresponds to the public key.
+func algorithm(public crypto.PublicKey) (byte, error) {
+	switch public := public.(type) {
+	case *rsa.PublicKey:
+		switch public.N.BitLen() {
+		case 1024:
+			return algRSA1024, nil
+		case 2048:
+			return algRSA2048, nil
+		}
+		return 0, fmt.Errorf("piv: pcsc: Unsupported RSA key size %d", public.N.BitLen())
+	case *ecdsa.PublicKey:
+		switch public.Curve {
+		case elliptic.P256():
+			return algECCP256, nil
+		case elliptic.P384():
+			return algECCP384, nil
+		}
+		return 0, fmt.Errorf("piv: pcsc: Unsupported curve %s", public.Curve.Params().Name)
+	default:
+		return 0, fmt.Errorf("piv: pcsc: Unsupported public key type %T", public)
+	}
+}
+
+// Look up the private key with the given key reference, taking the public
+// key from the certificate in the same slot.
+func (t Token) privateKey(reference byte, certificateTag tlv.Tag) (*PrivateKey, error) {
+	cert, err := t.x509Certificate(certificateTag)
+	if err != nil {
+		return nil, err
+	}
+	alg, err := algorithm(cert.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	return &PrivateKey{
+		token:     t,
+		reference: reference,
+		algorithm: alg,
+		public:    cert.PublicKey,
+	}, nil
+}
+
+// Public returns the public key corresponding to the private key, as read
+// from the certificate in the same slot.
+func (k PrivateKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// DER encoded DigestInfo prefixes, to be prepended to a digest before
+// padding, since the card only does the raw RSA operation.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Sign the digest with the private key on the card. RSA keys produce
+// PKCS #1 v1.5 signatures; PSS is not supported, since the padding would
+// have to be done here. ECDSA signatures are returned ASN.1 encoded, as the
+// card returns them.
+func (k PrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch public := k.public.(type) {
+	case *rsa.PublicKey:
+		if _, ok := opts.(*rsa.PSSOptions); ok {
+			return nil, fmt.Errorf("piv: pcsc: RSA-PSS is not supported")
+		}
+		prefix, ok := digestInfoPrefixes[opts.HashFunc()]
+		if !ok {
+			return nil, fmt.Errorf("piv: pcsc: Unsupported hash function %s", opts.HashFunc())
+		}
+		size := (public.N.BitLen() + 7) / 8
+		tLen := len(prefix) + len(digest)
+		if size < tLen+11 {
+			return nil, fmt.Errorf("piv: pcsc: Digest is too long for the key")
+		}
+
+		// EMSA-PKCS1-v1_5, from RFC 8017, Section 9.2.
+		em := make([]byte, size)
+		em[1] = 0x01
+		for i := 2; i < size-tLen-1; i++ {
+			em[i] = 0xFF
+		}
+		copy(em[size-tLen:], prefix)
+		copy(em[size-len(digest):], digest)
+		return k.generalAuthenticate(em)
+	case *ecdsa.PublicKey:
+		// The card expects the digest truncated or left padded to the
+		// size of the curve.
+		size := (public.Curve.Params().BitSize + 7) / 8
+		challenge := make([]byte, size)
+		if len(digest) > size {
+			digest = digest[:size]
+		}
+		copy(challenge[size-len(digest):], digest)
+		return k.generalAuthenticate(challenge)
+	default:
+		return nil, fmt.Errorf("piv: pcsc: Unsupported public key type %T", k.public)
+	}
+}
+
+// Decrypt the message with the private key on the card. Only RSA keys with
+// PKCS #1 v1.5 padding are supported.
+//
+// The card only does the raw RSA operation, so the padding is checked here,
+// in constant time. As with crypto/rsa, if opts is a
+// *rsa.PKCS1v15DecryptOptions with a SessionKeyLen, a random key of that
+// length is returned in place of a message with bad padding, so that
+// callers don't give away if the padding was valid.
+func (k PrivateKey) Decrypt(random io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	public, ok := k.public.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("piv: pcsc: Unsupported public key type %T", k.public)
+	}
+	sessionKeyLen := 0
+	switch opts := opts.(type) {
+	case nil:
+	case *rsa.PKCS1v15DecryptOptions:
+		sessionKeyLen = opts.SessionKeyLen
+	default:
+		return nil, fmt.Errorf("piv: pcsc: Unsupported decrypter options %T", opts)
+	}
+
+	size := (public.N.BitLen() + 7) / 8
+	if len(msg) != size {
+		return nil, rsa.ErrDecryption
+	}
+
+	var key []byte
+	if sessionKeyLen > 0 {
+		if random == nil {
+			random = rand.Reader
+		}
+		if size-(sessionKeyLen+3+8) < 0 {
+			return nil, rsa.ErrDecryption
+		}
+		key = make([]byte, sessionKeyLen)
+		if _, err := io.ReadFull(random, key); err != nil {
+			return nil, err
+		}
+	}
+
+	em, err := k.generalAuthenticate(msg)
+	if err != nil {
+		return nil, err
+	}
+	if len(em) > size {
+		return nil, rsa.ErrDecryption
+	}
+	em = append(make([]byte, size-len(em)), em...)
+
+	valid, index := unpadPKCS1v15(em)
+	if key != nil {
+		valid &= subtle.ConstantTimeEq(int32(len(em)-index), int32(len(key)))
+		subtle.ConstantTimeCopy(valid, key, em[len(em)-len(key):])
+		return key, nil
+	}
+	if valid == 0 {
+		return nil, rsa.ErrDecryption
+	}
+	return em[index:], nil
+}
+
+// unpadPKCS1v15 does EME-PKCS1-v1_5 decoding, from RFC 8017, Section
+// 7.2.2, in constant time. This returns 1 if the padding is valid, along
+// with the index of the message within em, and 0 otherwise.
+func unpadPKCS1v15(em []byte) (valid, index int) {
+	firstByteIsZero := subtle.ConstantTimeByteEq(em[0], 0x00)
+	secondByteIsTwo := subtle.ConstantTimeByteEq(em[1], 0x02)
+
+	// The message starts after the first zero byte following the random
+	// padding, which must be at least 8 bytes long.
+	lookingForIndex := 1
+	for i := 2; i < len(em); i++ {
+		equals0 := subtle.ConstantTimeByteEq(em[i], 0x00)
+		index = subtle.ConstantTimeSelect(lookingForIndex&equals0, i, index)
+		lookingForIndex = subtle.ConstantTimeSelect(equals0, 0, lookingForIndex)
+	}
+	validPS := subtle.ConstantTimeLessOrEq(2+8, index)
+
+	valid = firstByteIsZero & secondByteIsTwo & (^lookingForIndex & 1) & validPS
+	index = subtle.ConstantTimeSelect(valid, index+1, 0)
+	return valid, index
+}
+
+// generalAuthenticate will send the challenge to the card, and return the
+// card's response, as described in SP 800-73-4 Part 2, Appendix A.
+func (k PrivateKey) generalAuthenticate(challenge []byte) ([]byte, error) {
+	template := append(
+		tlv.Encode(tagResponse, nil),
+		tlv.Encode(tagChallenge, challenge)...,
+	)
+	resp, err := k.token.transmit(command{
+		ins:      insGeneralAuthenticate,
+		p1:       k.algorithm,
+		p2:       k.reference,
+		data:     tlv.Encode(tagDynamicAuthTemplate, template),
+		response: true,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err = tlv.Unwrap(resp, tagDynamicAuthTemplate, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := tlv.DecodeMode(resp, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	response := elements.Find(tagResponse)
+	if response == nil {
+		return nil, fmt.Errorf("piv: pcsc: Card did not return a response")
+	}
+	return response.Value, nil
+}
+
+// vim: foldmethod=marker
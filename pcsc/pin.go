@@ -0,0 +1,155 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package pcsc
+
+import (
+	"bytes"
+	"fmt"
+
+	"pault.ag/go/piv"
+)
+
+// Pad a PIN (or PUK) out to the fixed length the card expects.
+func padPIN(pin string) ([]byte, error) {
+	if len(pin) > pinLength {
+		return nil, fmt.Errorf("piv: pcsc: PIN is longer than %d characters", pinLength)
+	}
+	data := bytes.Repeat([]byte{pinPadding}, pinLength)
+	copy(data, pin)
+	return data, nil
+}
+
+// Map the status words the card uses for a rejected PIN to the piv PIN
+// errors, leaving any other error alone.
+func pinError(err error) error {
+	status, ok := err.(StatusError)
+	if !ok {
+		return err
+	}
+	switch {
+	case uint16(status) == swAuthenticationBlocked:
+		return piv.ErrPINBlocked
+	case byte(status>>8) == swVerificationFailed && status&0xF0 == 0xC0:
+		if status&0x0F == 0 {
+			return piv.ErrPINBlocked
+		}
+		return piv.ErrWrongPIN{Remaining: int(status & 0x0F)}
+	}
+	return err
+}
+
+func (t Token) verify(reference byte, pin string) error {
+	data, err := padPIN(pin)
+	if err != nil {
+		return err
+	}
+	_, err = t.transmit(command{ins: insVerify, p2: reference, data: data})
+	return pinError(err)
+}
+
+func (t Token) change(reference byte, oldPIN, newPIN string) error {
+	oldData, err := padPIN(oldPIN)
+	if err != nil {
+		return err
+	}
+	newData, err := padPIN(newPIN)
+	if err != nil {
+		return err
+	}
+	_, err = t.transmit(command{
+		ins:  insChangeReference,
+		p2:   reference,
+		data: append(oldData, newData...),
+	})
+	return pinError(err)
+}
+
+// Ask the card for the retry counter, by sending a VERIFY without a PIN.
+func (t Token) retries(reference byte) (int, error) {
+	_, err := t.transmit(command{ins: insVerify, p2: reference})
+	err = pinError(err)
+	if err == nil {
+		// The PIN has already been verified, and the card won't say how
+		// many tries there are.
+		return piv.UnknownPINRetries, nil
+	}
+	if err == piv.ErrPINBlocked {
+		return 0, nil
+	}
+	if wrong, ok := err.(piv.ErrWrongPIN); ok {
+		return wrong.Remaining, nil
+	}
+	return 0, err
+}
+
+// VerifyPIN will send the PIV Card Application PIN to the card, unlocking
+// the PIN protected keys for the rest of the session. If the PIN is wrong,
+// a piv.ErrWrongPIN is returned with the number of tries left.
+func (t Token) VerifyPIN(pin string) error {
+	return t.verify(pinReferenceApplication, pin)
+}
+
+// ChangePIN will change the PIV Card Application PIN.
+func (t Token) ChangePIN(oldPIN, newPIN string) error {
+	return t.change(pinReferenceApplication, oldPIN, newPIN)
+}
+
+// ResetRetryCounter will unblock the PIV Card Application PIN using the PUK,
+// setting a new PIN. If the PUK is wrong, a piv.ErrWrongPIN is returned with
+// the number of PUK tries left.
+func (t Token) ResetRetryCounter(puk, newPIN string) error {
+	pukData, err := padPIN(puk)
+	if err != nil {
+		return err
+	}
+	pinData, err := padPIN(newPIN)
+	if err != nil {
+		return err
+	}
+	_, err = t.transmit(command{
+		ins:  insResetRetryCounter,
+		p2:   pinReferenceApplication,
+		data: append(pukData, pinData...),
+	})
+	return pinError(err)
+}
+
+func (t Token) PINRetriesRemaining() (int, error) {
+	return t.retries(pinReferenceApplication)
+}
+
+// VerifyGlobalPIN will send the Global PIN to the card. Not every card
+// has a Global PIN; the Discovery Object says if this one does.
+func (t Token) VerifyGlobalPIN(pin string) error {
+	return t.verify(pinReferenceGlobal, pin)
+}
+
+func (t Token) ChangeGlobalPIN(oldPIN, newPIN string) error {
+	return t.change(pinReferenceGlobal, oldPIN, newPIN)
+}
+
+func (t Token) GlobalPINRetriesRemaining() (int, error) {
+	return t.retries(pinReferenceGlobal)
+}
+
+var _ piv.GlobalPINManager = Token{}
+
+// vim: foldmethod=marker
@@ -0,0 +1,303 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package pcsc implements a piv.Token that speaks the SP 800-73-4 card
+// command interface directly, without OpenSC or libykpiv. Commands are sent
+// through a Transport, which is usually a card connected over PC/SC, but
+// may just as well be a recorded session or a simulated card.
+package pcsc // import "pault.ag/go/piv/pcsc"
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto"
+	"crypto/x509"
+	"io/ioutil"
+
+	"pault.ag/go/cbeff"
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/biometrics"
+	"pault.ag/go/piv/tlv"
+)
+
+// Token is a PIV card, accessed by sending APDUs over a Transport. This
+// implements the piv.Token interface.
+type Token struct {
+	transport Transport
+}
+
+// New will select the PIV Card Application on the card behind the
+// Transport, and return a Token to talk to it.
+func New(transport Transport) (*Token, error) {
+	t := Token{transport: transport}
+	if _, err := t.transmit(command{
+		ins:      insSelect,
+		p1:       0x04,
+		data:     pivAID,
+		response: true,
+	}); err != nil {
+		return nil, err
+	}
+	return &t, nil
+}
+
+func (t Token) transmit(cmd command) ([]byte, error) {
+	return transmit(t.transport, cmd)
+}
+
+// data will GET DATA the object with the provided tag, returning the
+// object as read off the card, including the outer 0x53 container.
+func (t Token) data(tag tlv.Tag) ([]byte, error) {
+	return t.transmit(command{
+		ins:      insGetData,
+		p1:       0x3F,
+		p2:       0xFF,
+		data:     tlv.Encode(tagTagList, tag.Bytes()),
+		response: true,
+	})
+}
+
+func (t Token) cbeff(tag tlv.Tag) (*cbeff.CBEFF, error) {
+	data, err := t.data(tag)
+	if err != nil {
+		return nil, err
+	}
+	return biometrics.ParseTLVCBEFF(data)
+}
+
+func (t Token) Facial() (*cbeff.CBEFF, error) {
+	return t.cbeff(tagFacial)
+}
+
+// The Fingerprints may only be read once the PIN has been verified with
+// VerifyPIN.
+func (t Token) Fingerprints() (*cbeff.CBEFF, error) {
+	return t.cbeff(tagFingerprints)
+}
+
+// As with the Fingerprints, the Iris images may only be read once the PIN
+// has been verified.
+func (t Token) Iris() (*cbeff.CBEFF, error) {
+	return t.cbeff(tagIris)
+}
+
+func (t Token) CHUID() (*piv.CHUID, error) {
+	data, err := t.data(tagCHUID)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCHUID(data)
+}
+
+func (t Token) CardCapabilityContainer() (*piv.CardCapabilityContainer, error) {
+	data, err := t.data(tagCCC)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCardCapabilityContainer(data)
+}
+
+func (t Token) DiscoveryObject() (*piv.DiscoveryObject, error) {
+	data, err := t.data(tagDiscoveryObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseDiscoveryObject(data)
+}
+
+func (t Token) SecurityObject() (*piv.SecurityObject, error) {
+	data, err := t.data(tagSecurityObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseSecurityObject(data)
+}
+
+// Container will read the raw data object with the given ContainerID off
+// the card, for checking against the Security Object. As with
+// PrintedInformation, the PIN must be verified to read the Printed
+// Information.
+func (t Token) Container(id piv.ContainerID) ([]byte, error) {
+	tag, ok := containerTags[id]
+	if !ok {
+		return nil, NotFound
+	}
+	return t.data(tag)
+}
+
+// The Printed Information may only be read once the PIN has been verified
+// with VerifyPIN.
+func (t Token) PrintedInformation() (*piv.PrintedInformation, error) {
+	data, err := t.data(tagPrintedInformation)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParsePrintedInformation(data)
+}
+
+func (t Token) KeyHistory() (*piv.KeyHistory, error) {
+	data, err := t.data(tagKeyHistory)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseKeyHistory(data)
+}
+
+// Count the retired Key Management keys with a Certificate on the card.
+// Cards without a Key History Object have no retired keys.
+func (t Token) retiredKeys() (int, error) {
+	history, err := t.KeyHistory()
+	if err == NotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	return history.KeysWithOnCardCerts, nil
+}
+
+// x509Certificate will read the Certificate container with the provided
+// tag, decompressing the Certificate if the card has it gzipped.
+func (t Token) x509Certificate(tag tlv.Tag) (*x509.Certificate, error) {
+	data, err := t.data(tag)
+	if err != nil {
+		return nil, err
+	}
+	data, err = tlv.Unwrap(data, tagContainer, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	certificate := elements.Find(tagCertificate)
+	if certificate == nil || len(certificate.Value) == 0 {
+		return nil, NotFound
+	}
+	der := certificate.Value
+
+	if info := elements.Find(tagCertInfo); info != nil && len(info.Value) == 1 &&
+		info.Value[0]&certInfoCompressed != 0 {
+		reader, err := gzip.NewReader(bytes.NewReader(der))
+		if err != nil {
+			return nil, err
+		}
+		der, err = ioutil.ReadAll(reader)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return x509.ParseCertificate(der)
+}
+
+func (t Token) certificate(tag tlv.Tag) (*piv.Certificate, error) {
+	cert, err := t.x509Certificate(tag)
+	if err != nil {
+		return nil, err
+	}
+	return piv.NewCertificate(cert)
+}
+
+func (t Token) AuthenticationCertificate() (*piv.Certificate, error) {
+	return t.certificate(tagAuthenticationCertificate)
+}
+
+func (t Token) DigitalSignatureCertificate() (*piv.Certificate, error) {
+	return t.certificate(tagDigitalSignatureCertificate)
+}
+
+func (t Token) KeyManagementCertificate() (*piv.Certificate, error) {
+	return t.certificate(tagKeyManagementCertificate)
+}
+
+func (t Token) CardAuthenticationCertificate() (*piv.Certificate, error) {
+	return t.certificate(tagCardAuthenticationCertificate)
+}
+
+// Create a crypto.Signer backed by the key in the provided slot, returning
+// a nil interface (rather than a typed nil) on error.
+func (t Token) signer(key byte, tag tlv.Tag) (crypto.Signer, error) {
+	k, err := t.privateKey(key, tag)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (t Token) AuthenticationSigner() (crypto.Signer, error) {
+	return t.signer(keyAuthentication, tagAuthenticationCertificate)
+}
+
+func (t Token) DigitalSignatureSigner() (crypto.Signer, error) {
+	return t.signer(keyDigitalSignature, tagDigitalSignatureCertificate)
+}
+
+func (t Token) KeyManagementDecrypter() (crypto.Decrypter, error) {
+	k, err := t.privateKey(keyManagement, tagKeyManagementCertificate)
+	if err != nil {
+		return nil, err
+	}
+	return k, nil
+}
+
+func (t Token) RetiredKeyManagementCertificates() ([]*piv.Certificate, error) {
+	n, err := t.retiredKeys()
+	if err != nil {
+		return nil, err
+	}
+	certs := []*piv.Certificate{}
+	for i := 0; i < n; i++ {
+		cert, err := t.certificate(tagRetiredKeyManagementCertificate + tlv.Tag(i))
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func (t Token) RetiredKeyManagementDecrypters() ([]crypto.Decrypter, error) {
+	n, err := t.retiredKeys()
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.Decrypter{}
+	for i := 0; i < n; i++ {
+		key, err := t.privateKey(
+			keyRetiredKeyManagement+byte(i),
+			tagRetiredKeyManagementCertificate+tlv.Tag(i),
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (t Token) CardAuthenticationSigner() (crypto.Signer, error) {
+	return t.signer(keyCardAuthentication, tagCardAuthenticationCertificate)
+}
+
+var _ piv.Token = Token{}
+
+// vim: foldmethod=marker
@@ -0,0 +1,336 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package pcsc_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/pcsc"
+	"pault.ag/go/piv/softtoken"
+)
+
+// newToken creates a software Token with small keys, and a pcsc Token
+// talking to it through the simulated card.
+func newToken(t *testing.T, config softtoken.Config) (*softtoken.Token, *pcsc.Token) {
+	t.Helper()
+	ca, err := softtoken.NewCA(softtoken.CAConfig{Bits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	config.CA = ca
+	config.Bits = 1024
+	soft, err := softtoken.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := pcsc.New(soft.Card())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return soft, token
+}
+
+func TestCertificates(t *testing.T) {
+	soft, token := newToken(t, softtoken.Config{RetiredKeys: 2})
+
+	for _, test := range []struct {
+		name      string
+		want, got func() (*piv.Certificate, error)
+	}{
+		{"authentication", soft.AuthenticationCertificate, token.AuthenticationCertificate},
+		{"digital signature", soft.DigitalSignatureCertificate, token.DigitalSignatureCertificate},
+		{"key management", soft.KeyManagementCertificate, token.KeyManagementCertificate},
+		{"card authentication", soft.CardAuthenticationCertificate, token.CardAuthenticationCertificate},
+	} {
+		want, err := test.want()
+		if err != nil {
+			t.Fatal(err)
+		}
+		got, err := test.got()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if !bytes.Equal(want.Raw, got.Raw) {
+			t.Fatalf("%s: certificate doesn't match the card", test.name)
+		}
+	}
+
+	retired, err := token.RetiredKeyManagementCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(retired) != 2 {
+		t.Fatalf("got %d retired certificates, want 2", len(retired))
+	}
+}
+
+func TestDataObjects(t *testing.T) {
+	soft, token := newToken(t, softtoken.Config{})
+	opts := piv.VerifyOptions{Roots: soft.CA().Pool()}
+
+	chuid, err := token.CHUID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := chuid.Verify(opts); err != nil {
+		t.Fatalf("CHUID signature: %s", err)
+	}
+	if _, err := token.CardCapabilityContainer(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.DiscoveryObject(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.Facial(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Printed Information is PIN protected.
+	if _, err := token.PrintedInformation(); !errors.Is(err, piv.ErrSecurityStatus) || !errors.Is(err, piv.ErrAuthRequired) {
+		t.Fatalf("got %v reading the Printed Information without a PIN", err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.PrintedInformation(); err != nil {
+		t.Fatal(err)
+	}
+
+	so, err := token.SecurityObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := so.Verify(opts); err != nil {
+		t.Fatalf("Security Object signature: %s", err)
+	}
+	if err := so.CheckContainers(token); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := token.KeyHistory(); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("got %v reading a missing Key History", err)
+	}
+}
+
+func TestSign(t *testing.T) {
+	_, token := newToken(t, softtoken.Config{})
+	digest := sha256.Sum256([]byte("hello"))
+
+	signer, err := token.AuthenticationSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); !errors.Is(err, piv.ErrSecurityStatus) {
+		t.Fatalf("got %v signing without a PIN", err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	sig, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], sig); err != nil {
+		t.Fatal(err)
+	}
+
+	// The Card Authentication key needs no PIN.
+	_, token = newToken(t, softtoken.Config{})
+	signer, err = token.CardAuthenticationSigner()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDecrypt(t *testing.T) {
+	_, token := newToken(t, softtoken.Config{})
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	decrypter, err := token.KeyManagementDecrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	public := decrypter.Public().(*rsa.PublicKey)
+
+	key := []byte("0123456789abcdef")
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, public, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(plaintext, key) {
+		t.Fatalf("got %x, want %x", plaintext, key)
+	}
+
+	// Raw RSA of something without PKCS #1 v1.5 padding.
+	bad := make([]byte, public.Size())
+	bad[public.Size()-1] = 0x02
+	if _, err := decrypter.Decrypt(rand.Reader, bad, nil); err != rsa.ErrDecryption {
+		t.Fatalf("got %v decrypting bad padding", err)
+	}
+
+	// With a SessionKeyLen, bad padding gives a random key, and no error.
+	opts := &rsa.PKCS1v15DecryptOptions{SessionKeyLen: len(key)}
+	session, err := decrypter.Decrypt(rand.Reader, ciphertext, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(session, key) {
+		t.Fatalf("got session key %x, want %x", session, key)
+	}
+	session, err = decrypter.Decrypt(rand.Reader, bad, opts)
+	if err != nil {
+		t.Fatalf("got %v decrypting bad padding with a SessionKeyLen", err)
+	}
+	if len(session) != len(key) || bytes.Equal(session, key) {
+		t.Fatalf("got session key %x for bad padding", session)
+	}
+	opts.SessionKeyLen = len(key) + 1
+	session, err = decrypter.Decrypt(rand.Reader, ciphertext, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(session) != len(key)+1 {
+		t.Fatalf("got a %d byte session key for the wrong length", len(session))
+	}
+}
+
+func TestPIN(t *testing.T) {
+	_, token := newToken(t, softtoken.Config{GlobalPIN: "87654321"})
+
+	// Asking for the retries sends VERIFY without any data, which the
+	// simulated card rejects if Le is sent along with it.
+	if n, err := token.PINRetriesRemaining(); err != nil || n != 3 {
+		t.Fatalf("got %d, %v retries", n, err)
+	}
+
+	var wrong piv.ErrWrongPIN
+	if err := token.VerifyPIN("000000"); !errors.As(err, &wrong) || wrong.Remaining != 2 {
+		t.Fatalf("got %v verifying the wrong PIN", err)
+	}
+	if err := token.ChangePIN("123456", "654321"); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.VerifyPIN("654321"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		token.ChangePIN("000000", "111111")
+	}
+	if err := token.VerifyPIN("654321"); !errors.Is(err, piv.ErrPINBlocked) {
+		t.Fatalf("got %v verifying a blocked PIN", err)
+	}
+	if err := token.ResetRetryCounter("12345678", "222222"); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.VerifyPIN("222222"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := token.VerifyGlobalPIN("87654321"); err != nil {
+		t.Fatal(err)
+	}
+	if n, err := token.GlobalPINRetriesRemaining(); err != nil || n != piv.UnknownPINRetries {
+		t.Fatalf("got %d, %v global PIN retries", n, err)
+	}
+}
+
+// wrongLe is a Transport that answers the first command asking for
+// response data with 6Cxx, the way some cards insist on an exact Le.
+type wrongLe struct {
+	pcsc.Transport
+	rejected bool
+}
+
+func (w *wrongLe) Transmit(apdu []byte) ([]byte, error) {
+	if !w.rejected && len(apdu) > 5 && apdu[1] == 0xCB && apdu[len(apdu)-1] == 0x00 {
+		w.rejected = true
+		return []byte{0x6C, 0x80}, nil
+	}
+	if w.rejected && apdu[1] == 0xCB && apdu[len(apdu)-1] != 0x80 {
+		return []byte{0x67, 0x00}, nil
+	}
+	return w.Transport.Transmit(apdu)
+}
+
+func TestWrongLe(t *testing.T) {
+	soft, _ := newToken(t, softtoken.Config{})
+	transport := &wrongLe{Transport: soft.Card()}
+	token, err := pcsc.New(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.CHUID(); err != nil {
+		t.Fatal(err)
+	}
+	if !transport.rejected {
+		t.Fatal("the card never asked for a different Le")
+	}
+}
+
+// moreData is a Transport that claims to have more data for every GET
+// RESPONSE, without ever finishing.
+type moreData struct {
+	pcsc.Transport
+	getResponses int
+}
+
+func (m *moreData) Transmit(apdu []byte) ([]byte, error) {
+	if apdu[1] != 0xCB && apdu[1] != 0xC0 {
+		return m.Transport.Transmit(apdu)
+	}
+	if apdu[1] == 0xC0 {
+		m.getResponses++
+	}
+	return []byte{0x00, 0x61, 0x00}, nil
+}
+
+func TestMoreData(t *testing.T) {
+	soft, _ := newToken(t, softtoken.Config{})
+	transport := &moreData{Transport: soft.Card()}
+	token, err := pcsc.New(transport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := token.CHUID(); err == nil {
+		t.Fatal("endless response was accepted")
+	}
+	if transport.getResponses == 0 || transport.getResponses > 0x100 {
+		t.Fatalf("sent %d GET RESPONSE commands", transport.getResponses)
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,78 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"fmt"
+)
+
+// ErrWrongPIN is returned when the card rejects a PIN (or PUK), along with
+// the number of tries left before it's blocked.
+type ErrWrongPIN struct {
+	// Number of tries left, or UnknownPINRetries if the Token can't tell.
+	Remaining int
+}
+
+// Error implements the error interface.
+func (e ErrWrongPIN) Error() string {
+	if e.Remaining == UnknownPINRetries {
+		return "piv: wrong PIN"
+	}
+	return fmt.Sprintf("piv: wrong PIN, %d tries remaining", e.Remaining)
+}
+
+// UnknownPINRetries is returned by PINRetriesRemaining when the Token can't
+// tell how many tries are left, such as when the PIN has already been
+// verified, or the backend only reports whether the count is low.
+const UnknownPINRetries = -1
+
+// PINManager is a Token that allows the cardholder's PIV Card Application
+// PIN to be verified and managed.
+type PINManager interface {
+	// Verify the PIN, unlocking the PIN protected keys and data objects.
+	VerifyPIN(pin string) error
+
+	// Change the PIN from oldPIN to newPIN.
+	ChangePIN(oldPIN, newPIN string) error
+
+	// Unblock the PIN using the PUK, setting the PIN to newPIN and
+	// resetting the retry counter.
+	ResetRetryCounter(puk, newPIN string) error
+
+	// Number of tries left before the PIN is blocked, or
+	// UnknownPINRetries.
+	PINRetriesRemaining() (int, error)
+}
+
+// GlobalPINManager is a Token that also allows the card's Global PIN to be
+// verified and managed. The Discovery Object's PIN Usage Policy says if the
+// card has a Global PIN, and if it's preferred over the Application PIN.
+//
+// There is no PUK for the Global PIN, so it may not be unblocked.
+type GlobalPINManager interface {
+	PINManager
+
+	VerifyGlobalPIN(pin string) error
+	ChangeGlobalPIN(oldPIN, newPIN string) error
+	GlobalPINRetriesRemaining() (int, error)
+}
+
+// vim: foldmethod=marker
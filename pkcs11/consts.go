@@ -1,22 +1,35 @@
 package pkcs11
 
 const (
-	// AuthKeyLabel         string = "PIV AUTH key"
+	AuthKeyLabel string = "PIV AUTH key"
 	// AuthPubkeyLabel      string = "PIV AUTH pubkey"
 	AuthCertificateLabel string = "Certificate for PIV Authentication"
 
-	// SignKeyLabel         string = "SIGN key"
+	SignKeyLabel string = "SIGN key"
 	// SignPubkeyLabel      string = "SIGN pubkey"
 	SignCertificateLabel string = "Certificate for Digital Signature"
 
-	// CardAuthKeyLabel         string = "CARD AUTH key"
+	CardAuthKeyLabel string = "CARD AUTH key"
 	// CardAuthPubkeyLabel      string = "CARD AUTH pubkey"
 	CardAuthCertificateLabel string = "Certificate for Card Authentication"
 
-	//
-	//
+	KeyManagementKeyLabel string = "KEY MAN key"
+	// KeyManagementPubkeyLabel      string = "KEY MAN pubkey"
 	KeyManagementCertificateLabel string = "Certificate for Key Management"
 
-	// FingerprintLabel string = "Cardholder Fingerprints"
-	FacialLabel string = "Cardholder Facial Image"
+	// Formats of the retired Key Management labels, which are numbered from
+	// 1 to piv.RetiredKeyManagementSlots.
+	RetiredKeyManagementKeyLabelFormat         string = "Retired KEY MAN %d"
+	RetiredKeyManagementCertificateLabelFormat string = "Retired Certificate for Key Management %d"
+
+	CHUIDLabel           string = "Card Holder Unique Identifier"
+	CCCLabel             string = "Card Capability Container"
+	DiscoveryObjectLabel string = "Discovery Object"
+	KeyHistoryLabel      string = "Key History Object"
+	PrintedLabel         string = "Printed Information"
+	SecurityObjectLabel  string = "Security Object"
+
+	FingerprintLabel string = "Cardholder Fingerprints"
+	FacialLabel      string = "Cardholder Facial Image"
+	IrisLabel        string = "Cardholder Iris Images"
 )
@@ -0,0 +1,51 @@
+package pkcs11
+
+import (
+	"pault.ag/go/piv"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Classification of the PKCS#11 return values into the piv errors. Anything
+// not listed here is returned as the bare pkcs11.Error. A closed or invalid
+// session is a problem with this package's own state rather than the card,
+// so it isn't classified.
+var errorKinds = map[pkcs11.Error][]error{
+	pkcs11.CKR_USER_NOT_LOGGED_IN:         {piv.ErrAuthRequired, piv.ErrSecurityStatus},
+	pkcs11.CKR_PIN_EXPIRED:                {piv.ErrAuthRequired},
+	pkcs11.CKR_PIN_INCORRECT:              {piv.ErrSecurityStatus},
+	pkcs11.CKR_PIN_LOCKED:                 {piv.ErrPINBlocked},
+	pkcs11.CKR_TOKEN_NOT_PRESENT:          {piv.ErrCardRemoved},
+	pkcs11.CKR_DEVICE_REMOVED:             {piv.ErrCardRemoved},
+	pkcs11.CKR_DEVICE_ERROR:               {piv.ErrCommunication},
+	pkcs11.CKR_FUNCTION_FAILED:            {piv.ErrCommunication},
+	pkcs11.CKR_FUNCTION_NOT_SUPPORTED:     {piv.ErrNotSupported},
+	pkcs11.CKR_MECHANISM_INVALID:          {piv.ErrNotSupported},
+	pkcs11.CKR_KEY_FUNCTION_NOT_PERMITTED: {piv.ErrNotSupported},
+	pkcs11.CKR_OBJECT_HANDLE_INVALID:      {piv.ErrNotFound},
+	pkcs11.CKR_SLOT_ID_INVALID:            {piv.ErrNotFound},
+
+	// The Security Officer can't log in while read-only sessions are open,
+	// or while the user is logged in, and nothing may be changed from a
+	// read-only session.
+	pkcs11.CKR_SESSION_READ_ONLY_EXISTS:       {piv.ErrSecurityStatus},
+	pkcs11.CKR_USER_ANOTHER_ALREADY_LOGGED_IN: {piv.ErrSecurityStatus},
+	pkcs11.CKR_SESSION_READ_ONLY:              {piv.ErrSecurityStatus},
+}
+
+// mapError will wrap a pkcs11.Error in a piv.Error for each of the matching
+// kinds, so that it may be checked for with errors.Is, and the pkcs11.Error
+// itself with errors.As. Any other error, including nil, is returned as is.
+func mapError(err error) error {
+	native, ok := err.(pkcs11.Error)
+	if !ok {
+		return err
+	}
+	kinds := errorKinds[native]
+	for i := len(kinds) - 1; i >= 0; i-- {
+		err = &piv.Error{Kind: kinds[i], Err: err}
+	}
+	return err
+}
+
+// vim: foldmethod=marker
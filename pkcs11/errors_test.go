@@ -0,0 +1,49 @@
+package pkcs11
+
+import (
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv"
+
+	"github.com/miekg/pkcs11"
+)
+
+func TestMapError(t *testing.T) {
+	for _, test := range []struct {
+		code  uint
+		is    []error
+		isNot []error
+	}{
+		{pkcs11.CKR_USER_NOT_LOGGED_IN, []error{piv.ErrAuthRequired, piv.ErrSecurityStatus}, nil},
+		{pkcs11.CKR_PIN_INCORRECT, []error{piv.ErrSecurityStatus}, []error{piv.ErrPINBlocked}},
+		{pkcs11.CKR_PIN_LOCKED, []error{piv.ErrPINBlocked}, nil},
+		{pkcs11.CKR_SESSION_READ_ONLY_EXISTS, []error{piv.ErrSecurityStatus}, []error{piv.ErrAuthRequired}},
+		{pkcs11.CKR_DEVICE_REMOVED, []error{piv.ErrCardRemoved}, nil},
+		{pkcs11.CKR_TOKEN_NOT_PRESENT, []error{piv.ErrCardRemoved}, nil},
+		{pkcs11.CKR_SESSION_CLOSED, nil, []error{piv.ErrCardRemoved}},
+		{pkcs11.CKR_MECHANISM_INVALID, []error{piv.ErrNotSupported}, nil},
+	} {
+		err := mapError(pkcs11.Error(test.code))
+		for _, kind := range test.is {
+			if !errors.Is(err, kind) {
+				t.Errorf("%s: isn't %s", pkcs11.Error(test.code), kind)
+			}
+		}
+		for _, kind := range test.isNot {
+			if errors.Is(err, kind) {
+				t.Errorf("%s: is %s", pkcs11.Error(test.code), kind)
+			}
+		}
+		var native pkcs11.Error
+		if !errors.As(err, &native) || native != pkcs11.Error(test.code) {
+			t.Errorf("%s: the pkcs11.Error isn't reachable", pkcs11.Error(test.code))
+		}
+	}
+
+	if mapError(nil) != nil {
+		t.Error("nil was mapped to an error")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,187 @@
+package pkcs11
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"encoding/asn1"
+	"fmt"
+	"io"
+	"math/big"
+
+	"github.com/miekg/pkcs11"
+)
+
+// PrivateKey is a handle to a private key held on the PKCS#11 token. This
+// implements both crypto.Signer and crypto.Decrypter, although not every
+// key on a PIV card may be used for both.
+type PrivateKey struct {
+	token  Token
+	handle pkcs11.ObjectHandle
+	public crypto.PublicKey
+}
+
+// Look up the private key with the given label, along with the certificate
+// that holds the corresponding public key.
+func (t Token) privateKey(ctx context.Context, keyLabel, certificateLabel string) (*PrivateKey, error) {
+	cert, err := t.certificate(ctx, certificateLabel)
+	if err != nil {
+		return nil, err
+	}
+
+	handle, err := t.getObjectHandle(ctx, t.config.GetPrivateKeyTemplate(keyLabel))
+	if err != nil {
+		return nil, err
+	}
+
+	return &PrivateKey{
+		token:  t,
+		handle: *handle,
+		public: cert.PublicKey,
+	}, nil
+}
+
+// Public returns the public key corresponding to the private key, as read
+// from the certificate in the same slot.
+func (k PrivateKey) Public() crypto.PublicKey {
+	return k.public
+}
+
+// DER encoded DigestInfo prefixes, to be prepended to a digest before
+// signing with CKM_RSA_PKCS, which (unlike CKM_SHA256_RSA_PKCS) will not
+// do it for us.
+var digestInfoPrefixes = map[crypto.Hash][]byte{
+	crypto.SHA1:   {0x30, 0x21, 0x30, 0x09, 0x06, 0x05, 0x2b, 0x0e, 0x03, 0x02, 0x1a, 0x05, 0x00, 0x04, 0x14},
+	crypto.SHA224: {0x30, 0x2d, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x04, 0x05, 0x00, 0x04, 0x1c},
+	crypto.SHA256: {0x30, 0x31, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x01, 0x05, 0x00, 0x04, 0x20},
+	crypto.SHA384: {0x30, 0x41, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x02, 0x05, 0x00, 0x04, 0x30},
+	crypto.SHA512: {0x30, 0x51, 0x30, 0x0d, 0x06, 0x09, 0x60, 0x86, 0x48, 0x01, 0x65, 0x03, 0x04, 0x02, 0x03, 0x05, 0x00, 0x04, 0x40},
+}
+
+// Mapping of Go hash functions to the PKCS#11 hash mechanism and MGF, for
+// use with RSA-PSS and RSA-OAEP.
+var hashMechanisms = map[crypto.Hash]struct{ hash, mgf uint }{
+	crypto.SHA1:   {pkcs11.CKM_SHA_1, pkcs11.CKG_MGF1_SHA1},
+	crypto.SHA224: {pkcs11.CKM_SHA224, pkcs11.CKG_MGF1_SHA224},
+	crypto.SHA256: {pkcs11.CKM_SHA256, pkcs11.CKG_MGF1_SHA256},
+	crypto.SHA384: {pkcs11.CKM_SHA384, pkcs11.CKG_MGF1_SHA384},
+	crypto.SHA512: {pkcs11.CKM_SHA512, pkcs11.CKG_MGF1_SHA512},
+}
+
+// Sign the digest with the private key on the token. For RSA keys, both
+// PKCS #1 v1.5 and PSS signatures are supported. ECDSA signatures are
+// returned ASN.1 encoded, as crypto/ecdsa would.
+func (k PrivateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	switch k.public.(type) {
+	case *rsa.PublicKey:
+		return k.signRSA(digest, opts)
+	case *ecdsa.PublicKey:
+		return k.signECDSA(digest)
+	default:
+		return nil, fmt.Errorf("piv: pkcs11: Unsupported public key type %T", k.public)
+	}
+}
+
+func (k PrivateKey) signRSA(digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	hash := opts.HashFunc()
+
+	if pssOpts, ok := opts.(*rsa.PSSOptions); ok {
+		mechanisms, ok := hashMechanisms[hash]
+		if !ok {
+			return nil, fmt.Errorf("piv: pkcs11: Unsupported hash function %s", hash)
+		}
+		saltLength := pssOpts.SaltLength
+		if saltLength == rsa.PSSSaltLengthAuto || saltLength == rsa.PSSSaltLengthEqualsHash {
+			saltLength = hash.Size()
+		}
+		params := pkcs11.NewPSSParams(mechanisms.hash, mechanisms.mgf, uint(saltLength))
+		return k.sign(pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_PSS, params), digest)
+	}
+
+	prefix, ok := digestInfoPrefixes[hash]
+	if !ok {
+		return nil, fmt.Errorf("piv: pkcs11: Unsupported hash function %s", hash)
+	}
+	return k.sign(
+		pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil),
+		append(append([]byte{}, prefix...), digest...),
+	)
+}
+
+func (k PrivateKey) signECDSA(digest []byte) ([]byte, error) {
+	sig, err := k.sign(pkcs11.NewMechanism(pkcs11.CKM_ECDSA, nil), digest)
+	if err != nil {
+		return nil, err
+	}
+	if len(sig)%2 != 0 {
+		return nil, fmt.Errorf("piv: pkcs11: ECDSA signature has an odd length")
+	}
+
+	// PKCS#11 returns the raw r || s, but Go expects the ASN.1 form.
+	half := len(sig) / 2
+	return asn1.Marshal(struct{ R, S *big.Int }{
+		R: new(big.Int).SetBytes(sig[:half]),
+		S: new(big.Int).SetBytes(sig[half:]),
+	})
+}
+
+func (k PrivateKey) sign(mechanism *pkcs11.Mechanism, data []byte) ([]byte, error) {
+	var sig []byte
+	err := k.token.withSession(context.Background(), func(session pkcs11.SessionHandle) error {
+		if err := k.token.context.SignInit(
+			session, []*pkcs11.Mechanism{mechanism}, k.handle,
+		); err != nil {
+			return mapError(err)
+		}
+		var err error
+		sig, err = k.token.context.Sign(session, data)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return sig, nil
+}
+
+// Decrypt the message with the private key on the token. Only RSA keys are
+// supported, using either PKCS #1 v1.5 or OAEP padding.
+func (k PrivateKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if _, ok := k.public.(*rsa.PublicKey); !ok {
+		return nil, fmt.Errorf("piv: pkcs11: Unsupported public key type %T", k.public)
+	}
+
+	mechanism := pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS, nil)
+	switch opts := opts.(type) {
+	case nil, *rsa.PKCS1v15DecryptOptions:
+	case *rsa.OAEPOptions:
+		mechanisms, ok := hashMechanisms[opts.Hash]
+		if !ok {
+			return nil, fmt.Errorf("piv: pkcs11: Unsupported hash function %s", opts.Hash)
+		}
+		params := pkcs11.NewOAEPParams(
+			mechanisms.hash, mechanisms.mgf, pkcs11.CKZ_DATA_SPECIFIED, opts.Label,
+		)
+		mechanism = pkcs11.NewMechanism(pkcs11.CKM_RSA_PKCS_OAEP, params)
+	default:
+		return nil, fmt.Errorf("piv: pkcs11: Unsupported decrypter options %T", opts)
+	}
+
+	var plaintext []byte
+	err := k.token.withSession(context.Background(), func(session pkcs11.SessionHandle) error {
+		if err := k.token.context.DecryptInit(
+			session, []*pkcs11.Mechanism{mechanism}, k.handle,
+		); err != nil {
+			return mapError(err)
+		}
+		var err error
+		plaintext, err = k.token.context.Decrypt(session, msg)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// vim: foldmethod=marker
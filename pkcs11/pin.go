@@ -0,0 +1,101 @@
+package pkcs11
+
+import (
+	"context"
+
+	"pault.ag/go/piv"
+
+	"github.com/miekg/pkcs11"
+)
+
+// Map the errors PKCS#11 uses for a rejected PIN to the piv PIN errors,
+// using the token flags to work out how many tries are left, if possible.
+// The lockedFlag and finalTryFlag are the flags for the user PIN or SO PIN,
+// depending on which was rejected. Anything other than an incorrect PIN is
+// classified by mapError, as it would be anywhere else.
+func (s Token) pinError(err error, lockedFlag, finalTryFlag uint) error {
+	switch err {
+	case pkcs11.Error(pkcs11.CKR_PIN_INCORRECT):
+		remaining, flagErr := s.retries(lockedFlag, finalTryFlag)
+		if flagErr != nil {
+			return piv.ErrWrongPIN{Remaining: piv.UnknownPINRetries}
+		}
+		if remaining == 0 {
+			return piv.ErrPINBlocked
+		}
+		return piv.ErrWrongPIN{Remaining: remaining}
+	default:
+		return mapError(err)
+	}
+}
+
+// PKCS#11 doesn't give the number of tries left, only if the PIN is on its
+// final try, or has been locked.
+func (s Token) retries(lockedFlag, finalTryFlag uint) (int, error) {
+	info, err := s.context.GetTokenInfo(s.slot)
+	if err != nil {
+		return 0, mapError(err)
+	}
+	switch {
+	case info.Flags&lockedFlag != 0:
+		return 0, nil
+	case info.Flags&finalTryFlag != 0:
+		return 1, nil
+	default:
+		return piv.UnknownPINRetries, nil
+	}
+}
+
+// VerifyPIN will log in to the token with the PIN, unlocking the private
+// keys. This may be used instead of setting the PIN in the Config.
+func (s Token) VerifyPIN(pin string) error {
+	return s.withSession(context.Background(), func(session pkcs11.SessionHandle) error {
+		err := s.context.Login(session, pkcs11.CKU_USER, pin)
+		if err == pkcs11.Error(pkcs11.CKR_USER_ALREADY_LOGGED_IN) {
+			return nil
+		}
+		return s.pinError(err, pkcs11.CKF_USER_PIN_LOCKED, pkcs11.CKF_USER_PIN_FINAL_TRY)
+	})
+}
+
+// ChangePIN will change the PIN, using C_SetPIN.
+func (s Token) ChangePIN(oldPIN, newPIN string) error {
+	return s.withSession(context.Background(), func(session pkcs11.SessionHandle) error {
+		return s.pinError(
+			s.context.SetPIN(session, oldPIN, newPIN),
+			pkcs11.CKF_USER_PIN_LOCKED, pkcs11.CKF_USER_PIN_FINAL_TRY,
+		)
+	})
+}
+
+// ResetRetryCounter will unblock the PIN by logging in as the Security
+// Officer with the PUK, and setting a new PIN with C_InitPIN. The PIN must
+// not be logged in at the time, and since logging in applies to every
+// session, other operations on the Token will run as the Security Officer
+// until this returns.
+func (s Token) ResetRetryCounter(puk, newPIN string) error {
+	return s.withSession(context.Background(), func(session pkcs11.SessionHandle) error {
+		if err := s.context.Login(session, pkcs11.CKU_SO, puk); err != nil {
+			return s.pinError(err, pkcs11.CKF_SO_PIN_LOCKED, pkcs11.CKF_SO_PIN_FINAL_TRY)
+		}
+		defer s.context.Logout(session)
+		return mapError(s.context.InitPIN(session, newPIN))
+	})
+}
+
+// PINRetriesRemaining returns 0 if the PIN is locked, 1 if it's on its
+// final try, and piv.UnknownPINRetries otherwise, since PKCS#11 doesn't
+// report the count.
+func (s Token) PINRetriesRemaining() (int, error) {
+	var retries int
+	err := s.withSession(context.Background(), func(pkcs11.SessionHandle) error {
+		var err error
+		retries, err = s.retries(pkcs11.CKF_USER_PIN_LOCKED, pkcs11.CKF_USER_PIN_FINAL_TRY)
+		return err
+	})
+	return retries, err
+}
+
+var _ piv.PINManager = Token{}
+
+// vim: foldmethod=marker
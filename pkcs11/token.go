@@ -1,11 +1,15 @@
 package pkcs11
 
 import (
+	"context"
+	"crypto"
 	"fmt"
+	"sync"
 
 	"pault.ag/go/cbeff"
 	"pault.ag/go/piv"
 	"pault.ag/go/piv/biometrics"
+	"pault.ag/go/piv/internal/cancel"
 
 	"github.com/miekg/pkcs11"
 )
@@ -24,6 +28,16 @@ type Config struct {
 
 	//
 	TokenLabel string
+
+	// Number of sessions to open on the token, so that this many
+	// operations may be run at once from different goroutines. If this is
+	// 0, a single session is used, and operations wait their turn for it.
+	Sessions int
+
+	// Make only one call into the PKCS#11 module at a time, even across
+	// sessions, for modules that aren't safe to call from more than one
+	// thread. This is always done if the module can't do its own locking.
+	Serialize bool
 }
 
 // Create a pkcs11.Attribute array containing constraints that should
@@ -35,6 +49,15 @@ func (c Config) GetDataTemplate(label string) []*pkcs11.Attribute {
 	}
 }
 
+// Create a pkcs11.Attribute array containing constraints that should
+// uniquely identify the PKCS#11 Private Key we're interested in
+func (c Config) GetPrivateKeyTemplate(label string) []*pkcs11.Attribute {
+	return []*pkcs11.Attribute{
+		pkcs11.NewAttribute(pkcs11.CKA_LABEL, label),
+		pkcs11.NewAttribute(pkcs11.CKA_CLASS, pkcs11.CKO_PRIVATE_KEY),
+	}
+}
+
 // Create a pkcs11.Attribute array containing constraints that should
 // uniquely identify the PKCS#11 Certificate we're interested in
 func (c Config) GetCertificateTemplate(label string) []*pkcs11.Attribute {
@@ -67,119 +90,252 @@ func (c Config) SelectSlot(context *pkcs11.Ctx, slots []uint) (uint, error) {
 	for _, slot := range slots {
 		token, err := context.GetTokenInfo(slot)
 		if err != nil {
-			return 0, err
+			return 0, mapError(err)
 		}
 		if c.slotMatchesCriteria(token) {
 			return slot, nil
 		}
 	}
-	return 0, fmt.Errorf("No matching slot found")
+	return 0, &piv.Error{Kind: piv.ErrNotFound, Err: fmt.Errorf("No matching slot found")}
 }
 
 // Method to log out of the Token, and close any open sessions we might
 // have open. This method ought to be defer'd after creating a new
 // hsm.Store.
+//
+// Any operation still running, including one abandoned by a cancelled
+// context, is waited for first. Once closed, any other use of the Token
+// will return Closed. Calling Close again does nothing, and returns the
+// same error as the first call.
 func (s Token) Close() error {
-	if s.config.PIN != nil {
-		if s.context != nil && s.session != nil {
-			if err := s.context.Logout(*s.session); err != nil {
-				return err
-			}
-		}
-	}
+	s.closer.once.Do(func() {
+		close(s.closer.closed)
 
-	if s.session != nil {
-		return s.context.CloseSession(*s.session)
-	}
-
-	if s.context != nil {
-		s.context.Destroy()
-		if err := s.context.Finalize(); err != nil {
-			return err
+		sessions := []pkcs11.SessionHandle{}
+		for i := 0; i < cap(s.sessions); i++ {
+			sessions = append(sessions, <-s.sessions)
 		}
-	}
 
-	return nil
+		s.closer.err = s.lock.Do(context.Background(), func() error {
+			if s.context == nil {
+				return nil
+			}
+			defer s.context.Destroy()
+
+			// The PIN may have been verified after the Token was opened,
+			// so this always tries to log out, and doesn't mind if it
+			// wasn't logged in. Logging out of one session logs out of
+			// them all.
+			var err error
+			if len(sessions) != 0 {
+				err = s.context.Logout(sessions[0])
+				if err == pkcs11.Error(pkcs11.CKR_USER_NOT_LOGGED_IN) {
+					err = nil
+				}
+			}
+			for _, session := range sessions {
+				if closeErr := s.context.CloseSession(session); err == nil {
+					err = closeErr
+				}
+			}
+			if finalizeErr := s.context.Finalize(); err == nil {
+				err = finalizeErr
+			}
+			return mapError(err)
+		})
+	})
+	return s.closer.err
 }
 
 // Create a new hsm.Store defined by the hsm.Config. If no slot can be
 // found, or the underlying infrastructure throws a problem at us, we will
 // return an error.
 func New(config Config) (*Token, error) {
-	cStore := Token{config: &config}
+	cStore := Token{
+		config: &config,
+		closer: &closer{closed: make(chan struct{})},
+	}
 
 	cStore.context = pkcs11.New(config.Module)
-	if err := cStore.context.Initialize(); err != nil {
+	if cStore.context == nil {
+		return nil, fmt.Errorf("piv: pkcs11: Unable to load the module %s", config.Module)
+	}
+	serialize := config.Serialize
+	if err := cStore.context.Initialize(); err == pkcs11.Error(pkcs11.CKR_CANT_LOCK) {
+		// The module can't do its own locking, so ask it not to, and make
+		// sure it's only ever called from one goroutine at a time.
+		if err := cStore.context.Initialize(pkcs11.InitializeWithFlags(0)); err != nil {
+			cStore.context.Destroy()
+			return nil, mapError(err)
+		}
+		serialize = true
+	} else if err != nil {
+		cStore.context.Destroy()
+		return nil, mapError(err)
+	}
+	if serialize {
+		cStore.lock = cancel.NewLock()
+	}
+
+	// Once the module is initialized, anything that goes wrong has to
+	// close whatever sessions were opened, and finalize the module again.
+	sessions := []pkcs11.SessionHandle{}
+	fail := func(err error) (*Token, error) {
+		for _, session := range sessions {
+			cStore.context.CloseSession(session)
+		}
+		cStore.context.Finalize()
+		cStore.context.Destroy()
 		return nil, err
 	}
 
 	slots, err := cStore.context.GetSlotList(true)
 	if err != nil {
-		return nil, err
+		return fail(mapError(err))
 	}
 
 	slot, err := config.SelectSlot(cStore.context, slots)
 	if err != nil {
-		return nil, err
+		return fail(err)
 	}
 
-	var sessionBitmask uint = pkcs11.CKF_SERIAL_SESSION // | pkcs11.CKF_RW_SESSION
-	session, err := cStore.context.OpenSession(slot, sessionBitmask)
-	if err != nil {
-		return nil, err
+	count := config.Sessions
+	if count <= 0 {
+		count = 1
 	}
-	cStore.session = &session
 
+	// The sessions are read/write, since PKCS#11 won't let the Security
+	// Officer log in to unblock the PIN while any read-only session is
+	// open, and changing a PIN needs a read/write session anyway.
+	var sessionBitmask uint = pkcs11.CKF_SERIAL_SESSION | pkcs11.CKF_RW_SESSION
+	for i := 0; i < count; i++ {
+		session, err := cStore.context.OpenSession(slot, sessionBitmask)
+		if err != nil {
+			return fail(mapError(err))
+		}
+		sessions = append(sessions, session)
+	}
+
+	// Logging in to one session logs in to every session on the token.
 	if config.PIN != nil {
-		if err := cStore.context.Login(session, pkcs11.CKU_USER, *config.PIN); err != nil {
-			return nil, err
+		if err := cStore.context.Login(sessions[0], pkcs11.CKU_USER, *config.PIN); err != nil {
+			return fail(mapError(err))
 		}
 	}
 
-	return &cStore, err
+	cStore.sessions = make(chan pkcs11.SessionHandle, count)
+	for _, session := range sessions {
+		cStore.sessions <- session
+	}
+	cStore.slot = slot
+
+	return &cStore, nil
 }
 
-// internal hsm.Store encaupsulating state. This implements the store.Store
-// interface, as well as crypto.Signer, and crypto.Decryptor.
+// internal hsm.Store encaupsulating state. This implements the piv.Token
+// interface, with private key operations exposed as a crypto.Signer or
+// crypto.Decrypter for each slot.
 type Token struct {
 	config *Config
 
-	session *pkcs11.SessionHandle
+	slot    uint
 	context *pkcs11.Ctx
+
+	// Pool of idle sessions. A session may only run one operation at a
+	// time, such as a search, so each operation takes a session from the
+	// pool, and gives it back once done. A call abandoned when its context
+	// was done keeps its session until it returns.
+	sessions chan pkcs11.SessionHandle
+
+	// Held around every call into the module if it isn't safe to call from
+	// more than one thread at a time, otherwise nil.
+	lock cancel.Lock
+
+	// Shared by every copy of the Token, so that it's only closed once.
+	closer *closer
 }
 
-// Get the object handles that match the set of pkcs11.Attribute critiera
-func (s Token) getObjectHandles(template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
-	if err := s.context.FindObjectsInit(*s.session, template); err != nil {
-		return nil, err
+// State of a Token being closed.
+type closer struct {
+	once   sync.Once
+	closed chan struct{}
+	err    error
+}
+
+// Run the function with a session taken from the pool, on its own
+// goroutine, giving up if the context is done before it returns.
+func (s Token) withSession(ctx context.Context, f func(pkcs11.SessionHandle) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	var session pkcs11.SessionHandle
+	select {
+	case session = <-s.sessions:
+	case <-s.closer.closed:
+		return Closed
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+	// A select picks at random when more than one case is ready, so this
+	// may have got a session even though the Token was already closed.
+	select {
+	case <-s.closer.closed:
+		s.sessions <- session
+		return Closed
+	default:
 	}
+	if err := s.lock.Acquire(ctx); err != nil {
+		s.sessions <- session
+		return err
+	}
+	return cancel.Run(ctx, func() error {
+		defer func() { s.sessions <- session }()
+		defer s.lock.Release()
+		return f(session)
+	})
+}
+
+// Get the object handles that match the set of pkcs11.Attribute critiera
+func (s Token) getObjectHandles(ctx context.Context, template []*pkcs11.Attribute) ([]pkcs11.ObjectHandle, error) {
 	objects := []pkcs11.ObjectHandle{}
-	for {
-		obj, more, err := s.context.FindObjects(*s.session, 8)
-		if err != nil {
-			return nil, err
+	err := s.withSession(ctx, func(session pkcs11.SessionHandle) error {
+		if err := s.context.FindObjectsInit(session, template); err != nil {
+			return mapError(err)
 		}
-		objects = append(objects, obj...)
+		for {
+			obj, more, err := s.context.FindObjects(session, 8)
+			if err != nil {
+				s.context.FindObjectsFinal(session)
+				return mapError(err)
+			}
+			objects = append(objects, obj...)
 
-		if !more {
-			break
+			if !more {
+				break
+			}
 		}
-	}
-	if err := s.context.FindObjectsFinal(*s.session); err != nil {
+		return mapError(s.context.FindObjectsFinal(session))
+	})
+	if err != nil {
 		return nil, err
 	}
 	return objects, nil
 }
 
 var (
-	NotFound = fmt.Errorf("piv: pkcs11: Not Found")
+	// NotFound is returned when the requested object isn't on the token.
+	// This matches piv.ErrNotFound with errors.Is.
+	NotFound error = &piv.Error{Kind: piv.ErrNotFound, Err: fmt.Errorf("piv: pkcs11: Not Found")}
+
+	// Closed is returned when the Token is used after it's been closed.
+	Closed = fmt.Errorf("piv: pkcs11: Token is closed")
 )
 
 // Get the one and only one object that match the set of pkcs11.Attribute
 // criteria. If multiple handles are returned, throw an error out,
 // and if no objects are returned, throw an error.
-func (s Token) getObjectHandle(template []*pkcs11.Attribute) (*pkcs11.ObjectHandle, error) {
-	candidates, err := s.getObjectHandles(template)
+func (s Token) getObjectHandle(ctx context.Context, template []*pkcs11.Attribute) (*pkcs11.ObjectHandle, error) {
+	candidates, err := s.getObjectHandles(ctx, template)
 	if err != nil {
 		return nil, err
 	}
@@ -187,7 +343,7 @@ func (s Token) getObjectHandle(template []*pkcs11.Attribute) (*pkcs11.ObjectHand
 	if len(candidates) == 0 {
 		return nil, NotFound
 	} else if len(candidates) != 1 {
-		return nil, fmt.Errorf("The query resulted in too many objects.")
+		return nil, &piv.Error{Kind: piv.ErrAmbiguous, Err: fmt.Errorf("The query resulted in too many objects.")}
 	}
 	return &candidates[0], nil
 }
@@ -195,52 +351,174 @@ func (s Token) getObjectHandle(template []*pkcs11.Attribute) (*pkcs11.ObjectHand
 // Find the object defined by `locate`, and return the attributes returned by
 // `attributes`. This is useful for looking up an object that we know is
 // unique, and returning the attributes we're interested in.
-func (s Token) getAttributes(locate, attributes []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
-	objectHandle, err := s.getObjectHandle(locate)
+func (s Token) getAttributes(ctx context.Context, locate, attributes []*pkcs11.Attribute) ([]*pkcs11.Attribute, error) {
+	objectHandle, err := s.getObjectHandle(ctx, locate)
+	if err != nil {
+		return nil, err
+	}
+	var attrs []*pkcs11.Attribute
+	err = s.withSession(ctx, func(session pkcs11.SessionHandle) error {
+		var err error
+		attrs, err = s.context.GetAttributeValue(session, *objectHandle, attributes)
+		return mapError(err)
+	})
 	if err != nil {
 		return nil, err
 	}
-	return s.context.GetAttributeValue(*s.session, *objectHandle, attributes)
+	return attrs, nil
 }
 
 // Find the object defined by `locate`, and return the attribute we're interested
 // in, defined by `attribuets`. If multiple handles or multiple attribuets are
 // returned, an error will be returned.
-func (s Token) getAttribute(locate, attributes []*pkcs11.Attribute) (*pkcs11.Attribute, error) {
-	attr, err := s.getAttributes(locate, attributes)
+func (s Token) getAttribute(ctx context.Context, locate, attributes []*pkcs11.Attribute) (*pkcs11.Attribute, error) {
+	attr, err := s.getAttributes(ctx, locate, attributes)
 	if err != nil {
 		return nil, err
 	}
 
 	if len(attr) != 1 {
-		return nil, fmt.Errorf("The query resulted in too many attributes.")
+		return nil, &piv.Error{Kind: piv.ErrAmbiguous, Err: fmt.Errorf("The query resulted in too many attributes.")}
 	}
 
 	return attr[0], nil
 }
 
-// Query the underlying HSM Store for the x509 Certificate we're interested in,
-// and return a Go x509.Certificate.
-func (s Token) cbeff(label string) (*cbeff.CBEFF, error) {
+// Query the underlying HSM Store for the raw value of the data object
+// we're interested in.
+func (s Token) data(ctx context.Context, label string) ([]byte, error) {
 	dataAttribute, err := s.getAttribute(
+		ctx,
 		s.config.GetDataTemplate(label),
 		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)},
 	)
 	if err != nil {
 		return nil, err
 	}
+	return dataAttribute.Value, nil
+}
+
+// Query the underlying HSM Store for the biometric data object we're
+// interested in, and return the parsed CBEFF.
+func (s Token) cbeff(label string) (*cbeff.CBEFF, error) {
+	data, err := s.data(context.Background(), label)
+	if err != nil {
+		return nil, err
+	}
+	return biometrics.ParseTLVCBEFF(data)
+}
 
-	return biometrics.ParseTLVCBEFF(dataAttribute.Value)
+// Labels of the data objects that may be covered by the Security Object, by
+// ContainerID. Certificates are exposed as certificate objects rather than
+// data objects, so they can't be read back raw.
+var containerLabels = map[piv.ContainerID]string{
+	piv.ContainerCCC:                CCCLabel,
+	piv.ContainerCHUID:              CHUIDLabel,
+	piv.ContainerFingerprints:       FingerprintLabel,
+	piv.ContainerFacial:             FacialLabel,
+	piv.ContainerPrintedInformation: PrintedLabel,
+	piv.ContainerDiscoveryObject:    DiscoveryObjectLabel,
+	piv.ContainerKeyHistory:         KeyHistoryLabel,
+	piv.ContainerIris:               IrisLabel,
+}
+
+// Container will read the raw data object with the given ContainerID, for
+// checking against the Security Object.
+func (t Token) Container(id piv.ContainerID) ([]byte, error) {
+	label, ok := containerLabels[id]
+	if !ok {
+		return nil, NotFound
+	}
+	return t.data(context.Background(), label)
+}
+
+func (t Token) SecurityObject() (*piv.SecurityObject, error) {
+	data, err := t.data(context.Background(), SecurityObjectLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseSecurityObject(data)
 }
 
 func (t Token) Facial() (*cbeff.CBEFF, error) {
 	return t.cbeff(FacialLabel)
 }
 
+// The Fingerprints may only be read once the PIN has been verified, so the
+// token must have been opened with a PIN.
+func (t Token) Fingerprints() (*cbeff.CBEFF, error) {
+	return t.cbeff(FingerprintLabel)
+}
+
+// As with the Fingerprints, the Iris images may only be read once the PIN
+// has been verified.
+func (t Token) Iris() (*cbeff.CBEFF, error) {
+	return t.cbeff(IrisLabel)
+}
+
+func (t Token) CHUID() (*piv.CHUID, error) {
+	data, err := t.data(context.Background(), CHUIDLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCHUID(data)
+}
+
+func (t Token) CardCapabilityContainer() (*piv.CardCapabilityContainer, error) {
+	data, err := t.data(context.Background(), CCCLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCardCapabilityContainer(data)
+}
+
+func (t Token) DiscoveryObject() (*piv.DiscoveryObject, error) {
+	data, err := t.data(context.Background(), DiscoveryObjectLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseDiscoveryObject(data)
+}
+
+// The Printed Information may only be read once the PIN has been verified,
+// so the token must have been opened with a PIN.
+func (t Token) PrintedInformation() (*piv.PrintedInformation, error) {
+	data, err := t.data(context.Background(), PrintedLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParsePrintedInformation(data)
+}
+
+func (t Token) KeyHistory() (*piv.KeyHistory, error) {
+	data, err := t.data(context.Background(), KeyHistoryLabel)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseKeyHistory(data)
+}
+
+// Count the retired Key Management keys with a Certificate on the token.
+// Tokens without a Key History Object have no retired keys.
+func (t Token) retiredKeys(ctx context.Context) (int, error) {
+	data, err := t.data(ctx, KeyHistoryLabel)
+	if err == NotFound {
+		return 0, nil
+	} else if err != nil {
+		return 0, err
+	}
+	history, err := piv.ParseKeyHistory(data)
+	if err != nil {
+		return 0, err
+	}
+	return history.KeysWithOnCardCerts, nil
+}
+
 // Query the underlying HSM Store for the x509 Certificate we're interested in,
 // and return a Go x509.Certificate.
-func (s Token) certificate(label string) (*piv.Certificate, error) {
+func (s Token) certificate(ctx context.Context, label string) (*piv.Certificate, error) {
 	certAttribute, err := s.getAttribute(
+		ctx,
 		s.config.GetCertificateTemplate(label),
 		[]*pkcs11.Attribute{pkcs11.NewAttribute(pkcs11.CKA_VALUE, nil)},
 	)
@@ -252,19 +530,127 @@ func (s Token) certificate(label string) (*piv.Certificate, error) {
 }
 
 func (t Token) AuthenticationCertificate() (*piv.Certificate, error) {
-	return t.certificate(AuthCertificateLabel)
+	return t.AuthenticationCertificateContext(context.Background())
+}
+
+func (t Token) AuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return t.certificate(ctx, AuthCertificateLabel)
 }
 
 func (t Token) DigitalSignatureCertificate() (*piv.Certificate, error) {
-	return t.certificate(SignCertificateLabel)
+	return t.DigitalSignatureCertificateContext(context.Background())
+}
+
+func (t Token) DigitalSignatureCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return t.certificate(ctx, SignCertificateLabel)
 }
 
 func (t Token) KeyManagementCertificate() (*piv.Certificate, error) {
-	return t.certificate(KeyManagementCertificateLabel)
+	return t.KeyManagementCertificateContext(context.Background())
+}
+
+func (t Token) KeyManagementCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return t.certificate(ctx, KeyManagementCertificateLabel)
 }
 
 func (t Token) CardAuthenticationCertificate() (*piv.Certificate, error) {
-	return t.certificate(CardAuthCertificateLabel)
+	return t.CardAuthenticationCertificateContext(context.Background())
+}
+
+func (t Token) CardAuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return t.certificate(ctx, CardAuthCertificateLabel)
+}
+
+// Query the underlying HSM Store for the private key we're interested in,
+// and return it as a crypto.Signer.
+func (s Token) signer(ctx context.Context, keyLabel, certificateLabel string) (crypto.Signer, error) {
+	key, err := s.privateKey(ctx, keyLabel, certificateLabel)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
 }
 
+func (t Token) AuthenticationSigner() (crypto.Signer, error) {
+	return t.AuthenticationSignerContext(context.Background())
+}
+
+func (t Token) AuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return t.signer(ctx, AuthKeyLabel, AuthCertificateLabel)
+}
+
+func (t Token) DigitalSignatureSigner() (crypto.Signer, error) {
+	return t.DigitalSignatureSignerContext(context.Background())
+}
+
+func (t Token) DigitalSignatureSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return t.signer(ctx, SignKeyLabel, SignCertificateLabel)
+}
+
+func (t Token) KeyManagementDecrypter() (crypto.Decrypter, error) {
+	return t.KeyManagementDecrypterContext(context.Background())
+}
+
+func (t Token) KeyManagementDecrypterContext(ctx context.Context) (crypto.Decrypter, error) {
+	key, err := t.privateKey(ctx, KeyManagementKeyLabel, KeyManagementCertificateLabel)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (t Token) RetiredKeyManagementCertificates() ([]*piv.Certificate, error) {
+	return t.RetiredKeyManagementCertificatesContext(context.Background())
+}
+
+func (t Token) RetiredKeyManagementCertificatesContext(ctx context.Context) ([]*piv.Certificate, error) {
+	n, err := t.retiredKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	certs := []*piv.Certificate{}
+	for i := 1; i <= n; i++ {
+		cert, err := t.certificate(ctx, fmt.Sprintf(RetiredKeyManagementCertificateLabelFormat, i))
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func (t Token) RetiredKeyManagementDecrypters() ([]crypto.Decrypter, error) {
+	return t.RetiredKeyManagementDecryptersContext(context.Background())
+}
+
+func (t Token) RetiredKeyManagementDecryptersContext(ctx context.Context) ([]crypto.Decrypter, error) {
+	n, err := t.retiredKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.Decrypter{}
+	for i := 1; i <= n; i++ {
+		key, err := t.privateKey(
+			ctx,
+			fmt.Sprintf(RetiredKeyManagementKeyLabelFormat, i),
+			fmt.Sprintf(RetiredKeyManagementCertificateLabelFormat, i),
+		)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+func (t Token) CardAuthenticationSigner() (crypto.Signer, error) {
+	return t.CardAuthenticationSignerContext(context.Background())
+}
+
+func (t Token) CardAuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return t.signer(ctx, CardAuthKeyLabel, CardAuthCertificateLabel)
+}
+
+var _ piv.TokenContext = Token{}
+
 // vim: foldmethod=marker
@@ -0,0 +1,116 @@
+package pkcs11
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/miekg/pkcs11"
+)
+
+// newClosableToken returns a Token with a pool of made up sessions, and no
+// module behind it, which is enough to check how Close and withSession get
+// along.
+func newClosableToken(count int) Token {
+	token := Token{
+		sessions: make(chan pkcs11.SessionHandle, count),
+		closer:   &closer{closed: make(chan struct{})},
+	}
+	for i := 0; i < count; i++ {
+		token.sessions <- pkcs11.SessionHandle(i)
+	}
+	return token
+}
+
+func TestClose(t *testing.T) {
+	token := newClosableToken(2)
+	if err := token.withSession(context.Background(), func(pkcs11.SessionHandle) error { return nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() { done <- token.Close() }()
+	go func() { done <- token.Close() }()
+	for i := 0; i < 2; i++ {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatal("Close didn't return")
+		}
+	}
+
+	err := token.withSession(context.Background(), func(pkcs11.SessionHandle) error {
+		t.Fatal("ran after Close")
+		return nil
+	})
+	if err != Closed {
+		t.Fatalf("got %v, want Closed", err)
+	}
+}
+
+func TestClosePIN(t *testing.T) {
+	token := newClosableToken(1)
+	if err := token.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The module has been finalized, so none of these may touch it.
+	for name, f := range map[string]func() error{
+		"VerifyPIN":         func() error { return token.VerifyPIN("123456") },
+		"ChangePIN":         func() error { return token.ChangePIN("123456", "654321") },
+		"ResetRetryCounter": func() error { return token.ResetRetryCounter("12345678", "654321") },
+		"PINRetriesRemaining": func() error {
+			_, err := token.PINRetriesRemaining()
+			return err
+		},
+	} {
+		if err := f(); err != Closed {
+			t.Errorf("%s: got %v, want Closed", name, err)
+		}
+	}
+}
+
+func TestCloseWaits(t *testing.T) {
+	token := newClosableToken(1)
+	started := make(chan struct{})
+	release := make(chan struct{})
+	go token.withSession(context.Background(), func(pkcs11.SessionHandle) error {
+		close(started)
+		<-release
+		return nil
+	})
+	<-started
+
+	// A caller waiting for the session gives up once the Token is closed.
+	waiting := make(chan error)
+	go func() {
+		waiting <- token.withSession(context.Background(), func(pkcs11.SessionHandle) error { return nil })
+	}()
+
+	closed := make(chan error)
+	go func() { closed <- token.Close() }()
+
+	select {
+	case err := <-waiting:
+		if err != Closed {
+			t.Fatalf("got %v, want Closed", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("withSession didn't return once closed")
+	}
+
+	select {
+	case <-closed:
+		t.Fatal("Close returned while a session was in use")
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(release)
+	if err := <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// vim: foldmethod=marker
@@ -142,6 +142,11 @@ func (p Policy) String() string {
 
 // ParsePolicies will read a list of ObjectIdentifier objects, and
 // return the known Policy objects as a set of Policies.
+//
+// This is a plain lookup, and does no validation or policy mapping. For
+// a Certificate from a cross-certified issuer, use EvaluatePolicies (or
+// Certificate.EffectivePolicies) to find out which policies in the relying
+// party's domain the Certificate is actually valid for.
 func ParsePolicies(ids []asn1.ObjectIdentifier) Policies {
 	ret := Policies{}
 	for _, id := range ids {
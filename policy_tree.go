@@ -0,0 +1,575 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+var (
+	oidAnyPolicy = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+
+	oidExtensionPolicyMappings    = asn1.ObjectIdentifier{2, 5, 29, 33}
+	oidExtensionPolicyConstraints = asn1.ObjectIdentifier{2, 5, 29, 36}
+	oidExtensionInhibitAnyPolicy  = asn1.ObjectIdentifier{2, 5, 29, 54}
+
+	anyPolicy = oidAnyPolicy.String()
+)
+
+// policyMapping is a single entry of the RFC 5280 PolicyMappings extension.
+type policyMapping struct {
+	IssuerDomainPolicy  asn1.ObjectIdentifier
+	SubjectDomainPolicy asn1.ObjectIdentifier
+}
+
+// policyConstraints is the RFC 5280 PolicyConstraints extension. A value of
+// -1 means the field was absent.
+type policyConstraints struct {
+	RequireExplicitPolicy int `asn1:"optional,tag:0,default:-1"`
+	InhibitPolicyMapping  int `asn1:"optional,tag:1,default:-1"`
+}
+
+// policyExtensions are the policy related extensions of a Certificate that
+// crypto/x509 doesn't parse for us.
+type policyExtensions struct {
+	Mappings    []policyMapping
+	Constraints policyConstraints
+
+	// InhibitAnyPolicy is -1 if the extension was absent.
+	InhibitAnyPolicy int
+}
+
+// Parse the PolicyMappings, PolicyConstraints and InhibitAnyPolicy
+// extensions out of the Certificate.
+func parsePolicyExtensions(cert *x509.Certificate) (*policyExtensions, error) {
+	ret := policyExtensions{
+		Constraints:      policyConstraints{RequireExplicitPolicy: -1, InhibitPolicyMapping: -1},
+		InhibitAnyPolicy: -1,
+	}
+
+	for _, extension := range cert.Extensions {
+		var (
+			target interface{}
+			name   string
+		)
+
+		switch {
+		case extension.Id.Equal(oidExtensionPolicyMappings):
+			target, name = &ret.Mappings, "PolicyMappings"
+		case extension.Id.Equal(oidExtensionPolicyConstraints):
+			target, name = &ret.Constraints, "PolicyConstraints"
+		case extension.Id.Equal(oidExtensionInhibitAnyPolicy):
+			target, name = &ret.InhibitAnyPolicy, "InhibitAnyPolicy"
+		default:
+			continue
+		}
+
+		rest, err := asn1.Unmarshal(extension.Value, target)
+		if err != nil {
+			return nil, fmt.Errorf("piv: malformed %s extension: %w", name, err)
+		}
+		if len(rest) != 0 {
+			return nil, fmt.Errorf("piv: trailing data after the %s extension", name)
+		}
+	}
+
+	for _, mapping := range ret.Mappings {
+		if mapping.IssuerDomainPolicy.Equal(oidAnyPolicy) ||
+			mapping.SubjectDomainPolicy.Equal(oidAnyPolicy) {
+			return nil, fmt.Errorf("piv: PolicyMappings extension maps anyPolicy")
+		}
+	}
+
+	return &ret, nil
+}
+
+// policyNode is a node of the RFC 5280 valid_policy_tree. Policy
+// qualifiers aren't tracked, since nothing in PIV makes use of them.
+type policyNode struct {
+	validPolicy       string
+	expectedPolicySet []string
+	parent            *policyNode
+	children          []*policyNode
+}
+
+func (n *policyNode) addChild(validPolicy string, expectedPolicySet []string) {
+	n.children = append(n.children, &policyNode{
+		validPolicy:       validPolicy,
+		expectedPolicySet: expectedPolicySet,
+		parent:            n,
+	})
+}
+
+func (n *policyNode) expects(policy string) bool {
+	for _, el := range n.expectedPolicySet {
+		if el == policy {
+			return true
+		}
+	}
+	return false
+}
+
+func (n *policyNode) hasChild(validPolicy string) bool {
+	for _, child := range n.children {
+		if child.validPolicy == validPolicy {
+			return true
+		}
+	}
+	return false
+}
+
+// Remove the node (and all its descendants) from the tree.
+func (n *policyNode) remove() {
+	if n.parent == nil {
+		return
+	}
+	siblings := []*policyNode{}
+	for _, child := range n.parent.children {
+		if child != n {
+			siblings = append(siblings, child)
+		}
+	}
+	n.parent.children = siblings
+	n.parent = nil
+}
+
+// policyTree is the RFC 5280 valid_policy_tree, along with the state
+// variables used while processing a certification path.
+type policyTree struct {
+	// Root of the tree, or nil if the tree is NULL.
+	root *policyNode
+
+	// Number of certificates in the path, not counting the trust anchor.
+	length int
+
+	// Current depth of the tree.
+	depth int
+
+	explicitPolicy   int
+	inhibitAnyPolicy int
+	policyMapping    int
+}
+
+// Return all nodes at the given depth, with the root at depth 0.
+func (t *policyTree) nodesAt(depth int) []*policyNode {
+	if t.root == nil {
+		return nil
+	}
+	nodes := []*policyNode{t.root}
+	for i := 0; i < depth; i++ {
+		next := []*policyNode{}
+		for _, node := range nodes {
+			next = append(next, node.children...)
+		}
+		nodes = next
+	}
+	return nodes
+}
+
+// Delete any node at the given depth or less without any children, which
+// is to say, any branch that doesn't reach the bottom of the tree. If this
+// deletes the root, the tree becomes NULL.
+func (t *policyTree) prune(depth int) {
+	for i := depth; i >= 0; i-- {
+		for _, node := range t.nodesAt(i) {
+			if len(node.children) == 0 {
+				if node == t.root {
+					t.root = nil
+					return
+				}
+				node.remove()
+			}
+		}
+	}
+}
+
+func oidStrings(ids []asn1.ObjectIdentifier) []string {
+	ret := []string{}
+	for _, id := range ids {
+		ret = append(ret, id.String())
+	}
+	return ret
+}
+
+// Parse the dotted decimal form of an ObjectIdentifier, as returned by
+// asn1.ObjectIdentifier.String.
+func parseOID(s string) (asn1.ObjectIdentifier, error) {
+	ret := asn1.ObjectIdentifier{}
+	for _, el := range strings.Split(s, ".") {
+		i, err := strconv.Atoi(el)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, i)
+	}
+	return ret, nil
+}
+
+func isSelfIssued(cert *x509.Certificate) bool {
+	return bytes.Equal(cert.RawIssuer, cert.RawSubject)
+}
+
+// Initialize the valid_policy_tree and state variables, as described in
+// RFC 5280 section 6.1.2.
+func newPolicyTree(length int, opts PolicyOptions) *policyTree {
+	t := policyTree{
+		root:             &policyNode{validPolicy: anyPolicy, expectedPolicySet: []string{anyPolicy}},
+		length:           length,
+		explicitPolicy:   length + 1,
+		inhibitAnyPolicy: length + 1,
+		policyMapping:    length + 1,
+	}
+	if opts.InitialExplicitPolicy {
+		t.explicitPolicy = 0
+	}
+	if opts.InitialAnyPolicyInhibit {
+		t.inhibitAnyPolicy = 0
+	}
+	if opts.InitialPolicyMappingInhibit {
+		t.policyMapping = 0
+	}
+	return &t
+}
+
+// Process the certificate policies of the next certificate in the path, as
+// described in RFC 5280 section 6.1.3 (d) through (f).
+func (t *policyTree) processCertificate(cert *x509.Certificate) error {
+	t.depth++
+	i := t.depth
+
+	if t.root != nil && len(cert.PolicyIdentifiers) != 0 {
+		parents := t.nodesAt(i - 1)
+		hasAnyPolicy := false
+
+		for _, id := range cert.PolicyIdentifiers {
+			policy := id.String()
+			if policy == anyPolicy {
+				hasAnyPolicy = true
+				continue
+			}
+
+			matched := false
+			for _, parent := range parents {
+				if parent.expects(policy) {
+					parent.addChild(policy, []string{policy})
+					matched = true
+				}
+			}
+			if matched {
+				continue
+			}
+			for _, parent := range parents {
+				if parent.validPolicy == anyPolicy {
+					parent.addChild(policy, []string{policy})
+				}
+			}
+		}
+
+		if hasAnyPolicy && (t.inhibitAnyPolicy > 0 || (i < t.length && isSelfIssued(cert))) {
+			for _, parent := range parents {
+				for _, policy := range parent.expectedPolicySet {
+					if !parent.hasChild(policy) {
+						parent.addChild(policy, []string{policy})
+					}
+				}
+			}
+		}
+
+		t.prune(i - 1)
+	}
+
+	if len(cert.PolicyIdentifiers) == 0 {
+		t.root = nil
+	}
+
+	if t.explicitPolicy <= 0 && t.root == nil {
+		return fmt.Errorf("piv: no valid policies at depth %d, and an explicit policy is required", i)
+	}
+	return nil
+}
+
+// Prepare for the next certificate in the path, as described in RFC 5280
+// section 6.1.4 (a), (b), and (h) through (j).
+func (t *policyTree) prepareNext(cert *x509.Certificate, ext *policyExtensions) {
+	i := t.depth
+
+	issuerDomainPolicies := []string{}
+	mappings := map[string][]string{}
+	for _, mapping := range ext.Mappings {
+		issuer := mapping.IssuerDomainPolicy.String()
+		if _, ok := mappings[issuer]; !ok {
+			issuerDomainPolicies = append(issuerDomainPolicies, issuer)
+		}
+		mappings[issuer] = append(mappings[issuer], mapping.SubjectDomainPolicy.String())
+	}
+
+	for _, issuer := range issuerDomainPolicies {
+		nodes := t.nodesAt(i)
+
+		if t.policyMapping > 0 {
+			found := false
+			for _, node := range nodes {
+				if node.validPolicy == issuer {
+					node.expectedPolicySet = mappings[issuer]
+					found = true
+				}
+			}
+			if found {
+				continue
+			}
+			for _, node := range nodes {
+				if node.validPolicy == anyPolicy {
+					node.parent.addChild(issuer, mappings[issuer])
+					break
+				}
+			}
+			continue
+		}
+
+		for _, node := range nodes {
+			if node.validPolicy == issuer {
+				node.remove()
+			}
+		}
+		t.prune(i - 1)
+	}
+
+	if !isSelfIssued(cert) {
+		if t.explicitPolicy > 0 {
+			t.explicitPolicy--
+		}
+		if t.policyMapping > 0 {
+			t.policyMapping--
+		}
+		if t.inhibitAnyPolicy > 0 {
+			t.inhibitAnyPolicy--
+		}
+	}
+
+	if c := ext.Constraints.RequireExplicitPolicy; c >= 0 && c < t.explicitPolicy {
+		t.explicitPolicy = c
+	}
+	if c := ext.Constraints.InhibitPolicyMapping; c >= 0 && c < t.policyMapping {
+		t.policyMapping = c
+	}
+	if c := ext.InhibitAnyPolicy; c >= 0 && c < t.inhibitAnyPolicy {
+		t.inhibitAnyPolicy = c
+	}
+}
+
+// Wrap up processing once the end entity certificate has been processed,
+// as described in RFC 5280 section 6.1.5 (a), (b) and (g).
+func (t *policyTree) wrapUp(ext *policyExtensions, initialPolicies []string) error {
+	if t.explicitPolicy > 0 {
+		t.explicitPolicy--
+	}
+	if ext.Constraints.RequireExplicitPolicy == 0 {
+		t.explicitPolicy = 0
+	}
+
+	if t.root != nil && len(initialPolicies) != 0 {
+		initial := map[string]bool{}
+		for _, policy := range initialPolicies {
+			initial[policy] = true
+		}
+
+		// The valid_policy_node_set is every node whose parent is an
+		// anyPolicy node. Their valid_policy is in the trust anchor's
+		// domain, so that's what gets compared to the initial set.
+		seen := map[string]bool{}
+		for depth := 1; depth <= t.length; depth++ {
+			for _, node := range t.nodesAt(depth) {
+				if node.parent.validPolicy != anyPolicy || node.validPolicy == anyPolicy {
+					continue
+				}
+				if !initial[node.validPolicy] {
+					node.remove()
+					continue
+				}
+				seen[node.validPolicy] = true
+			}
+		}
+
+		for _, node := range t.nodesAt(t.length) {
+			if node.validPolicy != anyPolicy {
+				continue
+			}
+			for _, policy := range initialPolicies {
+				if !seen[policy] {
+					node.parent.addChild(policy, []string{policy})
+				}
+			}
+			node.remove()
+		}
+
+		t.prune(t.length - 1)
+	}
+
+	if t.explicitPolicy <= 0 && t.root == nil {
+		return fmt.Errorf("piv: no valid policies, and an explicit policy is required")
+	}
+	return nil
+}
+
+// processPolicies will run the RFC 5280 section 6.1 policy processing
+// over the chain, as returned by x509.Certificate.Verify (that is to say,
+// starting with the end entity, and ending with the trust anchor), and
+// return the resulting valid_policy_tree.
+func processPolicies(chain []*x509.Certificate, opts PolicyOptions) (*policyTree, error) {
+	if len(chain) < 2 {
+		return nil, fmt.Errorf("piv: chain must include at least the end entity and trust anchor")
+	}
+
+	// RFC 5280 numbers the path from the certificate issued by the trust
+	// anchor (1) to the end entity (n), and doesn't include the anchor.
+	path := []*x509.Certificate{}
+	for i := len(chain) - 2; i >= 0; i-- {
+		path = append(path, chain[i])
+	}
+
+	tree := newPolicyTree(len(path), opts)
+	for i, cert := range path {
+		ext, err := parsePolicyExtensions(cert)
+		if err != nil {
+			return nil, err
+		}
+		if err := tree.processCertificate(cert); err != nil {
+			return nil, err
+		}
+		if i == len(path)-1 {
+			if err := tree.wrapUp(ext, oidStrings(opts.InitialPolicies)); err != nil {
+				return nil, err
+			}
+			break
+		}
+		tree.prepareNext(cert, ext)
+	}
+
+	return tree, nil
+}
+
+// Return the valid_policy of every node at the bottom of the tree, which
+// are the end entity's policies that are valid for this path.
+func (t *policyTree) leafPolicies() []asn1.ObjectIdentifier {
+	policies := []string{}
+	for _, node := range t.nodesAt(t.length) {
+		policies = append(policies, node.validPolicy)
+	}
+	return uniqueOIDs(policies)
+}
+
+// Return the policies of the end entity, mapped into the trust anchor's
+// domain. For each node at the bottom of the tree, this is the valid_policy
+// of the ancestor whose parent is anyPolicy, since that's the node that
+// was created by a Certificate asserting a policy from the anchor's domain.
+func (t *policyTree) authorityPolicies() []asn1.ObjectIdentifier {
+	policies := []string{}
+	for _, node := range t.nodesAt(t.length) {
+		for ; node.parent != nil; node = node.parent {
+			if node.validPolicy != anyPolicy && node.parent.validPolicy == anyPolicy {
+				policies = append(policies, node.validPolicy)
+				break
+			}
+		}
+	}
+	return uniqueOIDs(policies)
+}
+
+// Turn a list of dotted decimal OIDs into ObjectIdentifiers, dropping any
+// duplicates, and anyPolicy, which is never interesting to a caller.
+func uniqueOIDs(policies []string) []asn1.ObjectIdentifier {
+	ret := []asn1.ObjectIdentifier{}
+	seen := map[string]bool{anyPolicy: true}
+	for _, policy := range policies {
+		if seen[policy] {
+			continue
+		}
+		seen[policy] = true
+		id, err := parseOID(policy)
+		if err != nil {
+			continue
+		}
+		ret = append(ret, id)
+	}
+	return ret
+}
+
+// PolicyOptions are the RFC 5280 section 6.1.1 inputs to policy processing
+// that a relying party may set.
+type PolicyOptions struct {
+	// Policies (in the trust anchor's domain) that are acceptable to the
+	// relying party, the user-initial-policy-set from RFC 5280. If this is
+	// empty, any policy is acceptable.
+	InitialPolicies []asn1.ObjectIdentifier
+
+	// If true, the path must be valid for at least one policy in the
+	// InitialPolicies, the RFC 5280 initial-explicit-policy.
+	InitialExplicitPolicy bool
+
+	// If true, policy mapping is not permitted in the path, the RFC 5280
+	// initial-policy-mapping-inhibit.
+	InitialPolicyMappingInhibit bool
+
+	// If true, the anyPolicy OID won't be considered a match for any other
+	// policy, the RFC 5280 initial-any-policy-inhibit.
+	InitialAnyPolicyInhibit bool
+}
+
+// EvaluatedPolicies are the result of RFC 5280 policy processing over a
+// certification path.
+type EvaluatedPolicies struct {
+	// Policies asserted by the end entity Certificate that are valid for
+	// the path. These are in the end entity's own domain, so for a card
+	// from a cross-certified issuer, these will be the issuer's policies.
+	Asserted Policies
+
+	// Policies that the end entity Certificate is valid for, mapped into
+	// the trust anchor's domain. When trusting the Federal Common Policy
+	// CA, this will be the Common policies (such as commonAuth) that the
+	// issuer's policies were mapped to, which is what a relying party
+	// ought to be making decisions on.
+	Effective Policies
+}
+
+// EvaluatePolicies will run the RFC 5280 section 6.1 policy processing
+// over the chain, which starts with the end entity, and ends with the trust
+// anchor, exactly as returned by x509.Certificate.Verify. The chain's
+// signatures and validity periods are not checked.
+//
+// Any policies not known to this package are not returned, but still
+// take part in processing.
+func EvaluatePolicies(chain []*x509.Certificate, opts PolicyOptions) (*EvaluatedPolicies, error) {
+	tree, err := processPolicies(chain, opts)
+	if err != nil {
+		return nil, err
+	}
+	return &EvaluatedPolicies{
+		Asserted:  ParsePolicies(tree.leafPolicies()),
+		Effective: ParsePolicies(tree.authorityPolicies()),
+	}, nil
+}
+
+// vim: foldmethod=marker
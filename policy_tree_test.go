@@ -0,0 +1,226 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+)
+
+var (
+	oidAnyPolicy      = asn1.ObjectIdentifier{2, 5, 29, 32, 0}
+	oidCommonAuth     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 13}
+	oidCommonHigh     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 16}
+	oidDoDMedium      = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 2, 1, 11, 5}
+	oidPolicyMappings = asn1.ObjectIdentifier{2, 5, 29, 33}
+)
+
+// policyMapping is an entry of the RFC 5280 PolicyMappings extension.
+type policyMapping struct {
+	IssuerDomainPolicy  asn1.ObjectIdentifier
+	SubjectDomainPolicy asn1.ObjectIdentifier
+}
+
+// policyCertificate creates a throwaway Certificate asserting the policies,
+// issued by the parent, or self signed if the parent is nil.
+func policyCertificate(
+	t *testing.T,
+	name string,
+	parent *x509.Certificate,
+	parentKey crypto.Signer,
+	policies []asn1.ObjectIdentifier,
+	mappings ...policyMapping,
+) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		PolicyIdentifiers:     policies,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	if mappings != nil {
+		value, err := asn1.Marshal(mappings)
+		if err != nil {
+			t.Fatal(err)
+		}
+		template.ExtraExtensions = []pkix.Extension{{Id: oidPolicyMappings, Value: value}}
+	}
+	if parent == nil {
+		parent, parentKey = &template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// crossCertifiedChain builds a chain from a leaf asserting dodMedium, through
+// a cross certificate mapping commonAuth to dodMedium, to an anchor.
+func crossCertifiedChain(t *testing.T) []*x509.Certificate {
+	t.Helper()
+	anchor, anchorKey := policyCertificate(t, "Anchor", nil, nil, nil)
+	cross, crossKey := policyCertificate(
+		t, "Cross", anchor, anchorKey, []asn1.ObjectIdentifier{oidCommonAuth},
+		policyMapping{oidCommonAuth, oidDoDMedium},
+	)
+	leaf, _ := policyCertificate(t, "Leaf", cross, crossKey, []asn1.ObjectIdentifier{oidDoDMedium})
+	return []*x509.Certificate{leaf, cross, anchor}
+}
+
+// policyNames returns the names of the Policies, for comparing.
+func policyNames(policies piv.Policies) []string {
+	names := []string{}
+	for _, policy := range policies {
+		names = append(names, policy.Name)
+	}
+	return names
+}
+
+func checkPolicies(t *testing.T, name string, got piv.Policies, want ...string) {
+	t.Helper()
+	names := policyNames(got)
+	if len(names) != len(want) {
+		t.Fatalf("%s: got %v, want %v", name, names, want)
+	}
+	for i := range want {
+		if names[i] != want[i] {
+			t.Fatalf("%s: got %v, want %v", name, names, want)
+		}
+	}
+}
+
+func TestEvaluatePoliciesMapping(t *testing.T) {
+	chain := crossCertifiedChain(t)
+
+	policies, err := piv.EvaluatePolicies(chain, piv.PolicyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "asserted", policies.Asserted, "dodMedium")
+	checkPolicies(t, "effective", policies.Effective, "commonAuth")
+
+	policies, err = piv.EvaluatePolicies(chain, piv.PolicyOptions{
+		InitialPolicies:       []asn1.ObjectIdentifier{oidCommonAuth},
+		InitialExplicitPolicy: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "effective with initial policies", policies.Effective, "commonAuth")
+}
+
+func TestEvaluatePoliciesInhibitMapping(t *testing.T) {
+	chain := crossCertifiedChain(t)
+
+	policies, err := piv.EvaluatePolicies(chain, piv.PolicyOptions{InitialPolicyMappingInhibit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "asserted", policies.Asserted)
+	checkPolicies(t, "effective", policies.Effective)
+
+	_, err = piv.EvaluatePolicies(chain, piv.PolicyOptions{
+		InitialPolicyMappingInhibit: true,
+		InitialExplicitPolicy:       true,
+	})
+	if err == nil {
+		t.Fatal("mapped policy was valid with mapping inhibited, and an explicit policy required")
+	}
+}
+
+func TestEvaluatePoliciesInitialPolicies(t *testing.T) {
+	chain := crossCertifiedChain(t)
+	opts := piv.PolicyOptions{InitialPolicies: []asn1.ObjectIdentifier{oidCommonHigh}}
+
+	policies, err := piv.EvaluatePolicies(chain, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "effective", policies.Effective)
+
+	opts.InitialExplicitPolicy = true
+	if _, err := piv.EvaluatePolicies(chain, opts); err == nil {
+		t.Fatal("path was valid without any of the initial policies")
+	}
+}
+
+func TestEvaluatePoliciesAnyPolicy(t *testing.T) {
+	anchor, anchorKey := policyCertificate(t, "Anchor", nil, nil, nil)
+	intermediate, intermediateKey := policyCertificate(
+		t, "Intermediate", anchor, anchorKey, []asn1.ObjectIdentifier{oidAnyPolicy},
+	)
+	leaf, _ := policyCertificate(t, "Leaf", intermediate, intermediateKey, []asn1.ObjectIdentifier{oidCommonAuth})
+	chain := []*x509.Certificate{leaf, intermediate, anchor}
+
+	policies, err := piv.EvaluatePolicies(chain, piv.PolicyOptions{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "asserted", policies.Asserted, "commonAuth")
+	checkPolicies(t, "effective", policies.Effective, "commonAuth")
+
+	// With anyPolicy inhibited, the intermediate doesn't pass anything on.
+	policies, err = piv.EvaluatePolicies(chain, piv.PolicyOptions{InitialAnyPolicyInhibit: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	checkPolicies(t, "asserted with anyPolicy inhibited", policies.Asserted)
+}
+
+func TestEvaluatePoliciesMalformed(t *testing.T) {
+	anchor, anchorKey := policyCertificate(t, "Anchor", nil, nil, nil)
+	cross, crossKey := policyCertificate(
+		t, "Cross", anchor, anchorKey, []asn1.ObjectIdentifier{oidCommonAuth},
+		policyMapping{oidAnyPolicy, oidDoDMedium},
+	)
+	leaf, _ := policyCertificate(t, "Leaf", cross, crossKey, []asn1.ObjectIdentifier{oidDoDMedium})
+
+	if _, err := piv.EvaluatePolicies([]*x509.Certificate{leaf, cross, anchor}, piv.PolicyOptions{}); err == nil {
+		t.Fatal("mapping of anyPolicy was accepted")
+	}
+	if _, err := piv.EvaluatePolicies([]*x509.Certificate{leaf}, piv.PolicyOptions{}); err == nil {
+		t.Fatal("chain without a trust anchor was accepted")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,155 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"pault.ag/go/piv/tlv"
+)
+
+// PrintedInformation is the Printed Information data object, which holds a
+// copy of the text printed on the face of the card. Unlike most of the data
+// objects, this may only be read after the PIN has been verified.
+type PrintedInformation struct {
+	// Entire Printed Information, as read from the card, without the
+	// outer container TLV wrapper.
+	Raw []byte
+
+	// Cardholder's name, as printed on the card.
+	Name string
+
+	// Employee affiliation, such as "Employee" or "Contractor".
+	EmployeeAffiliation string
+
+	// Date after which the card is no longer valid, as printed on the card.
+	Expiration time.Time
+
+	// Serial number the issuing agency assigned to the card.
+	AgencyCardSerialNumber string
+
+	// Identifies the card issuer.
+	IssuerIdentification string
+
+	// Optional organizational affiliation, printed over up to two lines.
+	// Missing lines are empty.
+	OrganizationAffiliationLine1 string
+	OrganizationAffiliationLine2 string
+}
+
+// Tags of the data elements in the Printed Information, as defined in
+// SP 800-73-4.
+const (
+	printedTagName                         tlv.Tag = 0x01
+	printedTagEmployeeAffiliation          tlv.Tag = 0x02
+	printedTagExpiration                   tlv.Tag = 0x04
+	printedTagAgencyCardSerialNumber       tlv.Tag = 0x05
+	printedTagIssuerIdentification         tlv.Tag = 0x06
+	printedTagOrganizationAffiliationLine1 tlv.Tag = 0x07
+	printedTagOrganizationAffiliationLine2 tlv.Tag = 0x08
+	printedTagErrorDetectionCode           tlv.Tag = 0xFE
+)
+
+// printedText returns the text of a Printed Information element, without
+// any padding the issuer may have left on the end.
+func printedText(value []byte) string {
+	return strings.TrimRight(string(value), " \x00")
+}
+
+// parsePrintedExpiration parses the expiration date, which is written as
+// YYYYMMMDD, such as "2030JAN01".
+func parsePrintedExpiration(value string) (time.Time, error) {
+	if len(value) != 9 {
+		return time.Time{}, fmt.Errorf("piv: Printed Information expiration isn't 9 characters")
+	}
+	// Go's month names are only capitalized, not upper case.
+	value = value[:5] + strings.ToLower(value[5:7]) + value[7:]
+	return time.Parse("2006Jan02", value)
+}
+
+// ParsePrintedInformation will parse the Printed Information data object, as
+// read from the card.
+func ParsePrintedInformation(data []byte) (*PrintedInformation, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := PrintedInformation{Raw: data}
+	var seenName, seenAffiliation, seenExpiration, seenSerial, seenIssuer bool
+
+	for i, el := range elements {
+		switch el.Tag {
+		case printedTagName:
+			ret.Name = printedText(el.Value)
+			seenName = true
+		case printedTagEmployeeAffiliation:
+			ret.EmployeeAffiliation = printedText(el.Value)
+			seenAffiliation = true
+		case printedTagExpiration:
+			ret.Expiration, err = parsePrintedExpiration(printedText(el.Value))
+			if err != nil {
+				return nil, err
+			}
+			seenExpiration = true
+		case printedTagAgencyCardSerialNumber:
+			ret.AgencyCardSerialNumber = printedText(el.Value)
+			seenSerial = true
+		case printedTagIssuerIdentification:
+			ret.IssuerIdentification = printedText(el.Value)
+			seenIssuer = true
+		case printedTagOrganizationAffiliationLine1:
+			ret.OrganizationAffiliationLine1 = printedText(el.Value)
+		case printedTagOrganizationAffiliationLine2:
+			ret.OrganizationAffiliationLine2 = printedText(el.Value)
+		case printedTagErrorDetectionCode:
+			if i != len(elements)-1 {
+				return nil, fmt.Errorf("piv: Printed Information has trailing data after the error detection code")
+			}
+		default:
+			return nil, fmt.Errorf("piv: Printed Information has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenName:
+		return nil, fmt.Errorf("piv: Printed Information is missing the name")
+	case !seenAffiliation:
+		return nil, fmt.Errorf("piv: Printed Information is missing the employee affiliation")
+	case !seenExpiration:
+		return nil, fmt.Errorf("piv: Printed Information is missing the expiration date")
+	case !seenSerial:
+		return nil, fmt.Errorf("piv: Printed Information is missing the agency card serial number")
+	case !seenIssuer:
+		return nil, fmt.Errorf("piv: Printed Information is missing the issuer identification")
+	}
+
+	return &ret, nil
+}
+
+// vim: foldmethod=marker
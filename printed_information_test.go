@@ -0,0 +1,63 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestParsePrintedInformation(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{NotAfter: testNotAfter})
+
+	if _, err := token.PrintedInformation(); !errors.Is(err, piv.ErrAuthRequired) {
+		t.Fatalf("got %v, want piv.ErrAuthRequired before the PIN is verified", err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+
+	printed, err := token.PrintedInformation()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if printed.Name != "Synthetic Cardholder" || printed.EmployeeAffiliation != "Employee" {
+		t.Fatalf("got name %q, affiliation %q", printed.Name, printed.EmployeeAffiliation)
+	}
+	if !printed.Expiration.Equal(time.Date(2030, time.June, 1, 0, 0, 0, 0, time.UTC)) {
+		t.Fatalf("got expiration %s", printed.Expiration)
+	}
+	if printed.IssuerIdentification != "SOFTTOKEN" || printed.OrganizationAffiliationLine1 != "pault.ag" ||
+		printed.OrganizationAffiliationLine2 != "" {
+		t.Fatalf("got issuer %q, organization %q, %q", printed.IssuerIdentification,
+			printed.OrganizationAffiliationLine1, printed.OrganizationAffiliationLine2)
+	}
+
+	if _, err := piv.ParsePrintedInformation([]byte{0x01, 0x01, 'A', 0x04, 0x04, 'n', 'o', 'p', 'e'}); err == nil {
+		t.Fatal("Printed Information with a bad expiration was parsed")
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,609 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"pault.ag/go/piv"
+)
+
+// maxCRLSize is the largest CRL that will be downloaded. Some Federal CRLs
+// are very large indeed.
+const maxCRLSize = 128 * 1024 * 1024
+
+// CRLChecker will determine the revocation status of a Certificate by
+// fetching the CRLs named in its CRL Distribution Points extension.
+//
+// Partitioned CRLs (scoped by the Issuing Distribution Point extension),
+// indirect CRLs (issued by someone other than the Certificate's issuer), and
+// delta CRLs (named by the Freshest CRL extension) are all understood.
+//
+// Fetched CRLs are kept in memory, and in the Store if one is provided,
+// until their nextUpdate (or MaxAge, if that's sooner).
+//
+// A CRLChecker is safe for concurrent use.
+type CRLChecker struct {
+	// Client is used to fetch CRLs. If nil, http.DefaultClient will be
+	// used.
+	Client HTTPClient
+
+	// Store to keep fetched CRLs in. If nil, CRLs are only kept in memory.
+	Store CRLStore
+
+	// CRLIssuers are Certificates, other than the Certificate's issuer,
+	// that are trusted to sign indirect CRLs. Each must itself be issued
+	// by the Certificate's issuer.
+	CRLIssuers []*x509.Certificate
+
+	// MaxAge is the longest a CRL will be used for after it has been
+	// fetched, even if its nextUpdate has not yet passed. If zero, CRLs
+	// are used until their nextUpdate.
+	MaxAge time.Duration
+
+	// Now returns the time against which CRLs are evaluated. If nil,
+	// time.Now is used.
+	Now func() time.Time
+
+	lock  sync.Mutex
+	cache map[string]fetchedCRL
+}
+
+// fetchedCRL is a parsed CRL, along with the time it was fetched.
+type fetchedCRL struct {
+	list    *pkix.CertificateList
+	fetched time.Time
+}
+
+// NewCRLChecker will create a new CRLChecker that fetches CRLs using the
+// provided HTTPClient, and keeps them in the provided CRLStore.
+func NewCRLChecker(client HTTPClient, store CRLStore) *CRLChecker {
+	return &CRLChecker{Client: client, Store: store}
+}
+
+// Check will determine the revocation status of the provided Certificate,
+// which must have been issued by the provided issuer Certificate.
+//
+// The Distribution Points are consulted in turn, until CRLs covering every
+// revocation reason have been checked. If only some reasons could be
+// checked, and the Certificate was not found on any of those CRLs, the
+// Status will be Unknown. If the Certificate does not list any CRL
+// Distribution Points, NoResponder is returned.
+func (c *CRLChecker) Check(cert *piv.Certificate, issuer *x509.Certificate) (*Response, error) {
+	dps, err := parseDistributionPoints(cert.Extensions, oidCRLDistributionPoints)
+	if err != nil {
+		return nil, err
+	}
+	if len(dps) == 0 {
+		return nil, NoResponder
+	}
+
+	freshest, err := parseDistributionPoints(cert.Extensions, oidFreshestCRL)
+	if err != nil {
+		return nil, err
+	}
+
+	issuerName, err := canonicalName(cert.RawIssuer)
+	if err != nil {
+		return nil, err
+	}
+
+	now := c.now()
+	covered := reasonFlags(0)
+	resp := Response{Status: Unknown}
+	var lastErr error
+
+	for _, dp := range dps {
+		if covered == allReasons {
+			break
+		}
+
+		var (
+			scope *crlScope
+			dpErr error
+		)
+		for _, uri := range dp.URIs {
+			scope, dpErr = c.base(uri, dp, cert.Certificate, issuer, issuerName, now)
+			if dpErr == nil {
+				break
+			}
+		}
+		if scope == nil {
+			if dpErr != nil {
+				lastErr = dpErr
+			}
+			continue
+		}
+
+		if scope.reasons&^covered == 0 {
+			// Nothing new to learn from this CRL.
+			continue
+		}
+
+		entry, err := scope.lookup(cert.Certificate, issuerName)
+		if err != nil {
+			return nil, err
+		}
+
+		deltaDPs := freshest
+		if deltas, err := parseDistributionPoints(scope.list.TBSCertList.Extensions, oidFreshestCRL); err != nil {
+			return nil, err
+		} else if len(deltas) != 0 {
+			deltaDPs = deltas
+		}
+
+		if delta := c.delta(deltaDPs, scope, now); delta != nil {
+			deltaEntry, err := delta.lookup(cert.Certificate, issuerName)
+			if err != nil {
+				return nil, err
+			}
+			if deltaEntry != nil {
+				entry = deltaEntry
+			}
+			scope.thisUpdate, scope.nextUpdate = delta.thisUpdate, delta.nextUpdate
+		}
+
+		if entry != nil && entry.Reason != RemoveFromCRL {
+			entry.ThisUpdate = scope.thisUpdate
+			entry.NextUpdate = scope.nextUpdate
+			return entry, nil
+		}
+
+		covered |= scope.reasons
+		if resp.ThisUpdate.IsZero() || scope.thisUpdate.After(resp.ThisUpdate) {
+			resp.ThisUpdate = scope.thisUpdate
+		}
+		if resp.NextUpdate.IsZero() || scope.nextUpdate.Before(resp.NextUpdate) {
+			resp.NextUpdate = scope.nextUpdate
+		}
+	}
+
+	if covered == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("revocation: no usable CRL distribution point")
+		}
+		return nil, lastErr
+	}
+	if covered == allReasons {
+		resp.Status = Good
+	}
+	return &resp, nil
+}
+
+// crlScope is a validated CRL, along with what it is able to say about a
+// Certificate.
+type crlScope struct {
+	list     *pkix.CertificateList
+	signer   *x509.Certificate
+	issuer   string
+	indirect bool
+	number   *big.Int
+
+	// reasons this CRL covers for the Certificate.
+	reasons reasonFlags
+
+	thisUpdate time.Time
+	nextUpdate time.Time
+}
+
+// lookup will find the Certificate on the CRL, returning nil if it's not
+// there. For an indirect CRL, each entry's issuer is tracked using the
+// Certificate Issuer entry extension.
+func (s *crlScope) lookup(cert *x509.Certificate, issuerName string) (*Response, error) {
+	entryIssuer := s.issuer
+	for _, revoked := range s.list.TBSCertList.RevokedCertificates {
+		reason := Unspecified
+		for _, ext := range revoked.Extensions {
+			switch {
+			case ext.Id.Equal(oidReasonCode):
+				var code asn1.Enumerated
+				if _, err := asn1.Unmarshal(ext.Value, &code); err != nil {
+					return nil, err
+				}
+				reason = Reason(code)
+			case ext.Id.Equal(oidCertificateIssuer):
+				if !s.indirect {
+					return nil, fmt.Errorf("revocation: certificate issuer on a direct CRL")
+				}
+				var names asn1.RawValue
+				if _, err := asn1.Unmarshal(ext.Value, &names); err != nil {
+					return nil, err
+				}
+				_, dirNames, err := parseGeneralNamesSequence(names.Bytes)
+				if err != nil {
+					return nil, err
+				}
+				if len(dirNames) != 1 {
+					return nil, fmt.Errorf("revocation: certificate issuer does not name one directory")
+				}
+				entryIssuer = dirNames[0]
+			case ext.Id.Equal(oidInvalidityDate):
+			default:
+				if ext.Critical {
+					return nil, fmt.Errorf("revocation: unhandled critical CRL entry extension %s", ext.Id)
+				}
+			}
+		}
+
+		if entryIssuer != issuerName || revoked.SerialNumber == nil ||
+			revoked.SerialNumber.Cmp(cert.SerialNumber) != 0 {
+			continue
+		}
+
+		return &Response{
+			Status:    Revoked,
+			RevokedAt: revoked.RevocationTime,
+			Reason:    reason,
+		}, nil
+	}
+	return nil, nil
+}
+
+// base will fetch the CRL at the URI, and ensure that it's a complete CRL
+// for the Distribution Point, signed by someone able to speak for the
+// Certificate, and that it's in scope for the Certificate.
+func (c *CRLChecker) base(
+	uri string,
+	dp distributionPoint,
+	cert, issuer *x509.Certificate,
+	issuerName string,
+	now time.Time,
+) (*crlScope, error) {
+	scope, err := c.load(uri, dp.CRLIssuers, issuer, issuerName, now)
+	if err != nil {
+		return nil, err
+	}
+	if delta, err := parseInteger(scope.list.TBSCertList.Extensions, oidDeltaCRLIndicator); err != nil {
+		return nil, err
+	} else if delta != nil {
+		return nil, fmt.Errorf("revocation: %s is a delta CRL", uri)
+	}
+
+	idp, err := parseIssuingDistributionPoint(scope.list.TBSCertList.Extensions)
+	if err != nil {
+		return nil, err
+	}
+
+	scope.reasons = dp.Reasons
+	if idp == nil {
+		if scope.indirect {
+			return nil, fmt.Errorf("revocation: %s is not an indirect CRL", uri)
+		}
+		return scope, nil
+	}
+
+	if scope.indirect && !idp.IndirectCRL {
+		return nil, fmt.Errorf("revocation: %s is not an indirect CRL", uri)
+	}
+	if len(idp.URIs) != 0 && !intersects(idp.URIs, dp.URIs) {
+		return nil, fmt.Errorf("revocation: %s is for a different distribution point", uri)
+	}
+	if idp.OnlyContainsAttributeCerts ||
+		(idp.OnlyContainsUserCerts && cert.IsCA) ||
+		(idp.OnlyContainsCACerts && !cert.IsCA) {
+		return nil, fmt.Errorf("revocation: %s does not cover this certificate", uri)
+	}
+	scope.reasons &= idp.OnlySomeReasons
+	return scope, nil
+}
+
+// delta will find a fresh delta CRL for the base CRL, returning nil if there
+// isn't one. Delta CRLs are an optimization, so any problem fetching them
+// is not fatal; the base CRL is used alone.
+func (c *CRLChecker) delta(dps []distributionPoint, base *crlScope, now time.Time) *crlScope {
+	if base.number == nil {
+		return nil
+	}
+	for _, dp := range dps {
+		for _, uri := range dp.URIs {
+			scope, err := c.load(uri, nil, base.signer, base.issuer, now)
+			if err != nil {
+				continue
+			}
+			baseNumber, err := parseInteger(scope.list.TBSCertList.Extensions, oidDeltaCRLIndicator)
+			if err != nil || baseNumber == nil || baseNumber.Cmp(base.number) > 0 {
+				continue
+			}
+			if scope.number == nil || scope.number.Cmp(base.number) <= 0 {
+				continue
+			}
+			scope.indirect = base.indirect
+			scope.reasons = base.reasons
+			return scope
+		}
+	}
+	return nil
+}
+
+// load will fetch the CRL at the URI, verify its signature, and check that
+// it's current. If crlIssuers is not empty, the CRL is indirect, and must
+// be signed by one of the CRLChecker's CRLIssuers with one of those names.
+func (c *CRLChecker) load(
+	uri string,
+	crlIssuers []string,
+	issuer *x509.Certificate,
+	issuerName string,
+	now time.Time,
+) (*crlScope, error) {
+	return c.fetch(uri, now, func(list *pkix.CertificateList) (*crlScope, error) {
+		return c.verify(uri, list, crlIssuers, issuer, issuerName, now)
+	})
+}
+
+// verify will check the CRL's issuer, signature, validity period and
+// extensions, returning the scope it covers.
+func (c *CRLChecker) verify(
+	uri string,
+	list *pkix.CertificateList,
+	crlIssuers []string,
+	issuer *x509.Certificate,
+	issuerName string,
+	now time.Time,
+) (*crlScope, error) {
+	name, err := canonicalRDNSequence(list.TBSCertList.Issuer)
+	if err != nil {
+		return nil, err
+	}
+
+	scope := crlScope{
+		list:       list,
+		issuer:     name,
+		indirect:   len(crlIssuers) != 0,
+		thisUpdate: list.TBSCertList.ThisUpdate,
+		nextUpdate: list.TBSCertList.NextUpdate,
+	}
+
+	if scope.indirect {
+		if !contains(crlIssuers, name) {
+			return nil, fmt.Errorf("revocation: %s was issued by an unexpected CRL issuer", uri)
+		}
+		scope.signer, err = c.crlIssuer(name, issuer, issuerName)
+		if err != nil {
+			return nil, err
+		}
+	} else {
+		if name != issuerName {
+			return nil, fmt.Errorf("revocation: %s was not issued by the certificate's issuer", uri)
+		}
+		scope.signer = issuer
+	}
+
+	if scope.signer.KeyUsage != 0 && scope.signer.KeyUsage&x509.KeyUsageCRLSign == 0 {
+		return nil, fmt.Errorf("revocation: CRL issuer is not allowed to sign CRLs")
+	}
+	if err := scope.signer.CheckCRLSignature(list); err != nil {
+		return nil, fmt.Errorf("revocation: %s: %w", uri, err)
+	}
+
+	if scope.thisUpdate.After(now) {
+		return nil, fmt.Errorf("revocation: %s is not yet valid", uri)
+	}
+	if !scope.nextUpdate.IsZero() && now.After(scope.nextUpdate) {
+		return nil, fmt.Errorf("revocation: %s has expired", uri)
+	}
+
+	for _, ext := range list.TBSCertList.Extensions {
+		switch {
+		case ext.Id.Equal(oidCRLNumber),
+			ext.Id.Equal(oidDeltaCRLIndicator),
+			ext.Id.Equal(oidIssuingDistributionPoint),
+			ext.Id.Equal(oidFreshestCRL),
+			ext.Id.Equal(oidAuthorityKeyIdentifier):
+		default:
+			if ext.Critical {
+				return nil, fmt.Errorf("revocation: unhandled critical CRL extension %s", ext.Id)
+			}
+		}
+	}
+
+	scope.number, err = parseInteger(list.TBSCertList.Extensions, oidCRLNumber)
+	if err != nil {
+		return nil, err
+	}
+	return &scope, nil
+}
+
+// crlIssuer will find the Certificate that signed an indirect CRL, which
+// must have been issued by the Certificate's own issuer.
+func (c *CRLChecker) crlIssuer(
+	name string,
+	issuer *x509.Certificate,
+	issuerName string,
+) (*x509.Certificate, error) {
+	if name == issuerName {
+		return issuer, nil
+	}
+	for _, candidate := range c.CRLIssuers {
+		subject, err := canonicalName(candidate.RawSubject)
+		if err != nil || subject != name {
+			continue
+		}
+		if err := candidate.CheckSignatureFrom(issuer); err != nil {
+			continue
+		}
+		return candidate, nil
+	}
+	return nil, fmt.Errorf("revocation: no trusted certificate for the indirect CRL issuer")
+}
+
+// fetch will return the verified CRL at the URI, from memory, the Store or
+// the network, in that order, using the first one that's still fresh. A CRL
+// is only kept in memory or written to the Store once it has been verified,
+// and one that no longer verifies is replaced by a new download.
+func (c *CRLChecker) fetch(
+	uri string,
+	now time.Time,
+	verify func(*pkix.CertificateList) (*crlScope, error),
+) (*crlScope, error) {
+	if !strings.HasPrefix(uri, "http://") && !strings.HasPrefix(uri, "https://") {
+		return nil, fmt.Errorf("revocation: unsupported CRL location %s", uri)
+	}
+
+	c.lock.Lock()
+	cached, ok := c.cache[uri]
+	c.lock.Unlock()
+	if ok && c.fresh(cached, now) {
+		if scope, err := verify(cached.list); err == nil {
+			return scope, nil
+		}
+		c.forget(uri)
+	}
+
+	if c.Store != nil {
+		if der, fetched, err := c.Store.Get(uri); err == nil {
+			if list, err := parseCRL(der); err == nil {
+				stored := fetchedCRL{list: list, fetched: fetched}
+				if c.fresh(stored, now) {
+					if scope, err := verify(list); err == nil {
+						c.remember(uri, stored)
+						return scope, nil
+					}
+				}
+			}
+		} else if err != NotFound {
+			return nil, err
+		}
+	}
+
+	der, err := c.download(uri)
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseCRL(der)
+	if err != nil {
+		return nil, fmt.Errorf("revocation: %s: %w", uri, err)
+	}
+	scope, err := verify(list)
+	if err != nil {
+		return nil, err
+	}
+
+	if c.Store != nil {
+		if err := c.Store.Put(uri, der, now); err != nil {
+			return nil, err
+		}
+	}
+	c.remember(uri, fetchedCRL{list: list, fetched: now})
+	return scope, nil
+}
+
+// download will fetch the raw CRL from the URI.
+func (c *CRLChecker) download(uri string) ([]byte, error) {
+	req, err := http.NewRequest("GET", uri, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	client := c.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("revocation: fetching %s: %w", uri, err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("revocation: fetching %s returned %s", uri, resp.Status)
+	}
+	return ioutil.ReadAll(http.MaxBytesReader(nil, resp.Body, maxCRLSize))
+}
+
+// fresh will check to see if a previously fetched CRL may still be used.
+// CRLs without a nextUpdate are never reused.
+func (c *CRLChecker) fresh(crl fetchedCRL, now time.Time) bool {
+	nextUpdate := crl.list.TBSCertList.NextUpdate
+	if nextUpdate.IsZero() || !now.Before(nextUpdate) {
+		return false
+	}
+	if c.MaxAge != 0 && now.Sub(crl.fetched) >= c.MaxAge {
+		return false
+	}
+	return true
+}
+
+// remember will keep the parsed CRL in memory.
+func (c *CRLChecker) remember(uri string, crl fetchedCRL) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if c.cache == nil {
+		c.cache = map[string]fetchedCRL{}
+	}
+	c.cache[uri] = crl
+}
+
+// forget will drop the CRL from memory.
+func (c *CRLChecker) forget(uri string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	delete(c.cache, uri)
+}
+
+// now returns the current time, as understood by the CRLChecker.
+func (c *CRLChecker) now() time.Time {
+	if c.Now != nil {
+		return c.Now()
+	}
+	return time.Now()
+}
+
+// parseCRL will parse a DER encoded CRL.
+func parseCRL(der []byte) (*pkix.CertificateList, error) {
+	list := pkix.CertificateList{}
+	if rest, err := asn1.Unmarshal(der, &list); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("revocation: trailing data after CRL")
+	}
+	return &list, nil
+}
+
+// contains checks to see if the value is in the list.
+func contains(list []string, value string) bool {
+	for _, el := range list {
+		if el == value {
+			return true
+		}
+	}
+	return false
+}
+
+// intersects checks to see if any value in a is also in b.
+func intersects(a, b []string) bool {
+	for _, el := range a {
+		if contains(b, el) {
+			return true
+		}
+	}
+	return false
+}
+
+// vim: foldmethod=marker
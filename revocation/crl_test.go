@@ -0,0 +1,492 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation_test
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/revocation"
+)
+
+var (
+	oidCRLReasonCode         = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidDeltaCRLIndicator     = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidIssuingDistPoint      = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidCertificateIssuer     = asn1.ObjectIdentifier{2, 5, 29, 29}
+	oidCRLDistributionPoints = asn1.ObjectIdentifier{2, 5, 29, 31}
+	oidFreshestCRL           = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+// keyCompromiseOnly is a ReasonFlags BIT STRING with only keyCompromise set.
+var keyCompromiseOnly = asn1.BitString{Bytes: []byte{0x40}, BitLength: 2}
+
+// crlServer is an httptest.Server publishing CRLs by path, and counting how
+// many times each has been fetched.
+type crlServer struct {
+	*httptest.Server
+
+	lock    sync.Mutex
+	crls    map[string][]byte
+	fetches map[string]int
+}
+
+func newCRLServer(t *testing.T) *crlServer {
+	t.Helper()
+	s := &crlServer{crls: map[string][]byte{}, fetches: map[string]int{}}
+	s.Server = httptest.NewServer(s)
+	t.Cleanup(s.Close)
+	return s
+}
+
+// ServeHTTP answers with the CRL published at the path.
+func (s *crlServer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.fetches[req.URL.Path]++
+	der, ok := s.crls[req.URL.Path]
+	if !ok {
+		http.NotFound(w, req)
+		return
+	}
+	w.Header().Set("Content-Type", "application/pkix-crl")
+	w.Write(der)
+}
+
+// publish will serve the CRL at the path, returning its URL.
+func (s *crlServer) publish(path string, der []byte) string {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.crls[path] = der
+	return s.URL + path
+}
+
+// count returns the number of times the CRL at the path was fetched.
+func (s *crlServer) count(path string) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.fetches[path]
+}
+
+// newCA will create a self signed CA able to sign CRLs.
+func newCA(t *testing.T, name string) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	return issue(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+}
+
+// signCRL will create a CRL from the template, valid for the next hour
+// unless the template says otherwise.
+func signCRL(t *testing.T, template *x509.RevocationList, issuer *x509.Certificate, key crypto.Signer) []byte {
+	t.Helper()
+	if template.ThisUpdate.IsZero() {
+		template.ThisUpdate = time.Now().Add(-time.Minute)
+		template.NextUpdate = time.Now().Add(time.Hour)
+	}
+	if template.Number == nil {
+		template.Number = big.NewInt(1)
+	}
+	der, err := x509.CreateRevocationList(rand.Reader, template, issuer, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// revoked is a CRL entry for the serial, with a reasonCode extension
+// along with any other entry extensions.
+func revoked(t *testing.T, serial int64, reason revocation.Reason, extensions ...pkix.Extension) pkix.RevokedCertificate {
+	t.Helper()
+	code, err := asn1.Marshal(asn1.Enumerated(reason))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.RevokedCertificate{
+		SerialNumber:   big.NewInt(serial),
+		RevocationTime: time.Now().Add(-time.Minute).UTC().Truncate(time.Second),
+		Extensions:     append([]pkix.Extension{{Id: oidCRLReasonCode, Value: code}}, extensions...),
+	}
+}
+
+// distributionPointName is the DistributionPointName CHOICE.
+type distributionPointName struct {
+	FullName []asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+// distributionPoint is a single DistributionPoint SEQUENCE.
+type distributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reasons           asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+// issuingDistributionPoint is the Issuing Distribution Point extension.
+type issuingDistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	OnlySomeReasons   asn1.BitString        `asn1:"optional,tag:3"`
+	IndirectCRL       bool                  `asn1:"optional,tag:4"`
+}
+
+// uriName is the URI as a GeneralName.
+func uriName(uri string) distributionPointName {
+	return distributionPointName{FullName: []asn1.RawValue{{
+		Class: asn1.ClassContextSpecific,
+		Tag:   6,
+		Bytes: []byte(uri),
+	}}}
+}
+
+// directoryName will encode the subject of the Certificate as a
+// GeneralNames SEQUENCE holding a single directoryName, returning the
+// contents of that SEQUENCE.
+func directoryName(t *testing.T, cert *x509.Certificate) []byte {
+	t.Helper()
+	der, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        4,
+		IsCompound: true,
+		Bytes:      cert.RawSubject,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// extension will DER encode the value as an extension with the OID.
+func extension(t *testing.T, id asn1.ObjectIdentifier, critical bool, value interface{}) pkix.Extension {
+	t.Helper()
+	der, err := asn1.Marshal(value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pkix.Extension{Id: id, Critical: critical, Value: der}
+}
+
+// leafWith will issue an end entity Certificate from the CA, carrying the
+// extensions.
+func leafWith(t *testing.T, serial int64, ca *x509.Certificate, caKey crypto.Signer, extensions ...pkix.Extension) *piv.Certificate {
+	t.Helper()
+	cert, _ := issue(t, &x509.Certificate{
+		SerialNumber:    big.NewInt(serial),
+		Subject:         pkix.Name{CommonName: "Test Cardholder"},
+		ExtraExtensions: extensions,
+	}, ca, caKey)
+	pivCert, err := piv.NewCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pivCert
+}
+
+// checkCRL will check the Certificate, failing the test on error.
+func checkCRL(t *testing.T, checker *revocation.CRLChecker, cert *piv.Certificate, issuer *x509.Certificate) *revocation.Response {
+	t.Helper()
+	resp, err := checker.Check(cert, issuer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return resp
+}
+
+func TestCRLDirect(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	uri := s.publish("/ca.crl", signCRL(t, &x509.RevocationList{
+		RevokedCertificates: []pkix.RevokedCertificate{
+			revoked(t, 101, revocation.KeyCompromise),
+		},
+	}, ca, caKey))
+	dps := extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(uri),
+	}})
+
+	checker := revocation.NewCRLChecker(s.Client(), nil)
+	for _, test := range []struct {
+		name   string
+		serial int64
+		want   revocation.Status
+	}{
+		{"good", 100, revocation.Good},
+		{"revoked", 101, revocation.Revoked},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := checkCRL(t, checker, leafWith(t, test.serial, ca, caKey, dps), ca)
+			if resp.Status != test.want {
+				t.Fatalf("got status %s, want %s", resp.Status, test.want)
+			}
+			if test.want == revocation.Revoked && resp.Reason != revocation.KeyCompromise {
+				t.Fatalf("got reason %d, want KeyCompromise", resp.Reason)
+			}
+			if resp.NextUpdate.IsZero() {
+				t.Fatal("response has no nextUpdate")
+			}
+		})
+	}
+
+	if n := s.count("/ca.crl"); n != 1 {
+		t.Fatalf("CRL was fetched %d times, want 1", n)
+	}
+
+	t.Run("no distribution points", func(t *testing.T) {
+		if _, err := checker.Check(leafWith(t, 102, ca, caKey), ca); err != revocation.NoResponder {
+			t.Fatalf("got %v, want NoResponder", err)
+		}
+	})
+
+	t.Run("wrong issuer", func(t *testing.T) {
+		other, otherKey := newCA(t, "Other CA")
+		_, err := revocation.NewCRLChecker(s.Client(), nil).Check(leafWith(t, 103, other, otherKey, dps), other)
+		if err == nil {
+			t.Fatal("CRL from another issuer was accepted")
+		}
+	})
+}
+
+func TestCRLDelta(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	deltaURI := s.URL + "/delta.crl"
+
+	baseURI := s.publish("/base.crl", signCRL(t, &x509.RevocationList{
+		Number: big.NewInt(1),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			revoked(t, 200, revocation.CertificateHold),
+			revoked(t, 201, revocation.CertificateHold),
+		},
+		ExtraExtensions: []pkix.Extension{
+			extension(t, oidFreshestCRL, false, []distributionPoint{{
+				DistributionPoint: uriName(deltaURI),
+			}}),
+		},
+	}, ca, caKey))
+	s.publish("/delta.crl", signCRL(t, &x509.RevocationList{
+		Number: big.NewInt(2),
+		RevokedCertificates: []pkix.RevokedCertificate{
+			revoked(t, 200, revocation.RemoveFromCRL),
+			revoked(t, 202, revocation.KeyCompromise),
+		},
+		ExtraExtensions: []pkix.Extension{
+			extension(t, oidDeltaCRLIndicator, true, 1),
+		},
+	}, ca, caKey))
+
+	dps := extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(baseURI),
+	}})
+	checker := revocation.NewCRLChecker(s.Client(), nil)
+
+	for _, test := range []struct {
+		name   string
+		serial int64
+		want   revocation.Status
+		reason revocation.Reason
+	}{
+		{"removed", 200, revocation.Good, 0},
+		{"held", 201, revocation.Revoked, revocation.CertificateHold},
+		{"revoked by the delta", 202, revocation.Revoked, revocation.KeyCompromise},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			resp := checkCRL(t, checker, leafWith(t, test.serial, ca, caKey, dps), ca)
+			if resp.Status != test.want {
+				t.Fatalf("got status %s, want %s", resp.Status, test.want)
+			}
+			if test.want == revocation.Revoked && resp.Reason != test.reason {
+				t.Fatalf("got reason %d, want %d", resp.Reason, test.reason)
+			}
+		})
+	}
+
+	t.Run("delta as a base", func(t *testing.T) {
+		dps := extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+			DistributionPoint: uriName(deltaURI),
+		}})
+		if _, err := checker.Check(leafWith(t, 203, ca, caKey, dps), ca); err == nil {
+			t.Fatal("delta CRL was used as a complete CRL")
+		}
+	})
+}
+
+func TestCRLPartitioned(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	uri := s.URL + "/keycompromise.crl"
+	s.publish("/keycompromise.crl", signCRL(t, &x509.RevocationList{
+		ExtraExtensions: []pkix.Extension{
+			extension(t, oidIssuingDistPoint, true, issuingDistributionPoint{
+				DistributionPoint: uriName(uri),
+				OnlySomeReasons:   keyCompromiseOnly,
+			}),
+		},
+	}, ca, caKey))
+	dps := extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(uri),
+	}})
+
+	// The only CRL covers keyCompromise, so nothing can be said about the
+	// other reasons.
+	resp := checkCRL(t, revocation.NewCRLChecker(s.Client(), nil), leafWith(t, 300, ca, caKey, dps), ca)
+	if resp.Status != revocation.Unknown {
+		t.Fatalf("got status %s, want unknown", resp.Status)
+	}
+}
+
+func TestCRLIndirect(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	signer, signerKey := issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "Test CRL Issuer"},
+		SubjectKeyId: []byte{1, 2, 3, 4},
+		KeyUsage:     x509.KeyUsageCRLSign,
+	}, ca, caKey)
+
+	uri := s.URL + "/indirect.crl"
+	s.publish("/indirect.crl", signCRL(t, &x509.RevocationList{
+		RevokedCertificates: []pkix.RevokedCertificate{
+			// Revoked by the CRL issuer itself, so not the leaf.
+			revoked(t, 400, revocation.Superseded),
+			revoked(t, 400, revocation.KeyCompromise, pkix.Extension{
+				Id:       oidCertificateIssuer,
+				Critical: true,
+				Value: func() []byte {
+					der, err := asn1.Marshal(asn1.RawValue{
+						Class:      asn1.ClassUniversal,
+						Tag:        asn1.TagSequence,
+						IsCompound: true,
+						Bytes:      directoryName(t, ca),
+					})
+					if err != nil {
+						t.Fatal(err)
+					}
+					return der
+				}(),
+			}),
+		},
+		ExtraExtensions: []pkix.Extension{
+			extension(t, oidIssuingDistPoint, true, issuingDistributionPoint{
+				DistributionPoint: uriName(uri),
+				IndirectCRL:       true,
+			}),
+		},
+	}, signer, signerKey))
+
+	dps := extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(uri),
+		CRLIssuer: asn1.RawValue{
+			Class:      asn1.ClassContextSpecific,
+			Tag:        2,
+			IsCompound: true,
+			Bytes:      directoryName(t, signer),
+		},
+	}})
+
+	checker := revocation.NewCRLChecker(s.Client(), nil)
+	checker.CRLIssuers = []*x509.Certificate{signer}
+
+	resp := checkCRL(t, checker, leafWith(t, 400, ca, caKey, dps), ca)
+	if resp.Status != revocation.Revoked || resp.Reason != revocation.KeyCompromise {
+		t.Fatalf("got status %s, reason %d, want revoked for KeyCompromise", resp.Status, resp.Reason)
+	}
+	resp = checkCRL(t, checker, leafWith(t, 401, ca, caKey, dps), ca)
+	if resp.Status != revocation.Good {
+		t.Fatalf("got status %s, want good", resp.Status)
+	}
+
+	t.Run("untrusted issuer", func(t *testing.T) {
+		checker := revocation.NewCRLChecker(s.Client(), nil)
+		if _, err := checker.Check(leafWith(t, 402, ca, caKey, dps), ca); err == nil {
+			t.Fatal("indirect CRL from an untrusted issuer was accepted")
+		}
+	})
+}
+
+func TestCRLForged(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	// A CA with the same name, but not the same key.
+	impostor, impostorKey := newCA(t, "Test CA")
+
+	genuine := signCRL(t, &x509.RevocationList{
+		RevokedCertificates: []pkix.RevokedCertificate{
+			revoked(t, 501, revocation.KeyCompromise),
+		},
+	}, ca, caKey)
+	forged := signCRL(t, &x509.RevocationList{}, impostor, impostorKey)
+
+	uri := s.publish("/ca.crl", forged)
+	cert := leafWith(t, 501, ca, caKey, extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(uri),
+	}}))
+	store := revocation.DirStore(t.TempDir())
+	checker := revocation.NewCRLChecker(s.Client(), store)
+
+	if _, err := checker.Check(cert, ca); err == nil {
+		t.Fatal("forged CRL was accepted")
+	}
+	if _, _, err := store.Get(uri); err != revocation.NotFound {
+		t.Fatalf("forged CRL was stored: %v", err)
+	}
+
+	// Neither memory nor the Store holds on to the forged CRL.
+	s.publish("/ca.crl", genuine)
+	if resp := checkCRL(t, checker, cert, ca); resp.Status != revocation.Revoked {
+		t.Fatalf("got status %s, want revoked", resp.Status)
+	}
+	if n := s.count("/ca.crl"); n != 2 {
+		t.Fatalf("CRL was fetched %d times, want 2", n)
+	}
+
+	t.Run("stored", func(t *testing.T) {
+		// A forged CRL already in the Store is replaced by a download.
+		store := revocation.DirStore(t.TempDir())
+		if err := store.Put(uri, forged, time.Now()); err != nil {
+			t.Fatal(err)
+		}
+		checker := revocation.NewCRLChecker(s.Client(), store)
+		if resp := checkCRL(t, checker, cert, ca); resp.Status != revocation.Revoked {
+			t.Fatalf("got status %s, want revoked", resp.Status)
+		}
+		if der, _, err := store.Get(uri); err != nil {
+			t.Fatal(err)
+		} else if !bytes.Equal(der, genuine) {
+			t.Fatal("forged CRL was left in the Store")
+		}
+	})
+}
+
+// vim: foldmethod=marker
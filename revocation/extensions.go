@@ -0,0 +1,271 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation
+
+import (
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"math/big"
+)
+
+var (
+	oidCRLNumber                = asn1.ObjectIdentifier{2, 5, 29, 20}
+	oidReasonCode               = asn1.ObjectIdentifier{2, 5, 29, 21}
+	oidInvalidityDate           = asn1.ObjectIdentifier{2, 5, 29, 24}
+	oidDeltaCRLIndicator        = asn1.ObjectIdentifier{2, 5, 29, 27}
+	oidIssuingDistributionPoint = asn1.ObjectIdentifier{2, 5, 29, 28}
+	oidCertificateIssuer        = asn1.ObjectIdentifier{2, 5, 29, 29}
+	oidCRLDistributionPoints    = asn1.ObjectIdentifier{2, 5, 29, 31}
+	oidAuthorityKeyIdentifier   = asn1.ObjectIdentifier{2, 5, 29, 35}
+	oidFreshestCRL              = asn1.ObjectIdentifier{2, 5, 29, 46}
+)
+
+const (
+	generalNameDirectoryName = 4
+	generalNameURI           = 6
+)
+
+// reasonFlags is a bitmask of the ReasonFlags (RFC 5280, Section 4.2.1.13)
+// a CRL covers, with bit n set for the nth bit of the BIT STRING.
+type reasonFlags uint16
+
+// allReasons is every reason a CRL can cover. Bit 0 ("unused") is never
+// set.
+const allReasons reasonFlags = 0x1FE
+
+// parseReasonFlags will turn the ReasonFlags BIT STRING into a bitmask. An
+// absent BIT STRING covers all reasons.
+func parseReasonFlags(bits asn1.BitString) reasonFlags {
+	if bits.BitLength == 0 {
+		return allReasons
+	}
+	var flags reasonFlags
+	for i := 1; i <= 8; i++ {
+		if bits.At(i) == 1 {
+			flags |= 1 << uint(i)
+		}
+	}
+	return flags
+}
+
+// distributionPointName is the DistributionPointName CHOICE.
+type distributionPointName struct {
+	FullName     []asn1.RawValue  `asn1:"optional,tag:0"`
+	RelativeName pkix.RDNSequence `asn1:"optional,tag:1"`
+}
+
+// rawDistributionPoint is the DistributionPoint SEQUENCE, as found in the
+// CRL Distribution Points and Freshest CRL extensions.
+type rawDistributionPoint struct {
+	DistributionPoint distributionPointName `asn1:"optional,tag:0"`
+	Reasons           asn1.BitString        `asn1:"optional,tag:1"`
+	CRLIssuer         asn1.RawValue         `asn1:"optional,tag:2"`
+}
+
+// rawIssuingDistributionPoint is the Issuing Distribution Point CRL
+// extension, from RFC 5280, Section 5.2.5.
+type rawIssuingDistributionPoint struct {
+	DistributionPoint          distributionPointName `asn1:"optional,tag:0"`
+	OnlyContainsUserCerts      bool                  `asn1:"optional,tag:1"`
+	OnlyContainsCACerts        bool                  `asn1:"optional,tag:2"`
+	OnlySomeReasons            asn1.BitString        `asn1:"optional,tag:3"`
+	IndirectCRL                bool                  `asn1:"optional,tag:4"`
+	OnlyContainsAttributeCerts bool                  `asn1:"optional,tag:5"`
+}
+
+// distributionPoint is a parsed DistributionPoint, containing only the
+// parts this package is able to make use of.
+type distributionPoint struct {
+	// URIs the CRL may be fetched from.
+	URIs []string
+
+	// Reasons covered by the CRL.
+	Reasons reasonFlags
+
+	// CRLIssuers, as canonical Names, if this Distribution Point is for an
+	// indirect CRL. If empty, the CRL is issued by the Certificate's
+	// issuer.
+	CRLIssuers []string
+}
+
+// issuingDistributionPoint is the parsed Issuing Distribution Point CRL
+// extension.
+type issuingDistributionPoint struct {
+	URIs                       []string
+	OnlyContainsUserCerts      bool
+	OnlyContainsCACerts        bool
+	OnlySomeReasons            reasonFlags
+	IndirectCRL                bool
+	OnlyContainsAttributeCerts bool
+}
+
+// parseGeneralNames will pull the URIs and directoryNames out of the
+// contents of a GeneralNames SEQUENCE. Other name forms are ignored.
+func parseGeneralNames(names []asn1.RawValue) ([]string, []string, error) {
+	uris := []string{}
+	dirNames := []string{}
+	for _, name := range names {
+		if name.Class != asn1.ClassContextSpecific {
+			continue
+		}
+		switch name.Tag {
+		case generalNameURI:
+			uris = append(uris, string(name.Bytes))
+		case generalNameDirectoryName:
+			dirName, err := canonicalName(name.Bytes)
+			if err != nil {
+				return nil, nil, err
+			}
+			dirNames = append(dirNames, dirName)
+		}
+	}
+	return uris, dirNames, nil
+}
+
+// parseGeneralNamesSequence is parseGeneralNames, for a DER encoded
+// GeneralNames SEQUENCE or an implicitly tagged GeneralNames.
+func parseGeneralNamesSequence(data []byte) ([]string, []string, error) {
+	names := []asn1.RawValue{}
+	for len(data) > 0 {
+		var name asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &name)
+		if err != nil {
+			return nil, nil, err
+		}
+		names = append(names, name)
+		data = rest
+	}
+	return parseGeneralNames(names)
+}
+
+// parseDistributionPoints will parse the CRL Distribution Points or
+// Freshest CRL extension with the provided OID out of the extensions.
+func parseDistributionPoints(
+	extensions []pkix.Extension,
+	id asn1.ObjectIdentifier,
+) ([]distributionPoint, error) {
+	ret := []distributionPoint{}
+	for _, ext := range extensions {
+		if !ext.Id.Equal(id) {
+			continue
+		}
+
+		raw := []rawDistributionPoint{}
+		if rest, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, fmt.Errorf("revocation: trailing data after distribution points")
+		}
+
+		for _, rdp := range raw {
+			uris, _, err := parseGeneralNames(rdp.DistributionPoint.FullName)
+			if err != nil {
+				return nil, err
+			}
+			_, issuers, err := parseGeneralNamesSequence(rdp.CRLIssuer.Bytes)
+			if err != nil {
+				return nil, err
+			}
+			ret = append(ret, distributionPoint{
+				URIs:       uris,
+				Reasons:    parseReasonFlags(rdp.Reasons),
+				CRLIssuers: issuers,
+			})
+		}
+	}
+	return ret, nil
+}
+
+// parseIssuingDistributionPoint will parse the Issuing Distribution Point
+// extension out of the CRL, returning nil if there is none.
+func parseIssuingDistributionPoint(
+	extensions []pkix.Extension,
+) (*issuingDistributionPoint, error) {
+	for _, ext := range extensions {
+		if !ext.Id.Equal(oidIssuingDistributionPoint) {
+			continue
+		}
+
+		raw := rawIssuingDistributionPoint{}
+		if rest, err := asn1.Unmarshal(ext.Value, &raw); err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, fmt.Errorf("revocation: trailing data after issuing distribution point")
+		}
+
+		uris, _, err := parseGeneralNames(raw.DistributionPoint.FullName)
+		if err != nil {
+			return nil, err
+		}
+		return &issuingDistributionPoint{
+			URIs:                       uris,
+			OnlyContainsUserCerts:      raw.OnlyContainsUserCerts,
+			OnlyContainsCACerts:        raw.OnlyContainsCACerts,
+			OnlySomeReasons:            parseReasonFlags(raw.OnlySomeReasons),
+			IndirectCRL:                raw.IndirectCRL,
+			OnlyContainsAttributeCerts: raw.OnlyContainsAttributeCerts,
+		}, nil
+	}
+	return nil, nil
+}
+
+// parseInteger will parse the INTEGER valued extension (such as the CRL
+// Number or Delta CRL Indicator) with the provided OID, returning nil if
+// it's not present.
+func parseInteger(extensions []pkix.Extension, id asn1.ObjectIdentifier) (*big.Int, error) {
+	for _, ext := range extensions {
+		if !ext.Id.Equal(id) {
+			continue
+		}
+		i := new(big.Int)
+		if rest, err := asn1.Unmarshal(ext.Value, &i); err != nil {
+			return nil, err
+		} else if len(rest) != 0 {
+			return nil, fmt.Errorf("revocation: trailing data after integer extension")
+		}
+		return i, nil
+	}
+	return nil, nil
+}
+
+// canonicalName will re-encode a DER encoded Name, so that two Names that
+// differ only in their choice of string types compare equal.
+func canonicalName(der []byte) (string, error) {
+	rdns := pkix.RDNSequence{}
+	if rest, err := asn1.Unmarshal(der, &rdns); err != nil {
+		return "", err
+	} else if len(rest) != 0 {
+		return "", fmt.Errorf("revocation: trailing data after name")
+	}
+	return canonicalRDNSequence(rdns)
+}
+
+// canonicalRDNSequence is canonicalName, for an already parsed Name.
+func canonicalRDNSequence(rdns pkix.RDNSequence) (string, error) {
+	der, err := asn1.Marshal(rdns)
+	if err != nil {
+		return "", err
+	}
+	return string(der), nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,299 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"pault.ag/go/piv"
+)
+
+var (
+	// id-pkix-ocsp-nocheck, from RFC 6960 Section 4.2.2.2.1. Delegated
+	// Responders that carry this extension need not be checked for
+	// revocation themselves.
+	oidOCSPNoCheck = asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5}
+)
+
+// maxOCSPResponseSize is the largest OCSP Response that will be read from
+// a Responder.
+const maxOCSPResponseSize = 1024 * 1024
+
+// OCSPChecker will query the OCSP Responders named in a Certificate's
+// Authority Information Access extension, validate the signature on the
+// response (including any Delegated Responder), and cache the result until
+// the Responder's nextUpdate.
+//
+// An OCSPChecker is safe for concurrent use.
+type OCSPChecker struct {
+	// Client is used to send requests to the OCSP Responder. If nil,
+	// http.DefaultClient will be used.
+	Client HTTPClient
+
+	// Hash used to identify the issuer in the OCSP Request. If zero,
+	// SHA-1 is used, since that's all many Responders understand.
+	Hash crypto.Hash
+
+	// Now returns the time against which responses are evaluated. If nil,
+	// time.Now is used.
+	Now func() time.Time
+
+	lock  sync.Mutex
+	cache map[[sha256.Size]byte]Response
+}
+
+// NewOCSPChecker will create a new OCSPChecker that talks to Responders
+// using the provided HTTPClient.
+func NewOCSPChecker(client HTTPClient) *OCSPChecker {
+	return &OCSPChecker{Client: client}
+}
+
+// Check will determine the revocation status of the provided Certificate,
+// which must have been issued by the provided issuer Certificate.
+//
+// Each OCSP Responder listed in the Certificate is tried in turn, and the
+// first valid response is returned. If the Certificate does not list any
+// OCSP Responder, NoResponder is returned.
+func (o *OCSPChecker) Check(cert *piv.Certificate, issuer *x509.Certificate) (*Response, error) {
+	return o.check(cert.Certificate, issuer, true)
+}
+
+// check does the heavy lifting of Check. If delegate is false, responses
+// signed by a Delegated Responder which must itself be checked for
+// revocation will be rejected, to avoid chasing Responders forever.
+func (o *OCSPChecker) check(cert, issuer *x509.Certificate, delegate bool) (*Response, error) {
+	if len(cert.OCSPServer) == 0 {
+		return nil, NoResponder
+	}
+
+	now := o.now()
+	key := o.cacheKey(cert, issuer)
+	if resp, ok := o.cached(key, now); ok {
+		return &resp, nil
+	}
+
+	var err error
+	for _, server := range cert.OCSPServer {
+		var resp *Response
+		resp, err = o.query(server, cert, issuer, now, delegate)
+		if err != nil {
+			continue
+		}
+		o.store(key, *resp)
+		return resp, nil
+	}
+	return nil, err
+}
+
+// query will send an OCSP Request for the Certificate to a single Responder,
+// and validate the response.
+func (o *OCSPChecker) query(
+	server string,
+	cert, issuer *x509.Certificate,
+	now time.Time,
+	delegate bool,
+) (*Response, error) {
+	der, err := ocsp.CreateRequest(cert, issuer, &ocsp.RequestOptions{Hash: o.Hash})
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", server, bytes.NewReader(der))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/ocsp-request")
+	req.Header.Set("Accept", "application/ocsp-response")
+
+	client := o.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+	hResp, err := client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("revocation: OCSP responder %s: %w", server, err)
+	}
+	defer hResp.Body.Close()
+
+	if hResp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("revocation: OCSP responder %s returned %s", server, hResp.Status)
+	}
+
+	body, err := ioutil.ReadAll(http.MaxBytesReader(nil, hResp.Body, maxOCSPResponseSize))
+	if err != nil {
+		return nil, err
+	}
+
+	oResp, err := ocsp.ParseResponseForCert(body, cert, issuer)
+	if err != nil {
+		return nil, fmt.Errorf("revocation: OCSP responder %s: %w", server, err)
+	}
+
+	if err := o.checkResponder(oResp, issuer, now, delegate); err != nil {
+		return nil, err
+	}
+
+	if oResp.ThisUpdate.After(now) {
+		return nil, fmt.Errorf("revocation: OCSP response is not yet valid")
+	}
+	if !oResp.NextUpdate.IsZero() && now.After(oResp.NextUpdate) {
+		return nil, fmt.Errorf("revocation: OCSP response has expired")
+	}
+
+	resp := Response{
+		ThisUpdate: oResp.ThisUpdate,
+		NextUpdate: oResp.NextUpdate,
+	}
+	switch oResp.Status {
+	case ocsp.Good:
+		resp.Status = Good
+	case ocsp.Revoked:
+		resp.Status = Revoked
+		resp.RevokedAt = oResp.RevokedAt
+		resp.Reason = Reason(oResp.RevocationReason)
+	default:
+		resp.Status = Unknown
+	}
+	return &resp, nil
+}
+
+// checkResponder will ensure the OCSP Response was signed by someone allowed
+// to speak for the issuer. The signatures themselves have already been
+// checked by the ocsp package; this checks that a Delegated Responder was
+// actually delegated to (RFC 6960, Section 4.2.2.2), and that it has not
+// itself been revoked.
+func (o *OCSPChecker) checkResponder(
+	resp *ocsp.Response,
+	issuer *x509.Certificate,
+	now time.Time,
+	delegate bool,
+) error {
+	responder := resp.Certificate
+	if responder == nil || bytes.Equal(responder.Raw, issuer.Raw) {
+		// Signed directly by the issuer.
+		return nil
+	}
+
+	ocspSigning := false
+	for _, usage := range responder.ExtKeyUsage {
+		if usage == x509.ExtKeyUsageOCSPSigning {
+			ocspSigning = true
+			break
+		}
+	}
+	if !ocspSigning {
+		return fmt.Errorf("revocation: OCSP responder certificate lacks the OCSPSigning extended key usage")
+	}
+
+	if now.Before(responder.NotBefore) || now.After(responder.NotAfter) {
+		return fmt.Errorf("revocation: OCSP responder certificate is not valid at this time")
+	}
+
+	for _, ext := range responder.Extensions {
+		if ext.Id.Equal(oidOCSPNoCheck) {
+			return nil
+		}
+	}
+
+	// Without id-pkix-ocsp-nocheck, the Delegated Responder has to be
+	// checked like any other Certificate.
+	if !delegate {
+		return fmt.Errorf("revocation: OCSP responder certificate requires a revocation check")
+	}
+	status, err := o.check(responder, issuer, false)
+	if err != nil {
+		return fmt.Errorf("revocation: checking OCSP responder certificate: %w", err)
+	}
+	if status.Status != Good {
+		return fmt.Errorf("revocation: OCSP responder certificate is %s", status.Status)
+	}
+	return nil
+}
+
+// now returns the current time, as understood by the OCSPChecker.
+func (o *OCSPChecker) now() time.Time {
+	if o.Now != nil {
+		return o.Now()
+	}
+	return time.Now()
+}
+
+// cacheKey identifies the Certificate by its issuer's public key and its
+// serial number, the same way the OCSP CertID does.
+func (o *OCSPChecker) cacheKey(cert, issuer *x509.Certificate) [sha256.Size]byte {
+	serial := cert.SerialNumber
+	if serial == nil {
+		serial = new(big.Int)
+	}
+	h := sha256.New()
+	h.Write(issuer.RawSubjectPublicKeyInfo)
+	h.Write(serial.Bytes())
+	var key [sha256.Size]byte
+	copy(key[:], h.Sum(nil))
+	return key
+}
+
+// cached returns a previous response for the Certificate, if there is one
+// and the Responder has not yet published an update.
+func (o *OCSPChecker) cached(key [sha256.Size]byte, now time.Time) (Response, bool) {
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	resp, ok := o.cache[key]
+	if !ok {
+		return Response{}, false
+	}
+	if !now.Before(resp.NextUpdate) {
+		delete(o.cache, key)
+		return Response{}, false
+	}
+	return resp, true
+}
+
+// store will cache the response until its nextUpdate. Responses without a
+// nextUpdate are always fetched fresh.
+func (o *OCSPChecker) store(key [sha256.Size]byte, resp Response) {
+	if resp.NextUpdate.IsZero() {
+		return
+	}
+
+	o.lock.Lock()
+	defer o.lock.Unlock()
+
+	if o.cache == nil {
+		o.cache = map[[sha256.Size]byte]Response{}
+	}
+	o.cache[key] = resp
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,297 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation_test
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/revocation"
+)
+
+// issue will create a Certificate for a new P-256 key, signed by the parent,
+// or self signed if the parent is nil.
+func issue(t *testing.T, template *x509.Certificate, parent *x509.Certificate, parentKey crypto.Signer) (*x509.Certificate, crypto.Signer) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if template.NotBefore.IsZero() {
+		template.NotBefore = time.Now().Add(-time.Hour)
+		template.NotAfter = time.Now().Add(time.Hour)
+	}
+	if parent == nil {
+		parent, parentKey = template, key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, parent, key.Public(), parentKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, key
+}
+
+// responder is an OCSP Responder running on an httptest.Server, answering
+// with the status set for each serial number.
+type responder struct {
+	*httptest.Server
+
+	issuer    *x509.Certificate
+	issuerKey crypto.Signer
+
+	// Certificate and key signing the responses. If nil, the issuer signs
+	// them directly.
+	cert *x509.Certificate
+	key  crypto.Signer
+
+	status map[int64]int
+}
+
+func newResponder(t *testing.T) *responder {
+	t.Helper()
+	r := &responder{status: map[int64]int{}}
+	r.issuer, r.issuerKey = issue(t, &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil, nil)
+	r.Server = httptest.NewServer(r)
+	t.Cleanup(r.Close)
+	return r
+}
+
+// ServeHTTP answers a single OCSP Request.
+func (r *responder) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	oReq, err := ocsp.ParseRequest(body)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	template := ocsp.Response{
+		SerialNumber: oReq.SerialNumber,
+		Status:       ocsp.Unknown,
+		ThisUpdate:   time.Now().Add(-time.Minute),
+		NextUpdate:   time.Now().Add(time.Hour),
+	}
+	if status, ok := r.status[oReq.SerialNumber.Int64()]; ok {
+		template.Status = status
+	}
+	if template.Status == ocsp.Revoked {
+		template.RevokedAt = time.Now().Add(-time.Minute).UTC().Truncate(time.Second)
+		template.RevocationReason = ocsp.KeyCompromise
+	}
+
+	// The status of the Delegated Responder itself comes from the issuer.
+	signer, key := r.issuer, r.issuerKey
+	if r.cert != nil && r.cert.SerialNumber.Cmp(oReq.SerialNumber) != 0 {
+		signer, key = r.cert, r.key
+		template.Certificate = r.cert
+	}
+	der, err := ocsp.CreateResponse(r.issuer, signer, template, key)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/ocsp-response")
+	w.Write(der)
+}
+
+// leaf will issue an end entity Certificate pointing at the Responder.
+func (r *responder) leaf(t *testing.T, serial int64, status int) *piv.Certificate {
+	t.Helper()
+	r.status[serial] = status
+	cert, _ := issue(t, &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Test Cardholder"},
+		OCSPServer:   []string{r.URL},
+	}, r.issuer, r.issuerKey)
+	pivCert, err := piv.NewCertificate(cert)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pivCert
+}
+
+// delegate will have the Responder sign with a Delegated Responder
+// Certificate issued by the CA.
+func (r *responder) delegate(t *testing.T, serial int64, noCheck bool, usage []x509.ExtKeyUsage) {
+	t.Helper()
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: "Test OCSP Responder"},
+		ExtKeyUsage:  usage,
+		OCSPServer:   []string{r.URL},
+	}
+	if noCheck {
+		template.ExtraExtensions = []pkix.Extension{{
+			Id:    asn1.ObjectIdentifier{1, 3, 6, 1, 5, 5, 7, 48, 1, 5},
+			Value: []byte{0x05, 0x00},
+		}}
+	}
+	r.cert, r.key = issue(t, template, r.issuer, r.issuerKey)
+}
+
+func TestOCSPStatus(t *testing.T) {
+	r := newResponder(t)
+
+	for _, test := range []struct {
+		name   string
+		serial int64
+		status int
+		want   revocation.Status
+	}{
+		{"good", 100, ocsp.Good, revocation.Good},
+		{"revoked", 101, ocsp.Revoked, revocation.Revoked},
+		{"unknown", 102, ocsp.Unknown, revocation.Unknown},
+	} {
+		t.Run(test.name, func(t *testing.T) {
+			checker := revocation.NewOCSPChecker(r.Client())
+			resp, err := checker.Check(r.leaf(t, test.serial, test.status), r.issuer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if resp.Status != test.want {
+				t.Fatalf("got status %s, want %s", resp.Status, test.want)
+			}
+			if test.want == revocation.Revoked && resp.Reason != revocation.KeyCompromise {
+				t.Fatalf("got reason %d, want KeyCompromise", resp.Reason)
+			}
+		})
+	}
+}
+
+func TestOCSPDelegatedResponder(t *testing.T) {
+	usage := []x509.ExtKeyUsage{x509.ExtKeyUsageOCSPSigning}
+
+	t.Run("nocheck", func(t *testing.T) {
+		r := newResponder(t)
+		r.delegate(t, 200, true, usage)
+		resp, err := revocation.NewOCSPChecker(r.Client()).Check(r.leaf(t, 201, ocsp.Good), r.issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != revocation.Good {
+			t.Fatalf("got status %s, want good", resp.Status)
+		}
+	})
+
+	t.Run("checked", func(t *testing.T) {
+		// Without id-pkix-ocsp-nocheck, the Delegated Responder is itself
+		// checked, and found to be good.
+		r := newResponder(t)
+		r.delegate(t, 300, false, usage)
+		r.status[300] = ocsp.Good
+		resp, err := revocation.NewOCSPChecker(r.Client()).Check(r.leaf(t, 301, ocsp.Revoked), r.issuer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if resp.Status != revocation.Revoked {
+			t.Fatalf("got status %s, want revoked", resp.Status)
+		}
+	})
+
+	t.Run("revoked", func(t *testing.T) {
+		r := newResponder(t)
+		r.delegate(t, 400, false, usage)
+		r.status[400] = ocsp.Revoked
+		if _, err := revocation.NewOCSPChecker(r.Client()).Check(r.leaf(t, 401, ocsp.Good), r.issuer); err == nil {
+			t.Fatal("response from a revoked Delegated Responder was accepted")
+		}
+	})
+
+	t.Run("not delegated", func(t *testing.T) {
+		r := newResponder(t)
+		r.delegate(t, 500, true, []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth})
+		if _, err := revocation.NewOCSPChecker(r.Client()).Check(r.leaf(t, 501, ocsp.Good), r.issuer); err == nil {
+			t.Fatal("response from a responder without OCSPSigning was accepted")
+		}
+	})
+}
+
+func TestOCSPErrors(t *testing.T) {
+	r := newResponder(t)
+	cert := r.leaf(t, 600, ocsp.Good)
+
+	t.Run("network", func(t *testing.T) {
+		r := newResponder(t)
+		cert := r.leaf(t, 601, ocsp.Good)
+		client := r.Client()
+		r.Close()
+
+		_, err := revocation.NewOCSPChecker(client).Check(cert, r.issuer)
+		var urlErr *url.Error
+		if !errors.As(err, &urlErr) {
+			t.Fatalf("got %v, want a *url.Error", err)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		garbage := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+			w.Write([]byte{0x30, 0x03, 0x0A, 0x01, 0x00})
+		}))
+		defer garbage.Close()
+		cert.OCSPServer = []string{garbage.URL}
+
+		_, err := revocation.NewOCSPChecker(garbage.Client()).Check(cert, r.issuer)
+		var parseErr ocsp.ParseError
+		if !errors.As(err, &parseErr) {
+			t.Fatalf("got %v, want an ocsp.ParseError", err)
+		}
+	})
+
+	t.Run("no responder", func(t *testing.T) {
+		cert := r.leaf(t, 602, ocsp.Good)
+		cert.OCSPServer = nil
+		if _, err := revocation.NewOCSPChecker(r.Client()).Check(cert, r.issuer); err != revocation.NoResponder {
+			t.Fatalf("got %v, want NoResponder", err)
+		}
+	})
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,113 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package revocation checks the revocation status of PIV Certificates, either
+// by asking the OCSP Responder named in the Certificate's Authority
+// Information Access extension, or by fetching the CRLs named in the
+// Certificate's CRL Distribution Points extension.
+package revocation
+
+import (
+	"errors"
+	"net/http"
+	"time"
+)
+
+var (
+	// NoResponder is returned when the Certificate does not name any
+	// source of revocation information.
+	NoResponder = errors.New("revocation: certificate has no revocation information")
+
+	// NotFound is returned by a CRLStore that has no CRL for a URL.
+	NotFound = errors.New("revocation: not found")
+)
+
+// HTTPClient is the interface used to fetch revocation information over
+// HTTP. The standard *http.Client implements this interface, but any
+// stand-in (such as one talking to an httptest.Server) may be provided.
+type HTTPClient interface {
+	Do(*http.Request) (*http.Response, error)
+}
+
+// Status is the revocation state of a Certificate.
+type Status int
+
+const (
+	// Good means the issuer has asserted the Certificate is not revoked.
+	Good Status = iota
+
+	// Revoked means the Certificate has been revoked, and must not be
+	// trusted.
+	Revoked
+
+	// Unknown means the issuer does not know about the Certificate.
+	Unknown
+)
+
+// String returns a human readable name of the Status.
+func (s Status) String() string {
+	switch s {
+	case Good:
+		return "good"
+	case Revoked:
+		return "revoked"
+	case Unknown:
+		return "unknown"
+	default:
+		return "invalid"
+	}
+}
+
+// Reason is the CRLReason (RFC 5280, Section 5.3.1) given when a Certificate
+// has been revoked.
+type Reason int
+
+const (
+	Unspecified          Reason = 0
+	KeyCompromise        Reason = 1
+	CACompromise         Reason = 2
+	AffiliationChanged   Reason = 3
+	Superseded           Reason = 4
+	CessationOfOperation Reason = 5
+	CertificateHold      Reason = 6
+	RemoveFromCRL        Reason = 8
+	PrivilegeWithdrawn   Reason = 9
+	AACompromise         Reason = 10
+)
+
+// Response is the revocation status of a single Certificate, along with the
+// window during which this information is considered current.
+type Response struct {
+	Status Status
+
+	// When Status is Revoked, the time the Certificate was revoked, and
+	// the reason given by the issuer.
+	RevokedAt time.Time
+	Reason    Reason
+
+	// ThisUpdate is the time at which this status was known to be correct,
+	// and NextUpdate is the time at which newer information will be
+	// available. NextUpdate is the zero time if the issuer did not
+	// provide one.
+	ThisUpdate time.Time
+	NextUpdate time.Time
+}
+
+// vim: foldmethod=marker
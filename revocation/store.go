@@ -0,0 +1,100 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// CRLStore holds fetched CRLs between runs, so that large CRLs need not be
+// downloaded again until they're due to be replaced.
+type CRLStore interface {
+	// Get returns the DER encoded CRL most recently fetched from the URL,
+	// along with the time it was fetched. If there is no stored CRL for
+	// that URL, NotFound is returned.
+	Get(url string) ([]byte, time.Time, error)
+
+	// Put stores the DER encoded CRL fetched from the URL at the provided
+	// time.
+	Put(url string, crl []byte, fetched time.Time) error
+}
+
+// DirStore is a CRLStore that keeps each CRL as a file in the named
+// directory. The file is named after the SHA-256 hash of the URL, and the
+// time it was fetched is kept as the file's modification time.
+type DirStore string
+
+// path returns the file the CRL for the URL is stored in.
+func (d DirStore) path(url string) string {
+	hash := sha256.Sum256([]byte(url))
+	return filepath.Join(string(d), hex.EncodeToString(hash[:])+".crl")
+}
+
+// Get implements the CRLStore interface.
+func (d DirStore) Get(url string) ([]byte, time.Time, error) {
+	path := d.path(url)
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return nil, time.Time{}, NotFound
+	} else if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	crl, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+	return crl, info.ModTime(), nil
+}
+
+// Put implements the CRLStore interface. The CRL is written to a temporary
+// file and renamed into place, so that concurrent readers never see a
+// partial CRL.
+func (d DirStore) Put(url string, crl []byte, fetched time.Time) error {
+	if err := os.MkdirAll(string(d), 0755); err != nil {
+		return err
+	}
+
+	fd, err := ioutil.TempFile(string(d), ".crl-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(fd.Name())
+
+	if _, err := fd.Write(crl); err != nil {
+		fd.Close()
+		return err
+	}
+	if err := fd.Close(); err != nil {
+		return err
+	}
+	if err := os.Chtimes(fd.Name(), fetched, fetched); err != nil {
+		return err
+	}
+	return os.Rename(fd.Name(), d.path(url))
+}
+
+// vim: foldmethod=marker
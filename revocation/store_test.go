@@ -0,0 +1,120 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package revocation_test
+
+import (
+	"bytes"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv/revocation"
+)
+
+func TestDirStore(t *testing.T) {
+	store := revocation.DirStore(filepath.Join(t.TempDir(), "crls"))
+
+	if _, _, err := store.Get("http://example.com/ca.crl"); err != revocation.NotFound {
+		t.Fatalf("got %v, want NotFound", err)
+	}
+
+	fetched := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := store.Put("http://example.com/ca.crl", []byte("first"), fetched); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("http://example.com/other.crl", []byte("other"), fetched); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Put("http://example.com/ca.crl", []byte("second"), fetched); err != nil {
+		t.Fatal(err)
+	}
+
+	crl, when, err := store.Get("http://example.com/ca.crl")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(crl, []byte("second")) {
+		t.Fatalf("got %q, want the most recent CRL", crl)
+	}
+	if !when.Equal(fetched) {
+		t.Fatalf("got fetch time %s, want %s", when, fetched)
+	}
+
+	// Only the two CRLs are left behind, without any temporary files.
+	files, err := ioutil.ReadDir(string(store))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 2 {
+		t.Fatalf("got %d files in the store, want 2", len(files))
+	}
+}
+
+func TestCRLStoreFreshness(t *testing.T) {
+	s := newCRLServer(t)
+	ca, caKey := newCA(t, "Test CA")
+	uri := s.publish("/ca.crl", signCRL(t, &x509.RevocationList{
+		RevokedCertificates: []pkix.RevokedCertificate{
+			revoked(t, 101, revocation.KeyCompromise),
+		},
+	}, ca, caKey))
+	cert := leafWith(t, 101, ca, caKey, extension(t, oidCRLDistributionPoints, false, []distributionPoint{{
+		DistributionPoint: uriName(uri),
+	}}))
+	store := revocation.DirStore(t.TempDir())
+
+	check := func(checker *revocation.CRLChecker, fetches int) {
+		t.Helper()
+		resp := checkCRL(t, checker, cert, ca)
+		if resp.Status != revocation.Revoked {
+			t.Fatalf("got status %s, want revoked", resp.Status)
+		}
+		if n := s.count("/ca.crl"); n != fetches {
+			t.Fatalf("CRL was fetched %d times, want %d", n, fetches)
+		}
+	}
+
+	check(revocation.NewCRLChecker(s.Client(), store), 1)
+	if _, _, err := store.Get(uri); err != nil {
+		t.Fatalf("CRL was not stored: %v", err)
+	}
+
+	// A new CRLChecker finds the CRL in the Store.
+	check(revocation.NewCRLChecker(s.Client(), store), 1)
+
+	// Once MaxAge has passed, the stored CRL is replaced, even though its
+	// nextUpdate hasn't.
+	later := revocation.NewCRLChecker(s.Client(), store)
+	later.MaxAge = 10 * time.Minute
+	later.Now = func() time.Time { return time.Now().Add(30 * time.Minute) }
+	check(later, 2)
+	check(later, 2)
+	if _, when, err := store.Get(uri); err != nil {
+		t.Fatal(err)
+	} else if when.Before(time.Now().Add(20 * time.Minute)) {
+		t.Fatalf("stored fetch time %s was not updated", when)
+	}
+}
+
+// vim: foldmethod=marker
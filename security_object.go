@@ -0,0 +1,313 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"crypto"
+	"crypto/subtle"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"sort"
+
+	"pault.ag/go/piv/internal/cms"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	oidLDSSecurityObject = asn1.ObjectIdentifier{2, 23, 136, 1, 1, 1}
+)
+
+// ContainerID is the 2 byte identifier given to each PIV data object in
+// SP 800-73-4, which is used by the Security Object to refer to the data
+// objects it covers.
+type ContainerID uint16
+
+const (
+	ContainerCCC                           ContainerID = 0xDB00
+	ContainerCHUID                         ContainerID = 0x3000
+	ContainerAuthenticationCertificate     ContainerID = 0x0101
+	ContainerFingerprints                  ContainerID = 0x6010
+	ContainerSecurityObject                ContainerID = 0x9000
+	ContainerFacial                        ContainerID = 0x6030
+	ContainerPrintedInformation            ContainerID = 0x3001
+	ContainerDigitalSignatureCertificate   ContainerID = 0x0100
+	ContainerKeyManagementCertificate      ContainerID = 0x0102
+	ContainerCardAuthenticationCertificate ContainerID = 0x0500
+	ContainerDiscoveryObject               ContainerID = 0x6050
+	ContainerKeyHistory                    ContainerID = 0x6060
+	ContainerIris                          ContainerID = 0x1015
+)
+
+// String returns the ContainerID as 4 hex digits.
+func (c ContainerID) String() string {
+	return fmt.Sprintf("%04X", uint16(c))
+}
+
+// ContainerReader is anything that can read the raw PIV data objects off a
+// card by ContainerID, such as each of the Token backends. This returns the
+// data object as read from the card, with or without the outer container TLV
+// wrapper.
+type ContainerReader interface {
+	Container(ContainerID) ([]byte, error)
+}
+
+// SecurityObject is the PIV Security Object, which is a mapping of ICAO LDS
+// data group numbers to PIV data objects, along with a CMS signed LDS
+// Security Object containing a hash of each of those data objects.
+//
+// Once the signature has been checked by Verify, and the data objects have
+// been checked by CheckContainers, the data objects (such as the facial
+// image and fingerprints) may be trusted to be what the issuer put on the
+// card.
+type SecurityObject struct {
+	// Entire Security Object, as read from the card, without the outer
+	// container TLV wrapper.
+	Raw []byte
+
+	// Mapping of data group numbers to the PIV data object they refer to.
+	Mapping map[int]ContainerID
+
+	// CMS SignedData over the LDS Security Object, signed by the card
+	// issuer.
+	LDSSecurityObject []byte
+
+	// Hash algorithm used to compute the DataGroupHashes.
+	HashAlgorithm crypto.Hash
+
+	// Hash of each data group, by data group number, as given in the LDS
+	// Security Object. These have not been verified until Verify is called.
+	DataGroupHashes map[int][]byte
+}
+
+// Tags of the data elements in the Security Object, as defined in
+// SP 800-73-4.
+const (
+	securityObjectTagMapping            tlv.Tag = 0xBA
+	securityObjectTagLDSSecurityObject  tlv.Tag = 0xBB
+	securityObjectTagErrorDetectionCode tlv.Tag = 0xFE
+)
+
+// ldsSecurityObject is the ICAO 9303 LDSSecurityObject.
+type ldsSecurityObject struct {
+	Version             int
+	HashAlgorithm       asn1.RawValue
+	DataGroupHashValues []dataGroupHash
+	LDSVersionInfo      asn1.RawValue `asn1:"optional"`
+}
+
+type dataGroupHash struct {
+	DataGroupNumber    int
+	DataGroupHashValue []byte
+}
+
+type algorithmIdentifier struct {
+	Algorithm  asn1.ObjectIdentifier
+	Parameters asn1.RawValue `asn1:"optional"`
+}
+
+// ParseSecurityObject will parse the Security Object, as read from the
+// card. This will not check the signature, which must be done by calling
+// Verify.
+func ParseSecurityObject(data []byte) (*SecurityObject, error) {
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return nil, err
+	}
+
+	elements, err := tlv.DecodeMode(data, tlv.Lenient)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := SecurityObject{Raw: data, Mapping: map[int]ContainerID{}}
+	var seenMapping, seenLDS bool
+
+	for i, el := range elements {
+		switch el.Tag {
+		case securityObjectTagMapping:
+			if len(el.Value)%3 != 0 {
+				return nil, fmt.Errorf("piv: Security Object mapping isn't a list of 3 byte entries")
+			}
+			for j := 0; j < len(el.Value); j += 3 {
+				dg := int(el.Value[j])
+				if _, ok := ret.Mapping[dg]; ok {
+					return nil, fmt.Errorf("piv: Security Object maps data group %d twice", dg)
+				}
+				ret.Mapping[dg] = ContainerID(binary.BigEndian.Uint16(el.Value[j+1 : j+3]))
+			}
+			seenMapping = true
+		case securityObjectTagLDSSecurityObject:
+			ret.LDSSecurityObject = el.Value
+			seenLDS = true
+		case securityObjectTagErrorDetectionCode:
+			if i != len(elements)-1 {
+				return nil, fmt.Errorf("piv: Security Object has trailing data after the error detection code")
+			}
+		default:
+			return nil, fmt.Errorf("piv: Security Object has unknown tag 0x%s", el.Tag)
+		}
+	}
+
+	switch {
+	case !seenMapping:
+		return nil, fmt.Errorf("piv: Security Object is missing the data group mapping")
+	case !seenLDS:
+		return nil, fmt.Errorf("piv: Security Object is missing the LDS Security Object")
+	}
+
+	sd, err := cms.Parse(ret.LDSSecurityObject)
+	if err != nil {
+		return nil, err
+	}
+	if !sd.ContentType.Equal(oidLDSSecurityObject) {
+		return nil, fmt.Errorf("piv: Security Object signature isn't over an LDS Security Object")
+	}
+	if sd.Content == nil {
+		return nil, fmt.Errorf("piv: Security Object has a detached LDS Security Object")
+	}
+
+	lds := ldsSecurityObject{}
+	if rest, err := asn1.Unmarshal(sd.Content, &lds); err != nil {
+		return nil, err
+	} else if len(rest) != 0 {
+		return nil, fmt.Errorf("piv: LDS Security Object has trailing data")
+	}
+
+	algorithm := algorithmIdentifier{}
+	if _, err := asn1.Unmarshal(lds.HashAlgorithm.FullBytes, &algorithm); err != nil {
+		return nil, err
+	}
+	ret.HashAlgorithm, err = cms.DigestAlgorithm(algorithm.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	ret.DataGroupHashes = map[int][]byte{}
+	for _, el := range lds.DataGroupHashValues {
+		if _, ok := ret.DataGroupHashes[el.DataGroupNumber]; ok {
+			return nil, fmt.Errorf("piv: LDS Security Object hashes data group %d twice", el.DataGroupNumber)
+		}
+		if len(el.DataGroupHashValue) != ret.HashAlgorithm.Size() {
+			return nil, fmt.Errorf("piv: LDS Security Object hash of data group %d is the wrong size", el.DataGroupNumber)
+		}
+		ret.DataGroupHashes[el.DataGroupNumber] = el.DataGroupHashValue
+	}
+
+	for dg := range ret.Mapping {
+		if _, ok := ret.DataGroupHashes[dg]; !ok {
+			return nil, fmt.Errorf("piv: LDS Security Object has no hash for data group %d", dg)
+		}
+	}
+
+	return &ret, nil
+}
+
+// Verify will check the signature over the LDS Security Object, and ensure
+// the signer is a PIV content signer, returning the signer's Certificate.
+// This does not check any of the data objects, which must be done by
+// calling CheckContainers.
+func (s SecurityObject) Verify(opts VerifyOptions) (*Certificate, error) {
+	sd, err := cms.Parse(s.LDSSecurityObject)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := sd.Verify(nil, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return VerifyContentSigner(cert, opts)
+}
+
+// ContainerIDs returns the ContainerID of every data object covered by the
+// Security Object, in data group order.
+func (s SecurityObject) ContainerIDs() []ContainerID {
+	dgs := []int{}
+	for dg := range s.Mapping {
+		dgs = append(dgs, dg)
+	}
+	sort.Ints(dgs)
+
+	ret := []ContainerID{}
+	for _, dg := range dgs {
+		ret = append(ret, s.Mapping[dg])
+	}
+	return ret
+}
+
+// CheckContainer will ensure the given data object, as read from the card,
+// matches the hash in the LDS Security Object. The hash is computed over the
+// contents of the data object, without the outer container TLV wrapper.
+//
+// This does not check the signature over the LDS Security Object, so unless
+// Verify has also been called, this says nothing about the data object being
+// genuine.
+func (s SecurityObject) CheckContainer(id ContainerID, data []byte) error {
+	dg := -1
+	for number, container := range s.Mapping {
+		if container == id {
+			dg = number
+			break
+		}
+	}
+	if dg < 0 {
+		return fmt.Errorf("piv: data object %s isn't covered by the Security Object", id)
+	}
+
+	data, err := unwrapContainer(data)
+	if err != nil {
+		return err
+	}
+
+	if !s.HashAlgorithm.Available() {
+		return fmt.Errorf("piv: Security Object hash algorithm %s isn't available", s.HashAlgorithm)
+	}
+	h := s.HashAlgorithm.New()
+	h.Write(data)
+
+	if subtle.ConstantTimeCompare(h.Sum(nil), s.DataGroupHashes[dg]) != 1 {
+		return fmt.Errorf("piv: data object %s doesn't match the Security Object", id)
+	}
+	return nil
+}
+
+// CheckContainers will read every data object covered by the Security
+// Object from the ContainerReader, and ensure each matches the hash in the
+// LDS Security Object.
+//
+// As with CheckContainer, this does not check the signature, so Verify must
+// also be called before the data objects may be trusted.
+func (s SecurityObject) CheckContainers(reader ContainerReader) error {
+	for _, id := range s.ContainerIDs() {
+		data, err := reader.Container(id)
+		if err != nil {
+			return err
+		}
+		if err := s.CheckContainer(id, data); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,92 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+// container reads the raw data object off the software Token.
+func container(t *testing.T, token *softtoken.Token, id piv.ContainerID) []byte {
+	t.Helper()
+	data, err := token.Container(id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestSecurityObject(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{})
+	security, err := token.SecurityObject()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := []piv.ContainerID{piv.ContainerCHUID, piv.ContainerFacial, piv.ContainerPrintedInformation}
+	got := security.ContainerIDs()
+	if len(got) != len(want) {
+		t.Fatalf("got containers %v, want %v", got, want)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Fatalf("got containers %v, want %v", got, want)
+		}
+	}
+
+	if _, err := security.Verify(piv.VerifyOptions{Roots: token.CA().Pool()}); err != nil {
+		t.Fatal(err)
+	}
+	other := newSoftToken(t, softtoken.Config{})
+	if _, err := security.Verify(piv.VerifyOptions{Roots: other.CA().Pool()}); err == nil {
+		t.Fatal("Security Object verified against the wrong CA")
+	}
+
+	// The Printed Information may only be read once the PIN is verified.
+	if err := security.CheckContainers(token); !errors.Is(err, piv.ErrAuthRequired) {
+		t.Fatalf("got %v, want piv.ErrAuthRequired", err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	if err := security.CheckContainers(token); err != nil {
+		t.Fatal(err)
+	}
+
+	chuid := container(t, token, piv.ContainerCHUID)
+	tampered := append([]byte{}, chuid...)
+	tampered[len(tampered)-3] ^= 0xFF
+	if err := security.CheckContainer(piv.ContainerCHUID, tampered); err == nil {
+		t.Fatal("tampered CHUID matched the Security Object")
+	}
+	if err := security.CheckContainer(piv.ContainerCCC, container(t, token, piv.ContainerCCC)); err == nil {
+		t.Fatal("CCC matched, but isn't covered by the Security Object")
+	}
+	if err := security.CheckContainer(piv.ContainerCHUID, container(t, other, piv.ContainerCHUID)); err == nil {
+		t.Fatal("CHUID from another card matched the Security Object")
+	}
+}
+
+// vim: foldmethod=marker
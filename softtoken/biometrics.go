@@ -0,0 +1,171 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"bytes"
+	"crypto"
+	"encoding/asn1"
+	"encoding/binary"
+	"fmt"
+	"image"
+	"image/color"
+	"image/jpeg"
+	"time"
+
+	"pault.ag/go/cbeff"
+	"pault.ag/go/piv/internal/cms"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	oidPIVBiometricObject = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 2}
+)
+
+// Encode a time.Time as a CBEFF Time.
+func cbeffTime(when time.Time) cbeff.Time {
+	when = when.UTC()
+	return cbeff.Time{
+		uint8(when.Year() / 100), uint8(when.Year() % 100),
+		uint8(when.Month()), uint8(when.Day()),
+		uint8(when.Hour()), uint8(when.Minute()), uint8(when.Second()),
+		'Z',
+	}
+}
+
+// Create a small, flat grey JPEG to stand in for the cardholder's photo.
+func syntheticPhoto(width, height int) ([]byte, error) {
+	img := image.NewGray(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.SetGray(x, y, color.Gray{Y: 0x80})
+		}
+	}
+
+	buf := bytes.Buffer{}
+	if err := jpeg.Encode(&buf, img, nil); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// Create a CBEFF containing a single INCITS 385 facial record, with a
+// synthetic photo, signed by the content signer.
+func syntheticFacial(config Config, signer slot) ([]byte, error) {
+	var width, height uint16 = 48, 64
+
+	photo, err := syntheticPhoto(int(width), int(height))
+	if err != nil {
+		return nil, err
+	}
+
+	facialInformation := cbeff.FacialInformation{}
+	imageInformation := cbeff.ImageInformation{
+		Type:       0x01, // Full Frontal
+		DataType:   0x00, // JPEG
+		Width:      width,
+		Height:     height,
+		ColorSpace: 0x01, // 24 bit RGB
+		SourceType: 0x02, // Digital still
+	}
+	facialInformation.Length = uint32(
+		binary.Size(facialInformation) + binary.Size(imageInformation) + len(photo),
+	)
+
+	facialHeader := cbeff.FacialHeader{
+		FormatID:    [4]byte{'F', 'A', 'C', 0x00},
+		VersionID:   [4]byte{'0', '1', '0', 0x00},
+		NumberFaces: 1,
+	}
+	facialHeader.RecordLength = uint32(binary.Size(facialHeader)) + facialInformation.Length
+
+	record := bytes.Buffer{}
+	for _, el := range []interface{}{
+		facialHeader, facialInformation, imageInformation, photo,
+	} {
+		if err := binary.Write(&record, binary.BigEndian, el); err != nil {
+			return nil, err
+		}
+	}
+
+	header := cbeff.Header{
+		PatronHeaderVersion:   0x03,
+		BDBLength:             uint32(record.Len()),
+		BDBFormatOwner:        0x001B, // INCITS Technical Committee M1
+		BDBFormatType:         0x0501, // INCITS 385 Face
+		BiometricCreationDate: cbeffTime(config.NotBefore),
+		ValidityNotBefore:     cbeffTime(config.NotBefore),
+		ValidityNotAfter:      cbeffTime(config.NotAfter),
+		BiometricType:         cbeff.BiometricTypeFacial,
+		BiometricDataType:     0x20, // Raw
+		BiometricDataQuality:  0xFE, // Not supported
+	}
+	copy(header.Creator[:], "pault.ag softtoken")
+	copy(header.FASC[:], config.FASCN)
+
+	return signBiometric(header, record.Bytes(), signer)
+}
+
+// Assemble a CBEFF from the header and biometric data block, with a
+// signature block over both, as defined in SP 800-76-2.
+func signBiometric(header cbeff.Header, record []byte, signer slot) ([]byte, error) {
+	encode := func(header cbeff.Header) ([]byte, error) {
+		ret := bytes.Buffer{}
+		if err := binary.Write(&ret, binary.BigEndian, header); err != nil {
+			return nil, err
+		}
+		ret.Write(record)
+		return ret.Bytes(), nil
+	}
+
+	// The signature covers the SBLength, so this has to be known before
+	// signing. RSA signatures don't change size, so sign once to find out
+	// how big the signature block is, and again with the right length.
+	header.SBHSecurityOptions = 0x0D
+	var signature []byte
+	for i := 0; i < 2; i++ {
+		content, err := encode(header)
+		if err != nil {
+			return nil, err
+		}
+		signature, err = cms.Sign(
+			oidPIVBiometricObject, content, true,
+			signer.certificate, signer.key, crypto.SHA256,
+		)
+		if err != nil {
+			return nil, err
+		}
+		if int(header.SBLength) == len(signature) {
+			return append(content, signature...), nil
+		}
+		header.SBLength = uint16(len(signature))
+	}
+	return nil, fmt.Errorf("piv: softtoken: biometric signature length isn't stable")
+}
+
+// Wrap a raw CBEFF in the PIV biometric container TLVs, as it would be
+// returned by a GET DATA on a card.
+func wrapBiometric(data []byte) []byte {
+	body := append(tlv.Encode(0xBC, data), tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, body)
+}
+
+// vim: foldmethod=marker
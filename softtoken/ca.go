@@ -0,0 +1,146 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"time"
+)
+
+// CAConfig defines the synthetic Certificate Authority that will issue the
+// certificates held by a software Token.
+type CAConfig struct {
+	// Subject of the CA Certificate. If this is empty, a generic "Synthetic
+	// PIV CA" name will be used.
+	Subject pkix.Name
+
+	// Size of the RSA key to generate for the CA. If this is zero, a 2048
+	// bit key will be created.
+	Bits int
+
+	// Validity period of the CA Certificate. If these are zero, the CA
+	// will be valid from an hour ago until ten years from now.
+	NotBefore time.Time
+	NotAfter  time.Time
+}
+
+// CA is a synthetic Certificate Authority, used to issue the certificates
+// held on a software Token. The same CA may be used to issue any number
+// of Tokens, which is helpful when testing code that has to deal with more
+// than one card.
+type CA struct {
+	// Self-signed CA Certificate. This ought to be used as the trust anchor
+	// when validating certificates issued to a software Token.
+	Certificate *x509.Certificate
+
+	// Private key backing the CA Certificate.
+	PrivateKey crypto.Signer
+}
+
+// NewCA will generate a new self-signed synthetic Certificate Authority.
+func NewCA(config CAConfig) (*CA, error) {
+	if config.Bits == 0 {
+		config.Bits = 2048
+	}
+	if len(config.Subject.CommonName) == 0 {
+		config.Subject = pkix.Name{
+			Country:      []string{"US"},
+			Organization: []string{"pault.ag"},
+			CommonName:   "Synthetic PIV CA",
+		}
+	}
+	config.NotBefore, config.NotAfter = validity(config.NotBefore, config.NotAfter, 10)
+
+	key, err := rsa.GenerateKey(rand.Reader, config.Bits)
+	if err != nil {
+		return nil, err
+	}
+
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	template := x509.Certificate{
+		SerialNumber:          serial,
+		Subject:               config.Subject,
+		NotBefore:             config.NotBefore,
+		NotAfter:              config.NotAfter,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, &template, &template, key.Public(), key)
+	if err != nil {
+		return nil, err
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+
+	return &CA{Certificate: cert, PrivateKey: key}, nil
+}
+
+// Issue will sign the provided template with the CA's private key, binding
+// it to the provided public key, and return the parsed Certificate.
+func (ca CA) Issue(template *x509.Certificate, pub crypto.PublicKey) (*x509.Certificate, error) {
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.Certificate, pub, ca.PrivateKey)
+	if err != nil {
+		return nil, err
+	}
+	return x509.ParseCertificate(der)
+}
+
+// Pool returns a new x509.CertPool containing only the CA Certificate, for
+// use as the Roots of an x509.VerifyOptions.
+func (ca CA) Pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.Certificate)
+	return pool
+}
+
+// Generate a random positive serial number, as big as RFC 5280 allows.
+func serialNumber() (*big.Int, error) {
+	return rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 159))
+}
+
+// Fill in any zero validity times with a period starting an hour ago, and
+// ending the provided number of years from now.
+func validity(notBefore, notAfter time.Time, years int) (time.Time, time.Time) {
+	now := time.Now()
+	if notBefore.IsZero() {
+		notBefore = now.Add(-time.Hour)
+	}
+	if notAfter.IsZero() {
+		notAfter = now.AddDate(years, 0, 0)
+	}
+	return notBefore, notAfter
+}
+
+// vim: foldmethod=marker
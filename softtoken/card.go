@@ -0,0 +1,388 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"bytes"
+	"crypto/rsa"
+	"math/big"
+	"sync"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+// Card is a simulated PIV card, answering SP 800-73-4 APDUs using the
+// Token's objects and keys. This implements the pcsc.Transport interface,
+// allowing the pcsc backend to be exercised without a reader.
+//
+// Only the commands needed to read the Token and manage its PINs are
+// supported: SELECT, GET DATA, VERIFY, CHANGE REFERENCE DATA, RESET RETRY
+// COUNTER, GENERAL AUTHENTICATE and GET RESPONSE.
+type Card struct {
+	token *Token
+
+	// Held for each command, since the chain and pending response are
+	// shared by everyone using the Card.
+	mu sync.Mutex
+
+	// Data sent so far in a command chain.
+	chain []byte
+
+	// Response data that has yet to be collected with GET RESPONSE.
+	pending []byte
+}
+
+// Card returns a simulated card backed by this Token. PIN verification
+// done through the Card is shared with the Token.
+func (t *Token) Card() *Card {
+	return &Card{token: t}
+}
+
+var (
+	cardAID = []byte{0xA0, 0x00, 0x00, 0x03, 0x08, 0x00, 0x00, 0x10, 0x00}
+
+	swSuccess                    = []byte{0x90, 0x00}
+	swWrongLength                = []byte{0x67, 0x00}
+	swSecurityStatusNotSatisfied = []byte{0x69, 0x82}
+	swAuthenticationBlocked      = []byte{0x69, 0x83}
+	swIncorrectData              = []byte{0x6A, 0x80}
+	swNotFound                   = []byte{0x6A, 0x82}
+	swIncorrectP1P2              = []byte{0x6A, 0x86}
+	swInstructionNotSupported    = []byte{0x6D, 0x00}
+)
+
+// Transmit handles a single command APDU, returning the response data and
+// status word.
+func (c *Card) Transmit(apdu []byte) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if len(apdu) < 4 {
+		return swWrongLength, nil
+	}
+	cla, ins, p1, p2 := apdu[0], apdu[1], apdu[2], apdu[3]
+
+	// Short APDUs only: Lc and the data, then Le, each of which may be
+	// missing.
+	var (
+		data  []byte
+		hasLe bool
+	)
+	switch body := apdu[4:]; {
+	case len(body) == 0:
+	case len(body) == 1:
+		hasLe = true
+	default:
+		lc := int(body[0])
+		switch len(body) {
+		case 1 + lc:
+		case 1 + lc + 1:
+			hasLe = true
+		default:
+			return swWrongLength, nil
+		}
+		data = body[1 : 1+lc]
+	}
+
+	// Like some real cards, commands that only return a status word are
+	// rejected if they ask for response data.
+	switch ins {
+	case 0x20, 0x24, 0x2C:
+		if hasLe {
+			return swWrongLength, nil
+		}
+	}
+
+	if cla&0x10 != 0 {
+		c.chain = append(c.chain, data...)
+		return swSuccess, nil
+	}
+	data = append(c.chain, data...)
+	c.chain = nil
+
+	if ins != 0xC0 {
+		c.pending = nil
+	}
+
+	switch ins {
+	case 0xA4:
+		if p1 != 0x04 || !bytes.HasPrefix(data, cardAID) {
+			return swNotFound, nil
+		}
+		return c.respond(tlv.Encode(0x61, tlv.Encode(0x4F, cardAID)))
+	case 0xCB:
+		return c.getData(p1, p2, data)
+	case 0x20:
+		return c.verify(p2, data)
+	case 0x24:
+		return c.changeReference(p2, data)
+	case 0x2C:
+		return c.resetRetryCounter(p2, data)
+	case 0x87:
+		return c.generalAuthenticate(p1, p2, data)
+	case 0xC0:
+		return c.respond(c.pending)
+	default:
+		return swInstructionNotSupported, nil
+	}
+}
+
+// respond will return up to 256 bytes of the response, keeping the rest
+// to be collected with GET RESPONSE.
+func (c *Card) respond(data []byte) ([]byte, error) {
+	if len(data) <= 0x100 {
+		c.pending = nil
+		return append(append([]byte{}, data...), swSuccess...), nil
+	}
+	c.pending = data[0x100:]
+	remaining := byte(0)
+	if len(c.pending) < 0x100 {
+		remaining = byte(len(c.pending))
+	}
+	return append(append([]byte{}, data[:0x100]...), 0x61, remaining), nil
+}
+
+// objects returns the data objects on the card, keyed by their tag.
+func (c *Card) objects() map[string][]byte {
+	t := c.token
+	objects := map[string][]byte{
+		"\x5F\xC1\x01": certificateContainer(t.cardAuthentication),
+		"\x5F\xC1\x02": t.chuid,
+		"\x5F\xC1\x05": certificateContainer(t.authentication),
+		"\x5F\xC1\x06": t.securityObject,
+		"\x5F\xC1\x07": t.ccc,
+		"\x5F\xC1\x08": t.facial,
+		"\x5F\xC1\x09": t.printed,
+		"\x5F\xC1\x0A": certificateContainer(t.digitalSignature),
+		"\x5F\xC1\x0B": certificateContainer(t.keyManagement),
+		"\x7E":         t.discovery,
+	}
+	if t.fingerprints != nil {
+		objects["\x5F\xC1\x03"] = t.fingerprints
+	}
+	if t.iris != nil {
+		objects["\x5F\xC1\x21"] = t.iris
+	}
+	if t.keyHistory != nil {
+		objects["\x5F\xC1\x0C"] = t.keyHistory
+	}
+	for i, s := range t.retiredKeyManagement[:t.onCardRetired] {
+		objects[string([]byte{0x5F, 0xC1, 0x0D + byte(i)})] = certificateContainer(s)
+	}
+	return objects
+}
+
+// certificateContainer will wrap the slot's Certificate as it would be
+// stored on a card, uncompressed.
+func certificateContainer(s slot) []byte {
+	body := tlv.Encode(0x70, s.certificate.Raw)
+	body = append(body, tlv.Encode(0x71, []byte{0x00})...)
+	body = append(body, tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, body)
+}
+
+func (c *Card) getData(p1, p2 byte, data []byte) ([]byte, error) {
+	if p1 != 0x3F || p2 != 0xFF {
+		return swIncorrectP1P2, nil
+	}
+	if len(data) < 2 || data[0] != 0x5C || int(data[1]) != len(data)-2 {
+		return swIncorrectData, nil
+	}
+	tag := string(data[2:])
+	object, ok := c.objects()[tag]
+	if !ok {
+		return swNotFound, nil
+	}
+	// The Printed Information and biometrics other than the facial image
+	// are PIN protected.
+	switch tag {
+	case "\x5F\xC1\x09", "\x5F\xC1\x03", "\x5F\xC1\x21":
+		if !c.token.isVerified() {
+			return swSecurityStatusNotSatisfied, nil
+		}
+	}
+	return c.respond(object)
+}
+
+// pinStatus returns the status word for the result of a PIN operation.
+func pinStatus(err error) []byte {
+	switch err := err.(type) {
+	case nil:
+		return swSuccess
+	case piv.ErrWrongPIN:
+		return []byte{0x63, 0xC0 | byte(err.Remaining)}
+	default:
+		if err == piv.ErrPINBlocked {
+			return swAuthenticationBlocked
+		}
+		return swIncorrectData
+	}
+}
+
+// unpadPIN splits the data into the 8 byte padded PINs it's made of.
+func unpadPIN(data []byte, count int) ([]string, bool) {
+	if len(data) != count*8 {
+		return nil, false
+	}
+	ret := []string{}
+	for i := 0; i < count; i++ {
+		ret = append(ret, string(bytes.TrimRight(data[i*8:(i+1)*8], "\xFF")))
+	}
+	return ret, true
+}
+
+func (c *Card) verify(p2 byte, data []byte) ([]byte, error) {
+	var pin *secret
+	switch p2 {
+	case 0x80:
+		pin = &c.token.pin
+	case 0x00:
+		pin = c.token.globalPIN
+	}
+	if pin == nil {
+		return swNotFound, nil
+	}
+
+	if len(data) == 0 {
+		return c.token.verifyStatus(pin), nil
+	}
+	pins, ok := unpadPIN(data, 1)
+	if !ok {
+		return swIncorrectData, nil
+	}
+	if p2 == 0x00 {
+		return pinStatus(c.token.VerifyGlobalPIN(pins[0])), nil
+	}
+	return pinStatus(c.token.VerifyPIN(pins[0])), nil
+}
+
+// verifyStatus returns the status word for VERIFY without any data, which
+// asks if the PIN has been verified, or else how many tries it has left.
+func (t *Token) verifyStatus(pin *secret) []byte {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.verified {
+		return swSuccess
+	}
+	if pin.retries == 0 {
+		return swAuthenticationBlocked
+	}
+	return []byte{0x63, 0xC0 | byte(pin.retries)}
+}
+
+func (c *Card) changeReference(p2 byte, data []byte) ([]byte, error) {
+	pins, ok := unpadPIN(data, 2)
+	if !ok {
+		return swIncorrectData, nil
+	}
+	switch {
+	case p2 == 0x80:
+		return pinStatus(c.token.ChangePIN(pins[0], pins[1])), nil
+	case p2 == 0x00 && c.token.globalPIN != nil:
+		return pinStatus(c.token.ChangeGlobalPIN(pins[0], pins[1])), nil
+	default:
+		return swNotFound, nil
+	}
+}
+
+func (c *Card) resetRetryCounter(p2 byte, data []byte) ([]byte, error) {
+	if p2 != 0x80 {
+		return swNotFound, nil
+	}
+	pins, ok := unpadPIN(data, 2)
+	if !ok {
+		return swIncorrectData, nil
+	}
+	return pinStatus(c.token.ResetRetryCounter(pins[0], pins[1])), nil
+}
+
+// generalAuthenticate does the raw RSA private key operation on the
+// challenge, as a card would. The padding is left entirely to the host.
+func (c *Card) generalAuthenticate(p1, p2 byte, data []byte) ([]byte, error) {
+	var (
+		s           slot
+		pinRequired = true
+	)
+	switch p2 {
+	case 0x9A:
+		s = c.token.authentication
+	case 0x9C:
+		s = c.token.digitalSignature
+	case 0x9D:
+		s = c.token.keyManagement
+	case 0x9E:
+		s, pinRequired = c.token.cardAuthentication, false
+	default:
+		i := int(p2) - 0x82
+		if i < 0 || i >= len(c.token.retiredKeyManagement) {
+			return swNotFound, nil
+		}
+		s = c.token.retiredKeyManagement[i]
+	}
+	if s.key == nil {
+		return swNotFound, nil
+	}
+	if !algorithmMatches(p1, s.key) {
+		return swIncorrectP1P2, nil
+	}
+	if pinRequired && !c.token.isVerified() {
+		return swSecurityStatusNotSatisfied, nil
+	}
+
+	template, err := tlv.Unwrap(data, 0x7C, tlv.Strict)
+	if err != nil {
+		return swIncorrectData, nil
+	}
+	elements, err := tlv.Decode(template)
+	if err != nil {
+		return swIncorrectData, nil
+	}
+	challenge := elements.Find(0x81)
+
+	size := s.key.Size()
+	if challenge == nil || len(challenge.Value) != size {
+		return swIncorrectData, nil
+	}
+	m := new(big.Int).SetBytes(challenge.Value)
+	if m.Cmp(s.key.N) >= 0 {
+		return swIncorrectData, nil
+	}
+	result := new(big.Int).Exp(m, s.key.D, s.key.N).Bytes()
+	result = append(make([]byte, size-len(result)), result...)
+
+	return c.respond(tlv.Encode(0x7C, tlv.Encode(0x82, result)))
+}
+
+// algorithmMatches checks the Cryptographic Algorithm Identifier sent by
+// the host against the key in the slot.
+func algorithmMatches(algorithm byte, key *rsa.PrivateKey) bool {
+	switch algorithm {
+	case 0x06:
+		return key.N.BitLen() == 1024
+	case 0x07:
+		return key.N.BitLen() == 2048
+	default:
+		return false
+	}
+}
+
+// vim: foldmethod=marker
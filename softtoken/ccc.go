@@ -0,0 +1,71 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	// GSC-RID, the Registered Application Provider Identifier of the GSC-IS.
+	gscRID = []byte{0xA0, 0x00, 0x00, 0x01, 0x16}
+)
+
+// Create the CCC for the card defined by the Config. The card ID is taken
+// from the Card UUID, so each Token has its own Card Identifier.
+func syntheticCCC(config Config) []byte {
+	identifier := append([]byte{}, gscRID...)
+	// Manufacturer ID and card type, both unregistered.
+	identifier = append(identifier, 0xFF, 0x02)
+	identifier = append(identifier, config.UUID[:14]...)
+
+	content := tlv.Encode(0xF0, identifier)
+	for _, el := range []struct {
+		tag   tlv.Tag
+		value []byte
+	}{
+		{0xF1, []byte{0x21}},
+		{0xF2, []byte{0x21}},
+		{0xF3, nil},
+		{0xF4, []byte{0x00}},
+		{0xF5, []byte{0x10}},
+		{0xF6, nil},
+		{0xF7, nil},
+		{0xFA, nil},
+		{0xFB, nil},
+		{0xFC, nil},
+		{0xFD, nil},
+		{0xFE, nil},
+	} {
+		content = append(content, tlv.Encode(el.tag, el.value)...)
+	}
+	return tlv.Encode(0x53, content)
+}
+
+func (t *Token) CardCapabilityContainer() (*piv.CardCapabilityContainer, error) {
+	if t.ccc == nil {
+		return nil, NotFound
+	}
+	return piv.ParseCardCapabilityContainer(t.ccc)
+}
+
+// vim: foldmethod=marker
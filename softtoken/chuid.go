@@ -0,0 +1,62 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto"
+	"encoding/asn1"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/internal/cms"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	oidCHUIDSecurityObject = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 1}
+)
+
+// Create the CHUID for the card defined by the Config, signed by the
+// content signer.
+func syntheticCHUID(config Config, signer slot) ([]byte, error) {
+	content := append(tlv.Encode(0x30, config.FASCN), tlv.Encode(0x34, config.UUID[:])...)
+	content = append(content, tlv.Encode(0x35, []byte(config.NotAfter.UTC().Format("20060102")))...)
+
+	signature, err := cms.Sign(
+		oidCHUIDSecurityObject, content, true,
+		signer.certificate, signer.key, crypto.SHA256,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	content = append(content, tlv.Encode(0x3E, signature)...)
+	content = append(content, tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, content), nil
+}
+
+func (t *Token) CHUID() (*piv.CHUID, error) {
+	if t.chuid == nil {
+		return nil, NotFound
+	}
+	return piv.ParseCHUID(t.chuid)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,107 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"context"
+	"crypto"
+
+	"pault.ag/go/piv"
+)
+
+// The software Token never blocks, so the context variants of each method
+// only check that the context isn't already done before doing the same as
+// the method without a context. This lets the Token stand in for a
+// hardware backed piv.TokenContext.
+
+func (t *Token) AuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.AuthenticationCertificate()
+}
+
+func (t *Token) DigitalSignatureCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.DigitalSignatureCertificate()
+}
+
+func (t *Token) KeyManagementCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.KeyManagementCertificate()
+}
+
+func (t *Token) CardAuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.CardAuthenticationCertificate()
+}
+
+func (t *Token) AuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.AuthenticationSigner()
+}
+
+func (t *Token) DigitalSignatureSignerContext(ctx context.Context) (crypto.Signer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.DigitalSignatureSigner()
+}
+
+func (t *Token) KeyManagementDecrypterContext(ctx context.Context) (crypto.Decrypter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.KeyManagementDecrypter()
+}
+
+func (t *Token) CardAuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.CardAuthenticationSigner()
+}
+
+func (t *Token) RetiredKeyManagementCertificatesContext(ctx context.Context) ([]*piv.Certificate, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.RetiredKeyManagementCertificates()
+}
+
+func (t *Token) RetiredKeyManagementDecryptersContext(ctx context.Context) ([]crypto.Decrypter, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return t.RetiredKeyManagementDecrypters()
+}
+
+var _ piv.TokenContext = &Token{}
+
+// vim: foldmethod=marker
@@ -0,0 +1,47 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+// Create the Discovery Object for the card. The PIV Card Application PIN is
+// always supported, and preferred over the Global PIN, if there is one.
+func syntheticDiscoveryObject(config Config) []byte {
+	aid := append(append([]byte{}, cardAID...), 0x01, 0x00)
+	policy := []byte{0x40, 0x00}
+	if len(config.GlobalPIN) != 0 {
+		policy = []byte{0x60, 0x10}
+	}
+	content := append(tlv.Encode(0x4F, aid), tlv.Encode(0x5F2F, policy)...)
+	return tlv.Encode(0x7E, content)
+}
+
+func (t *Token) DiscoveryObject() (*piv.DiscoveryObject, error) {
+	if t.discovery == nil {
+		return nil, NotFound
+	}
+	return piv.ParseDiscoveryObject(t.discovery)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,91 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"io"
+)
+
+// privateKey wraps the in-memory key for a slot, refusing to use it until
+// the PIN has been verified, as a real card would.
+type privateKey struct {
+	token       *Token
+	key         *rsa.PrivateKey
+	pinRequired bool
+}
+
+func (k privateKey) Public() crypto.PublicKey {
+	return k.key.Public()
+}
+
+func (k privateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	if k.pinRequired && !k.token.isVerified() {
+		return nil, PINRequired
+	}
+	return k.key.Sign(rand, digest, opts)
+}
+
+func (k privateKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	if k.pinRequired && !k.token.isVerified() {
+		return nil, PINRequired
+	}
+	return k.key.Decrypt(rand, msg, opts)
+}
+
+func (t *Token) privateKey(s slot, pinRequired bool) (*privateKey, error) {
+	if s.key == nil {
+		return nil, NotFound
+	}
+	return &privateKey{token: t, key: s.key, pinRequired: pinRequired}, nil
+}
+
+func (t *Token) signer(s slot, pinRequired bool) (crypto.Signer, error) {
+	key, err := t.privateKey(s, pinRequired)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+func (t *Token) AuthenticationSigner() (crypto.Signer, error) {
+	return t.signer(t.authentication, true)
+}
+
+func (t *Token) DigitalSignatureSigner() (crypto.Signer, error) {
+	return t.signer(t.digitalSignature, true)
+}
+
+func (t *Token) KeyManagementDecrypter() (crypto.Decrypter, error) {
+	key, err := t.privateKey(t.keyManagement, true)
+	if err != nil {
+		return nil, err
+	}
+	return key, nil
+}
+
+// The Card Authentication key may be used without the PIN.
+func (t *Token) CardAuthenticationSigner() (crypto.Signer, error) {
+	return t.signer(t.cardAuthentication, false)
+}
+
+// vim: foldmethod=marker
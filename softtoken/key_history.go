@@ -0,0 +1,113 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto"
+	"crypto/sha256"
+	"encoding/asn1"
+	"encoding/hex"
+	"strings"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+// certContainer is an entry in the OffCardKeyHistoryFile.
+type certContainer struct {
+	KeyReference []byte
+	Cert         asn1.RawValue
+}
+
+// Create the Key History Object for a card with the provided retired Key
+// Management slots, the first onCard of which have their Certificates on
+// the card. If any are left over, the OffCardKeyHistoryFile holding their
+// Certificates is created too.
+func syntheticKeyHistory(config Config, retired []slot, onCard int) ([]byte, []byte, error) {
+	offCard := len(retired) - onCard
+
+	content := append(
+		tlv.Encode(0xC1, []byte{byte(onCard)}),
+		tlv.Encode(0xC2, []byte{byte(offCard)})...,
+	)
+
+	var file []byte
+	if offCard != 0 {
+		containers := []certContainer{}
+		for i, s := range retired[onCard:] {
+			containers = append(containers, certContainer{
+				KeyReference: []byte{byte(0x82 + onCard + i)},
+				Cert:         asn1.RawValue{FullBytes: s.certificate.Raw},
+			})
+		}
+		var err error
+		file, err = asn1.Marshal(containers)
+		if err != nil {
+			return nil, nil, err
+		}
+		hash := sha256.Sum256(file)
+		url := strings.TrimSuffix(config.OffCardCertURLBase, "/") + "/" + hex.EncodeToString(hash[:])
+		content = append(content, tlv.Encode(0xF3, []byte(url))...)
+	}
+
+	content = append(content, tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, content), file, nil
+}
+
+// OffCardKeyHistoryFile returns the DER encoded OffCardKeyHistoryFile that
+// should be served at the Key History's offCardCertURL. If the Token has no
+// off card retired keys, this is nil.
+func (t *Token) OffCardKeyHistoryFile() []byte {
+	return t.offCardKeyHistory
+}
+
+func (t *Token) KeyHistory() (*piv.KeyHistory, error) {
+	if t.keyHistory == nil {
+		return nil, NotFound
+	}
+	return piv.ParseKeyHistory(t.keyHistory)
+}
+
+func (t *Token) RetiredKeyManagementCertificates() ([]*piv.Certificate, error) {
+	certs := []*piv.Certificate{}
+	for _, s := range t.retiredKeyManagement[:t.onCardRetired] {
+		cert, err := t.certificate(s)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+func (t *Token) RetiredKeyManagementDecrypters() ([]crypto.Decrypter, error) {
+	keys := []crypto.Decrypter{}
+	for _, s := range t.retiredKeyManagement[:t.onCardRetired] {
+		key, err := t.privateKey(s, true)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// vim: foldmethod=marker
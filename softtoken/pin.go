@@ -0,0 +1,161 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto/subtle"
+	"fmt"
+
+	"pault.ag/go/piv"
+)
+
+const (
+	maxPINRetries = 3
+	maxPUKRetries = 3
+)
+
+// secret is a PIN or PUK, along with the number of tries left before it's
+// blocked.
+type secret struct {
+	value   string
+	retries int
+	max     int
+}
+
+func newSecret(value string, max int) secret {
+	return secret{value: value, retries: max, max: max}
+}
+
+// check will compare the guess against the secret, counting down the tries
+// left if it's wrong, and resetting them if it's right.
+func (s *secret) check(guess string) error {
+	if s.retries == 0 {
+		return piv.ErrPINBlocked
+	}
+	if subtle.ConstantTimeCompare([]byte(guess), []byte(s.value)) != 1 {
+		s.retries--
+		if s.retries == 0 {
+			return piv.ErrPINBlocked
+		}
+		return piv.ErrWrongPIN{Remaining: s.retries}
+	}
+	s.retries = s.max
+	return nil
+}
+
+// Ensure a new PIN is one a card would accept, which is 6 to 8 characters.
+func checkNewPIN(pin string) error {
+	if len(pin) < 6 || len(pin) > 8 {
+		return fmt.Errorf("piv: softtoken: PIN must be 6 to 8 characters")
+	}
+	return nil
+}
+
+// VerifyPIN will check the given PIN against the Token's PIN, and if it
+// matches, unlock the PIN protected slots. If it doesn't, a piv.ErrWrongPIN
+// is returned with the number of tries left.
+func (t *Token) VerifyPIN(pin string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := t.pin.check(pin); err != nil {
+		return err
+	}
+	t.verified = true
+	return nil
+}
+
+func (t *Token) ChangePIN(oldPIN, newPIN string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := checkNewPIN(newPIN); err != nil {
+		return err
+	}
+	if err := t.pin.check(oldPIN); err != nil {
+		return err
+	}
+	t.pin.value = newPIN
+	return nil
+}
+
+// ResetRetryCounter will unblock the PIN using the PUK, setting a new PIN.
+func (t *Token) ResetRetryCounter(puk, newPIN string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if err := checkNewPIN(newPIN); err != nil {
+		return err
+	}
+	if err := t.puk.check(puk); err != nil {
+		return err
+	}
+	t.pin = newSecret(newPIN, maxPINRetries)
+	return nil
+}
+
+func (t *Token) PINRetriesRemaining() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.pin.retries, nil
+}
+
+// VerifyGlobalPIN will check the given PIN against the Token's Global PIN,
+// and if it matches, unlock the PIN protected slots. If the Token was
+// created without a Global PIN, NotFound is returned.
+func (t *Token) VerifyGlobalPIN(pin string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.globalPIN == nil {
+		return NotFound
+	}
+	if err := t.globalPIN.check(pin); err != nil {
+		return err
+	}
+	t.verified = true
+	return nil
+}
+
+func (t *Token) ChangeGlobalPIN(oldPIN, newPIN string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.globalPIN == nil {
+		return NotFound
+	}
+	if err := checkNewPIN(newPIN); err != nil {
+		return err
+	}
+	if err := t.globalPIN.check(oldPIN); err != nil {
+		return err
+	}
+	t.globalPIN.value = newPIN
+	return nil
+}
+
+func (t *Token) GlobalPINRetriesRemaining() (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.globalPIN == nil {
+		return 0, NotFound
+	}
+	return t.globalPIN.retries, nil
+}
+
+var _ piv.GlobalPINManager = &Token{}
+
+// vim: foldmethod=marker
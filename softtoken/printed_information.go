@@ -0,0 +1,68 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"encoding/hex"
+	"strings"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/tlv"
+)
+
+// Create the Printed Information for the card defined by the Config. The
+// Agency Card Serial Number is taken from the Card UUID.
+func syntheticPrintedInformation(config Config) []byte {
+	name := config.Subject.CommonName
+	if len(name) > 125 {
+		name = name[:125]
+	}
+	expiration := strings.ToUpper(config.NotAfter.UTC().Format("2006Jan02"))
+
+	content := tlv.Encode(0x01, []byte(name))
+	content = append(content, tlv.Encode(0x02, []byte("Employee"))...)
+	content = append(content, tlv.Encode(0x04, []byte(expiration))...)
+	content = append(content, tlv.Encode(0x05, []byte(hex.EncodeToString(config.UUID[:10])))...)
+	content = append(content, tlv.Encode(0x06, []byte("SOFTTOKEN"))...)
+	if len(config.Subject.Organization) != 0 {
+		organization := config.Subject.Organization[0]
+		if len(organization) > 20 {
+			organization = organization[:20]
+		}
+		content = append(content, tlv.Encode(0x07, []byte(organization))...)
+	}
+	content = append(content, tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, content)
+}
+
+// The Printed Information may only be read once the PIN has been verified,
+// as a real card would require.
+func (t *Token) PrintedInformation() (*piv.PrintedInformation, error) {
+	if t.printed == nil {
+		return nil, NotFound
+	}
+	if !t.isVerified() {
+		return nil, PINRequired
+	}
+	return piv.ParsePrintedInformation(t.printed)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,222 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+)
+
+var (
+	oidSubjectAltName = asn1.ObjectIdentifier{2, 5, 29, 17}
+	oidUPN            = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 3}
+	oidFASCN          = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 6}
+
+	oidSmartcardLogon = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 20, 2, 2}
+	oidPIVCardAuth    = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 8}
+	oidContentSigning = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 7}
+
+	oidCommonHW       = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 7}
+	oidCommonAuth     = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 13}
+	oidCommonCardAuth = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 17}
+
+	oidCommonPIVContentSigning = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 2, 1, 3, 39}
+)
+
+// certificateProfile defines the shape of the certificate issued for a
+// specific PIV key slot, loosely following the X.509 Certificate and CRL
+// Extensions Profile for PIV.
+type certificateProfile struct {
+	KeyUsage           x509.KeyUsage
+	ExtKeyUsage        []x509.ExtKeyUsage
+	UnknownExtKeyUsage []asn1.ObjectIdentifier
+	Policies           []asn1.ObjectIdentifier
+
+	// If true, the cardholder's UPN is added to the SAN.
+	PrincipalName bool
+
+	// If true, the cardholder's email address is added to the SAN.
+	EmailAddress bool
+
+	// If true, the card's FASC-N and UUID are added to the SAN.
+	CardIdentifiers bool
+
+	// If set, used as the Subject instead of the cardholder's name.
+	Subject *pkix.Name
+}
+
+var (
+	authenticationProfile = certificateProfile{
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:        []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidSmartcardLogon},
+		Policies:           []asn1.ObjectIdentifier{oidCommonAuth},
+		PrincipalName:      true,
+		CardIdentifiers:    true,
+	}
+
+	digitalSignatureProfile = certificateProfile{
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageContentCommitment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		Policies:     []asn1.ObjectIdentifier{oidCommonHW},
+		EmailAddress: true,
+	}
+
+	keyManagementProfile = certificateProfile{
+		KeyUsage:     x509.KeyUsageKeyEncipherment,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection},
+		Policies:     []asn1.ObjectIdentifier{oidCommonHW},
+		EmailAddress: true,
+	}
+
+	cardAuthenticationProfile = certificateProfile{
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidPIVCardAuth},
+		Policies:           []asn1.ObjectIdentifier{oidCommonCardAuth},
+		CardIdentifiers:    true,
+	}
+
+	contentSignerProfile = certificateProfile{
+		KeyUsage:           x509.KeyUsageDigitalSignature,
+		UnknownExtKeyUsage: []asn1.ObjectIdentifier{oidContentSigning},
+		Policies:           []asn1.ObjectIdentifier{oidCommonPIVContentSigning},
+		Subject:            &pkix.Name{CommonName: "Synthetic PIV Content Signer"},
+	}
+)
+
+// Create the x509.Certificate template for this profile, filled in with
+// the cardholder information from the Config.
+func (p certificateProfile) template(config Config) (*x509.Certificate, error) {
+	serial, err := serialNumber()
+	if err != nil {
+		return nil, err
+	}
+
+	san, err := p.subjectAltName(config)
+	if err != nil {
+		return nil, err
+	}
+
+	subject := config.Subject
+	if p.Subject != nil {
+		subject = *p.Subject
+	}
+
+	template := x509.Certificate{
+		SerialNumber:       serial,
+		Subject:            subject,
+		NotBefore:          config.NotBefore,
+		NotAfter:           config.NotAfter,
+		KeyUsage:           p.KeyUsage,
+		ExtKeyUsage:        p.ExtKeyUsage,
+		UnknownExtKeyUsage: p.UnknownExtKeyUsage,
+		PolicyIdentifiers:  p.Policies,
+	}
+	if san != nil {
+		template.ExtraExtensions = []pkix.Extension{*san}
+	}
+	return &template, nil
+}
+
+// Build the SubjectAltName extension by hand, since crypto/x509 has no
+// support for writing OtherName entries. If there are no names to be
+// added, this will return nil.
+func (p certificateProfile) subjectAltName(config Config) (*pkix.Extension, error) {
+	names := []asn1.RawValue{}
+
+	if p.PrincipalName && len(config.PrincipalName) != 0 {
+		upn, err := asn1.MarshalWithParams(config.PrincipalName, "utf8")
+		if err != nil {
+			return nil, err
+		}
+		name, err := otherName(oidUPN, upn)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, *name)
+	}
+
+	if p.EmailAddress && len(config.EmailAddress) != 0 {
+		names = append(names, asn1.RawValue{
+			Class: asn1.ClassContextSpecific,
+			Tag:   1,
+			Bytes: []byte(config.EmailAddress),
+		})
+	}
+
+	if p.CardIdentifiers {
+		fascn, err := asn1.Marshal(config.FASCN)
+		if err != nil {
+			return nil, err
+		}
+		name, err := otherName(oidFASCN, fascn)
+		if err != nil {
+			return nil, err
+		}
+		names = append(names, *name)
+
+		names = append(names, asn1.RawValue{
+			Class: asn1.ClassContextSpecific,
+			Tag:   6,
+			Bytes: []byte(config.UUID.URN()),
+		})
+	}
+
+	if len(names) == 0 {
+		return nil, nil
+	}
+
+	value, err := asn1.Marshal(names)
+	if err != nil {
+		return nil, err
+	}
+
+	return &pkix.Extension{Id: oidSubjectAltName, Value: value}, nil
+}
+
+// Create an OtherName GeneralName entry, with the (already DER encoded)
+// value wrapped in an explicit tag.
+func otherName(id asn1.ObjectIdentifier, value []byte) (*asn1.RawValue, error) {
+	oid, err := asn1.Marshal(id)
+	if err != nil {
+		return nil, err
+	}
+
+	explicit, err := asn1.Marshal(asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      value,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &asn1.RawValue{
+		Class:      asn1.ClassContextSpecific,
+		Tag:        0,
+		IsCompound: true,
+		Bytes:      append(oid, explicit...),
+	}, nil
+}
+
+// vim: foldmethod=marker
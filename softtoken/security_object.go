@@ -0,0 +1,151 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken
+
+import (
+	"crypto"
+	"encoding/asn1"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/internal/cms"
+	"pault.ag/go/piv/tlv"
+)
+
+var (
+	oidLDSSecurityObject = asn1.ObjectIdentifier{2, 23, 136, 1, 1, 1}
+)
+
+type ldsSecurityObject struct {
+	Version             int
+	HashAlgorithm       algorithmIdentifier
+	DataGroupHashValues []dataGroupHash
+}
+
+type algorithmIdentifier struct {
+	Algorithm asn1.ObjectIdentifier
+}
+
+type dataGroupHash struct {
+	DataGroupNumber    int
+	DataGroupHashValue []byte
+}
+
+// Create the Security Object covering the given data objects, each of which
+// is given the next data group number, starting at 1. The LDS Security
+// Object is signed by the content signer.
+func syntheticSecurityObject(signer slot, containers []piv.ContainerID, objects [][]byte) ([]byte, error) {
+	hash := crypto.SHA256
+	hashID, err := cms.DigestAlgorithmID(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	mapping := []byte{}
+	lds := ldsSecurityObject{HashAlgorithm: algorithmIdentifier{Algorithm: hashID}}
+	for i, id := range containers {
+		mapping = append(mapping, byte(i+1), byte(id>>8), byte(id))
+
+		value, err := tlv.Unwrap(objects[i], 0x53, tlv.Strict)
+		if err != nil {
+			return nil, err
+		}
+		h := hash.New()
+		h.Write(value)
+		lds.DataGroupHashValues = append(lds.DataGroupHashValues, dataGroupHash{
+			DataGroupNumber:    i + 1,
+			DataGroupHashValue: h.Sum(nil),
+		})
+	}
+
+	content, err := asn1.Marshal(lds)
+	if err != nil {
+		return nil, err
+	}
+
+	signature, err := cms.Sign(
+		oidLDSSecurityObject, content, false,
+		signer.certificate, signer.key, hash,
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	body := tlv.Encode(0xBA, mapping)
+	body = append(body, tlv.Encode(0xBB, signature)...)
+	body = append(body, tlv.Encode(0xFE, nil)...)
+	return tlv.Encode(0x53, body), nil
+}
+
+func (t *Token) SecurityObject() (*piv.SecurityObject, error) {
+	if t.securityObject == nil {
+		return nil, NotFound
+	}
+	return piv.ParseSecurityObject(t.securityObject)
+}
+
+// Container will return the raw data object with the given ContainerID, as
+// it would be read off a card, for checking against the Security Object. As
+// on a card, the PIN must be verified to read the Printed Information,
+// Fingerprints and Iris images.
+func (t *Token) Container(id piv.ContainerID) ([]byte, error) {
+	var data []byte
+	switch id {
+	case piv.ContainerCCC:
+		data = t.ccc
+	case piv.ContainerCHUID:
+		data = t.chuid
+	case piv.ContainerFingerprints:
+		if t.fingerprints != nil && !t.isVerified() {
+			return nil, PINRequired
+		}
+		data = t.fingerprints
+	case piv.ContainerIris:
+		if t.iris != nil && !t.isVerified() {
+			return nil, PINRequired
+		}
+		data = t.iris
+	case piv.ContainerFacial:
+		data = t.facial
+	case piv.ContainerPrintedInformation:
+		if t.printed != nil && !t.isVerified() {
+			return nil, PINRequired
+		}
+		data = t.printed
+	case piv.ContainerDiscoveryObject:
+		data = t.discovery
+	case piv.ContainerKeyHistory:
+		data = t.keyHistory
+	case piv.ContainerAuthenticationCertificate:
+		return certificateContainer(t.authentication), nil
+	case piv.ContainerDigitalSignatureCertificate:
+		return certificateContainer(t.digitalSignature), nil
+	case piv.ContainerKeyManagementCertificate:
+		return certificateContainer(t.keyManagement), nil
+	case piv.ContainerCardAuthenticationCertificate:
+		return certificateContainer(t.cardAuthentication), nil
+	}
+	if data == nil {
+		return nil, NotFound
+	}
+	return data, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,427 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package softtoken implements an entirely in-memory PIV Token, holding
+// synthetic certificates, private keys, biometrics and a PIN. This is
+// intended to allow code written against piv.Token to be exercised without
+// a physical card, YubiKey or PKCS#11 module.
+//
+// None of the key material held by this Token is protected in any way, so
+// this must never be used for anything other than testing.
+package softtoken // import "pault.ag/go/piv/softtoken"
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"sync"
+	"time"
+
+	"pault.ag/go/cbeff"
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/biometrics"
+)
+
+var (
+	// NotFound is returned when the requested object was not loaded onto
+	// the Token. This matches piv.ErrNotFound with errors.Is.
+	NotFound error = &piv.Error{Kind: piv.ErrNotFound, Err: fmt.Errorf("piv: softtoken: Not Found")}
+
+	// PINRequired is returned when a PIN protected private key is used
+	// before the PIN has been verified. This matches piv.ErrAuthRequired
+	// with errors.Is.
+	PINRequired error = &piv.Error{Kind: piv.ErrAuthRequired, Err: fmt.Errorf("piv: softtoken: PIN Required")}
+)
+
+// Config defines the cardholder and card that the software Token will
+// pretend to be. Every field is optional, and sensible synthetic defaults
+// will be used for anything left unset.
+type Config struct {
+	// Certificate Authority used to issue the Token's certificates. If this
+	// is nil, a new CA will be created with default settings.
+	CA *CA
+
+	// Subject of the cardholder certificates. If this is empty, a generic
+	// "Synthetic Cardholder" name will be used.
+	Subject pkix.Name
+
+	// Optional Microsoft UPN to place in the PIV Authentication Certificate.
+	PrincipalName string
+
+	// Optional email address to place in the Digital Signature and Key
+	// Management Certificates.
+	EmailAddress string
+
+	// Raw 25 byte packed FASC-N of the card. If this is nil, the example
+	// FASC-N from the TIG SCEPACS will be used.
+	FASCN []byte
+
+	// Card UUID of the card. If this is the nil UUID, a random UUID will be
+	// generated.
+	UUID piv.UUID
+
+	// PIN that must be given to VerifyPIN. If this is empty, "123456" will
+	// be used.
+	PIN string
+
+	// PUK used to unblock the PIN with ResetRetryCounter. If this is empty,
+	// "12345678" will be used.
+	PUK string
+
+	// Optional Global PIN. If this is empty, the Token will not have a
+	// Global PIN.
+	GlobalPIN string
+
+	// Size of the RSA keys to generate for each slot. If this is zero, 2048
+	// bit keys will be created.
+	Bits int
+
+	// Validity period of the cardholder certificates. If these are zero, the
+	// certificates will be valid from an hour ago until three years from now.
+	NotBefore time.Time
+	NotAfter  time.Time
+
+	// Number of retired Key Management keys to generate, each with its
+	// Certificate on the card. This may be at most
+	// piv.RetiredKeyManagementSlots.
+	RetiredKeys int
+
+	// Number of additional retired Key Management keys to generate, with
+	// their Certificates stored off the card, in the file returned by
+	// OffCardKeyHistoryFile. RetiredKeys and OffCardRetiredKeys together
+	// may be at most piv.RetiredKeyManagementSlots.
+	OffCardRetiredKeys int
+
+	// URL of the directory the off card key history file will be served
+	// from, such as "http://pki.example.gov/keyhistory". The file's name
+	// is appended to this to make the Key History's offCardCertURL. This
+	// must be set if OffCardRetiredKeys is not zero.
+	OffCardCertURLBase string
+
+	// Raw CBEFF of the cardholder's facial image, without the PIV TLV
+	// wrapping. If this is nil, a small synthetic image will be used,
+	// signed by the Token's content signer.
+	Facial []byte
+
+	// Raw CBEFF of the cardholder's fingerprints, without the PIV TLV
+	// wrapping. If this is nil, the Token will not have any fingerprints.
+	Fingerprints []byte
+
+	// Raw CBEFF of the cardholder's iris images, without the PIV TLV
+	// wrapping. If this is nil, the Token will not have any iris images.
+	Iris []byte
+}
+
+var (
+	// Example FASC-N taken from the TIG SCEPACS.
+	defaultFASCN = []byte{
+		0xd0, 0x43, 0x94, 0x58, 0x21, 0x0c, 0x2c, 0x19, 0xa0, 0x84, 0x6d, 0x83,
+		0x68, 0x5a, 0x10, 0x82, 0x10, 0x8c, 0xe7, 0x39, 0x84, 0x10, 0x8c, 0xa3,
+		0xf5,
+	}
+
+	defaultPIN = "123456"
+	defaultPUK = "12345678"
+)
+
+// slot is a single PIV key slot, holding a Certificate and the private key
+// that corresponds to it.
+type slot struct {
+	certificate *x509.Certificate
+	key         *rsa.PrivateKey
+}
+
+// Token is an in-memory PIV card. This implements the piv.Token interface.
+type Token struct {
+	ca *CA
+
+	authentication     slot
+	digitalSignature   slot
+	keyManagement      slot
+	cardAuthentication slot
+
+	// Retired Key Management slots, in slot order. The first
+	// onCardRetired have their Certificate on the card, and the rest have
+	// their Certificate in the offCardKeyHistory file.
+	retiredKeyManagement []slot
+	onCardRetired        int
+	offCardKeyHistory    []byte
+
+	// Key used to sign the data objects on the card, such as the CHUID.
+	// This would never be on a real card, but it's handy to keep around.
+	contentSigner slot
+
+	// PIV TLV wrapped data objects, as they'd be read off a card.
+	chuid          []byte
+	ccc            []byte
+	discovery      []byte
+	keyHistory     []byte
+	printed        []byte
+	facial         []byte
+	fingerprints   []byte
+	iris           []byte
+	securityObject []byte
+
+	// PINs and their retry counters. globalPIN is nil if the Token has no
+	// Global PIN. Verifying either PIN unlocks the Token. These may be
+	// changed from any goroutine using the Token or its Card, so they're
+	// guarded by mu.
+	mu        sync.Mutex
+	pin       secret
+	puk       secret
+	globalPIN *secret
+	verified  bool
+}
+
+// New will create a new software Token defined by the softtoken.Config,
+// generating and issuing keys and certificates for each of the four PIV
+// key slots.
+func New(config Config) (*Token, error) {
+	var err error
+
+	if config.CA == nil {
+		config.CA, err = NewCA(CAConfig{})
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(config.Subject.CommonName) == 0 {
+		config.Subject = pkix.Name{
+			Country:      []string{"US"},
+			Organization: []string{"pault.ag"},
+			CommonName:   "Synthetic Cardholder",
+		}
+	}
+	if config.FASCN == nil {
+		config.FASCN = defaultFASCN
+	}
+	if config.UUID == (piv.UUID{}) {
+		if _, err := rand.Read(config.UUID[:]); err != nil {
+			return nil, err
+		}
+		// Set the version (4) and variant (RFC 4122) bits.
+		config.UUID[6] = (config.UUID[6] & 0x0f) | 0x40
+		config.UUID[8] = (config.UUID[8] & 0x3f) | 0x80
+	}
+	if len(config.PIN) == 0 {
+		config.PIN = defaultPIN
+	}
+	if len(config.PUK) == 0 {
+		config.PUK = defaultPUK
+	}
+	if config.Bits == 0 {
+		config.Bits = 2048
+	}
+	if config.RetiredKeys < 0 || config.OffCardRetiredKeys < 0 ||
+		config.RetiredKeys+config.OffCardRetiredKeys > piv.RetiredKeyManagementSlots {
+		return nil, fmt.Errorf("piv: softtoken: At most %d retired keys are allowed", piv.RetiredKeyManagementSlots)
+	}
+	if config.OffCardRetiredKeys != 0 && len(config.OffCardCertURLBase) == 0 {
+		return nil, fmt.Errorf("piv: softtoken: OffCardCertURLBase is required for off card retired keys")
+	}
+	config.NotBefore, config.NotAfter = validity(config.NotBefore, config.NotAfter, 3)
+
+	token := Token{
+		ca:  config.CA,
+		pin: newSecret(config.PIN, maxPINRetries),
+		puk: newSecret(config.PUK, maxPUKRetries),
+	}
+	if len(config.GlobalPIN) != 0 {
+		globalPIN := newSecret(config.GlobalPIN, maxPINRetries)
+		token.globalPIN = &globalPIN
+	}
+
+	for _, profile := range []struct {
+		slot    *slot
+		profile certificateProfile
+	}{
+		{&token.authentication, authenticationProfile},
+		{&token.digitalSignature, digitalSignatureProfile},
+		{&token.keyManagement, keyManagementProfile},
+		{&token.cardAuthentication, cardAuthenticationProfile},
+		{&token.contentSigner, contentSignerProfile},
+	} {
+		*profile.slot, err = newSlot(config, profile.profile)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for i := 0; i < config.RetiredKeys+config.OffCardRetiredKeys; i++ {
+		retired, err := newSlot(config, keyManagementProfile)
+		if err != nil {
+			return nil, err
+		}
+		token.retiredKeyManagement = append(token.retiredKeyManagement, retired)
+	}
+	token.onCardRetired = config.RetiredKeys
+	if len(token.retiredKeyManagement) != 0 {
+		token.keyHistory, token.offCardKeyHistory, err = syntheticKeyHistory(
+			config, token.retiredKeyManagement, token.onCardRetired,
+		)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if config.Facial == nil {
+		config.Facial, err = syntheticFacial(config, token.contentSigner)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	token.chuid, err = syntheticCHUID(config, token.contentSigner)
+	if err != nil {
+		return nil, err
+	}
+	token.ccc = syntheticCCC(config)
+	token.discovery = syntheticDiscoveryObject(config)
+	token.printed = syntheticPrintedInformation(config)
+	token.facial = wrapBiometric(config.Facial)
+	if config.Fingerprints != nil {
+		token.fingerprints = wrapBiometric(config.Fingerprints)
+	}
+	if config.Iris != nil {
+		token.iris = wrapBiometric(config.Iris)
+	}
+
+	containers := []piv.ContainerID{
+		piv.ContainerCHUID, piv.ContainerFacial, piv.ContainerPrintedInformation,
+	}
+	objects := [][]byte{token.chuid, token.facial, token.printed}
+	if token.fingerprints != nil {
+		containers = append(containers, piv.ContainerFingerprints)
+		objects = append(objects, token.fingerprints)
+	}
+	if token.iris != nil {
+		containers = append(containers, piv.ContainerIris)
+		objects = append(objects, token.iris)
+	}
+	token.securityObject, err = syntheticSecurityObject(token.contentSigner, containers, objects)
+	if err != nil {
+		return nil, err
+	}
+
+	return &token, nil
+}
+
+// Generate a new private key, and have the CA issue a certificate for it
+// defined by the given certificateProfile.
+func newSlot(config Config, profile certificateProfile) (slot, error) {
+	key, err := rsa.GenerateKey(rand.Reader, config.Bits)
+	if err != nil {
+		return slot{}, err
+	}
+
+	template, err := profile.template(config)
+	if err != nil {
+		return slot{}, err
+	}
+
+	cert, err := config.CA.Issue(template, key.Public())
+	if err != nil {
+		return slot{}, err
+	}
+
+	return slot{certificate: cert, key: key}, nil
+}
+
+// CA returns the synthetic Certificate Authority that issued this Token's
+// certificates.
+func (t *Token) CA() *CA {
+	return t.ca
+}
+
+// Close will lock the Token again, requiring the PIN to be verified before
+// any PIN protected slots may be used. This exists for parity with the
+// hardware backed Tokens.
+func (t *Token) Close() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.verified = false
+	return nil
+}
+
+// isVerified reports if the PIN has been verified since the Token was
+// created or last closed.
+func (t *Token) isVerified() bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return t.verified
+}
+
+func (t *Token) certificate(s slot) (*piv.Certificate, error) {
+	if s.certificate == nil {
+		return nil, NotFound
+	}
+	return piv.NewCertificate(s.certificate)
+}
+
+func (t *Token) cbeff(data []byte) (*cbeff.CBEFF, error) {
+	if data == nil {
+		return nil, NotFound
+	}
+	return biometrics.ParseTLVCBEFF(data)
+}
+
+func (t *Token) Facial() (*cbeff.CBEFF, error) {
+	return t.cbeff(t.facial)
+}
+
+// The Fingerprints may only be read once the PIN has been verified, as a real
+// card would require.
+func (t *Token) Fingerprints() (*cbeff.CBEFF, error) {
+	if t.fingerprints != nil && !t.isVerified() {
+		return nil, PINRequired
+	}
+	return t.cbeff(t.fingerprints)
+}
+
+// As with the Fingerprints, the Iris images may only be read once the PIN
+// has been verified.
+func (t *Token) Iris() (*cbeff.CBEFF, error) {
+	if t.iris != nil && !t.isVerified() {
+		return nil, PINRequired
+	}
+	return t.cbeff(t.iris)
+}
+
+func (t *Token) AuthenticationCertificate() (*piv.Certificate, error) {
+	return t.certificate(t.authentication)
+}
+
+func (t *Token) DigitalSignatureCertificate() (*piv.Certificate, error) {
+	return t.certificate(t.digitalSignature)
+}
+
+func (t *Token) KeyManagementCertificate() (*piv.Certificate, error) {
+	return t.certificate(t.keyManagement)
+}
+
+func (t *Token) CardAuthenticationCertificate() (*piv.Certificate, error) {
+	return t.certificate(t.cardAuthentication)
+}
+
+var _ piv.Token = &Token{}
+
+// vim: foldmethod=marker
@@ -0,0 +1,285 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package softtoken_test
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"errors"
+	"sync"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+// newToken creates a software Token with small keys, since generating
+// 2048 bit keys for every slot makes the tests slow.
+func newToken(t *testing.T, config softtoken.Config) *softtoken.Token {
+	t.Helper()
+	if config.CA == nil {
+		ca, err := softtoken.NewCA(softtoken.CAConfig{Bits: 1024})
+		if err != nil {
+			t.Fatal(err)
+		}
+		config.CA = ca
+	}
+	if config.Bits == 0 {
+		config.Bits = 1024
+	}
+	token, err := softtoken.New(config)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return token
+}
+
+func TestCertificates(t *testing.T) {
+	token := newToken(t, softtoken.Config{})
+	opts := piv.VerifyOptions{Roots: token.CA().Pool()}
+
+	for _, test := range []struct {
+		name   string
+		get    func() (*piv.Certificate, error)
+		policy string
+	}{
+		{"authentication", token.AuthenticationCertificate, "commonAuth"},
+		{"digital signature", token.DigitalSignatureCertificate, "commonHW"},
+		{"key management", token.KeyManagementCertificate, "commonHW"},
+		{"card authentication", token.CardAuthenticationCertificate, "commonCardAuth"},
+	} {
+		cert, err := test.get()
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		policies, err := cert.Verify(opts)
+		if err != nil {
+			t.Fatalf("%s: %s", test.name, err)
+		}
+		if len(policies) != 1 || policies[0].Name != test.policy {
+			t.Fatalf("%s: got policies %v, want %s", test.name, policies, test.policy)
+		}
+	}
+
+	other := newToken(t, softtoken.Config{})
+	cert, err := other.AuthenticationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cert.Verify(opts); err == nil {
+		t.Fatal("Certificate from another CA verified")
+	}
+}
+
+func TestKeys(t *testing.T) {
+	token := newToken(t, softtoken.Config{})
+	digest := sha256.Sum256([]byte("softtoken"))
+
+	sign := func(get func() (crypto.Signer, error)) error {
+		signer, err := get()
+		if err != nil {
+			return err
+		}
+		signature, err := signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+		if err != nil {
+			return err
+		}
+		return rsa.VerifyPKCS1v15(signer.Public().(*rsa.PublicKey), crypto.SHA256, digest[:], signature)
+	}
+
+	// Only the Card Authentication key may be used without the PIN.
+	if err := sign(token.CardAuthenticationSigner); err != nil {
+		t.Fatal(err)
+	}
+	for _, get := range []func() (crypto.Signer, error){token.AuthenticationSigner, token.DigitalSignatureSigner} {
+		if err := sign(get); !errors.Is(err, piv.ErrAuthRequired) {
+			t.Fatalf("got %v, want piv.ErrAuthRequired", err)
+		}
+	}
+
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	for _, get := range []func() (crypto.Signer, error){token.AuthenticationSigner, token.DigitalSignatureSigner} {
+		if err := sign(get); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	decrypter, err := token.KeyManagementDecrypter()
+	if err != nil {
+		t.Fatal(err)
+	}
+	ciphertext, err := rsa.EncryptPKCS1v15(rand.Reader, decrypter.Public().(*rsa.PublicKey), []byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	plaintext, err := decrypter.Decrypt(rand.Reader, ciphertext, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(plaintext) != "secret" {
+		t.Fatalf("got %q", plaintext)
+	}
+
+	// Closing the Token locks the keys again.
+	if err := token.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := decrypter.Decrypt(rand.Reader, ciphertext, nil); !errors.Is(err, piv.ErrAuthRequired) {
+		t.Fatalf("got %v, want piv.ErrAuthRequired once closed", err)
+	}
+}
+
+func TestPIN(t *testing.T) {
+	token := newToken(t, softtoken.Config{})
+
+	var wrong piv.ErrWrongPIN
+	if err := token.VerifyPIN("000000"); !errors.As(err, &wrong) || wrong.Remaining != 2 {
+		t.Fatalf("got %v, want 2 tries remaining", err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	if retries, err := token.PINRetriesRemaining(); err != nil || retries != 3 {
+		t.Fatalf("got %d, %v, want the retries reset", retries, err)
+	}
+
+	if err := token.ChangePIN("123456", "12345"); err == nil {
+		t.Fatal("5 character PIN was accepted")
+	}
+	if err := token.ChangePIN("123456", "654321"); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 2; i++ {
+		token.VerifyPIN("000000")
+	}
+	if err := token.VerifyPIN("000000"); !errors.Is(err, piv.ErrPINBlocked) {
+		t.Fatalf("got %v, want piv.ErrPINBlocked", err)
+	}
+	if err := token.VerifyPIN("654321"); !errors.Is(err, piv.ErrPINBlocked) {
+		t.Fatalf("got %v, want piv.ErrPINBlocked for the right PIN once blocked", err)
+	}
+
+	if err := token.ResetRetryCounter("00000000", "11223344"); !errors.As(err, &wrong) {
+		t.Fatalf("got %v, want piv.ErrWrongPIN for the wrong PUK", err)
+	}
+	if err := token.ResetRetryCounter("12345678", "11223344"); err != nil {
+		t.Fatal(err)
+	}
+	if err := token.VerifyPIN("11223344"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := token.VerifyGlobalPIN("11223344"); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("got %v, want piv.ErrNotFound without a Global PIN", err)
+	}
+}
+
+func TestConcurrentUse(t *testing.T) {
+	token := newToken(t, softtoken.Config{})
+	card := token.Card()
+	digest := sha256.Sum256([]byte("softtoken"))
+	verify := append([]byte{0x00, 0x20, 0x00, 0x80, 0x08}, "123456\xFF\xFF"...)
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				token.VerifyPIN("123456")
+				card.Transmit(verify)
+				card.Transmit([]byte{0x00, 0x20, 0x00, 0x80})
+				token.PINRetriesRemaining()
+				if signer, err := token.AuthenticationSigner(); err == nil {
+					signer.Sign(rand.Reader, digest[:], crypto.SHA256)
+				}
+				token.PrintedInformation()
+				token.Close()
+			}
+		}()
+	}
+	wg.Wait()
+}
+
+func TestRetiredKeys(t *testing.T) {
+	token := newToken(t, softtoken.Config{
+		RetiredKeys:        2,
+		OffCardRetiredKeys: 1,
+		OffCardCertURLBase: "http://pki.example.gov/keyhistory",
+	})
+
+	certs, err := token.RetiredKeyManagementCertificates()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := token.VerifyPIN("123456"); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := token.RetiredKeyManagementDecrypters()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(certs) != 2 || len(keys) != 2 {
+		t.Fatalf("got %d certificates and %d keys, want 2 on the card", len(certs), len(keys))
+	}
+	for i := range certs {
+		if !certs[i].PublicKey.(*rsa.PublicKey).Equal(keys[i].Public()) {
+			t.Fatalf("retired key %d doesn't match its Certificate", i)
+		}
+	}
+	if len(token.OffCardKeyHistoryFile()) == 0 {
+		t.Fatal("no off card key history file")
+	}
+
+	token = newToken(t, softtoken.Config{})
+	if _, err := token.KeyHistory(); !errors.Is(err, piv.ErrNotFound) {
+		t.Fatalf("got %v, want piv.ErrNotFound without retired keys", err)
+	}
+	if token.OffCardKeyHistoryFile() != nil {
+		t.Fatal("off card key history file without off card keys")
+	}
+}
+
+func TestConfig(t *testing.T) {
+	ca, err := softtoken.NewCA(softtoken.CAConfig{Bits: 1024})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, config := range []softtoken.Config{
+		{RetiredKeys: piv.RetiredKeyManagementSlots + 1},
+		{RetiredKeys: piv.RetiredKeyManagementSlots, OffCardRetiredKeys: 1, OffCardCertURLBase: "http://example.gov"},
+		{RetiredKeys: -1},
+		{OffCardRetiredKeys: 1},
+	} {
+		config.CA = ca
+		config.Bits = 1024
+		if _, err := softtoken.New(config); err == nil {
+			t.Errorf("%+v: created", config)
+		}
+	}
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,225 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package tlv
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+)
+
+// Decoder reads BER-TLV elements, one at a time, from an io.Reader.
+type Decoder struct {
+	r      *bufio.Reader
+	mode   Mode
+	offset int
+}
+
+// NewDecoder creates a Decoder reading from the io.Reader, in the provided
+// Mode.
+func NewDecoder(r io.Reader, mode Mode) *Decoder {
+	return &Decoder{r: bufio.NewReader(r), mode: mode}
+}
+
+func (d *Decoder) readByte() (byte, error) {
+	b, err := d.r.ReadByte()
+	if err != nil {
+		return 0, err
+	}
+	d.offset++
+	return b, nil
+}
+
+func (d *Decoder) syntaxError(offset int, msg string) error {
+	return SyntaxError{Offset: offset, Msg: msg}
+}
+
+// Next returns the next element. When there are no elements left, io.EOF
+// is returned. If the data ends part way through an element, a SyntaxError
+// is returned.
+func (d *Decoder) Next() (*TLV, error) {
+	b, err := d.readByte()
+	if err != nil {
+		return nil, err
+	}
+	for d.mode == Lenient && (b == 0x00 || b == 0xFF) {
+		if b, err = d.readByte(); err != nil {
+			return nil, err
+		}
+	}
+
+	start := d.offset - 1
+	raw := []byte{b}
+
+	tag, err := d.readTag(b, start, &raw)
+	if err != nil {
+		return nil, err
+	}
+	length, err := d.readLength(&raw)
+	if err != nil {
+		return nil, err
+	}
+
+	value := bytes.Buffer{}
+	n, err := io.CopyN(&value, d.r, int64(length))
+	d.offset += int(n)
+	if err == io.EOF {
+		return nil, d.syntaxError(d.offset, "truncated value")
+	} else if err != nil {
+		return nil, err
+	}
+
+	return &TLV{
+		Tag:    tag,
+		Value:  value.Bytes(),
+		Raw:    append(raw, value.Bytes()...),
+		Offset: start,
+	}, nil
+}
+
+// readTag will read the remainder of a tag that starts with the provided
+// byte. Tags with all five low bits of the first byte set continue for as
+// long as the high bit of the following bytes is set.
+func (d *Decoder) readTag(first byte, start int, raw *[]byte) (Tag, error) {
+	tag := Tag(first)
+	if first&0x1F != 0x1F {
+		return tag, nil
+	}
+
+	for i := 0; ; i++ {
+		b, err := d.readByte()
+		if err == io.EOF {
+			return 0, d.syntaxError(d.offset, "truncated tag")
+		} else if err != nil {
+			return 0, err
+		}
+		*raw = append(*raw, b)
+
+		if d.mode == Strict {
+			if i == 0 && b == 0x80 {
+				return 0, d.syntaxError(start, "tag number has a redundant leading byte")
+			}
+			if i == 0 && b < 0x1F {
+				return 0, d.syntaxError(start, "tag number should use the short form")
+			}
+		}
+		if i == 3 {
+			return 0, d.syntaxError(start, "tag is longer than 4 bytes")
+		}
+
+		tag = tag<<8 | Tag(b)
+		if b&0x80 == 0 {
+			return tag, nil
+		}
+	}
+}
+
+// readLength will read a definite length, in either the short or long
+// form. Indefinite lengths are not allowed by SP 800-73-4.
+func (d *Decoder) readLength(raw *[]byte) (int, error) {
+	start := d.offset
+	b, err := d.readByte()
+	if err == io.EOF {
+		return 0, d.syntaxError(d.offset, "truncated length")
+	} else if err != nil {
+		return 0, err
+	}
+	*raw = append(*raw, b)
+
+	switch {
+	case b < 0x80:
+		return int(b), nil
+	case b == 0x80:
+		return 0, d.syntaxError(start, "indefinite length is not supported")
+	case b > 0x84:
+		return 0, d.syntaxError(start, "length is longer than 4 bytes")
+	}
+
+	size := int(b & 0x7F)
+	length := 0
+	for i := 0; i < size; i++ {
+		b, err := d.readByte()
+		if err == io.EOF {
+			return 0, d.syntaxError(d.offset, "truncated length")
+		} else if err != nil {
+			return 0, err
+		}
+		*raw = append(*raw, b)
+
+		if d.mode == Strict && i == 0 && b == 0x00 {
+			return 0, d.syntaxError(start, "length has a redundant leading byte")
+		}
+		length = length<<8 | int(b)
+	}
+	if length < 0 {
+		return 0, d.syntaxError(start, "length is too large")
+	}
+	if d.mode == Strict && length < 0x80 {
+		return 0, d.syntaxError(start, "length should use the short form")
+	}
+	return length, nil
+}
+
+// Decode will decode the entire run of BER-TLV elements in Strict mode.
+func Decode(data []byte) (TLVs, error) {
+	return DecodeMode(data, Strict)
+}
+
+// DecodeMode will decode the entire run of BER-TLV elements in the
+// provided Mode.
+func DecodeMode(data []byte, mode Mode) (TLVs, error) {
+	decoder := NewDecoder(bytes.NewReader(data), mode)
+	ret := TLVs{}
+	for {
+		el, err := decoder.Next()
+		if err == io.EOF {
+			return ret, nil
+		} else if err != nil {
+			return nil, err
+		}
+		ret = append(ret, *el)
+	}
+}
+
+// Unwrap will decode a single element with the provided tag, which must
+// span all of the data, and return its value.
+func Unwrap(data []byte, tag Tag, mode Mode) ([]byte, error) {
+	decoder := NewDecoder(bytes.NewReader(data), mode)
+	el, err := decoder.Next()
+	if err == io.EOF {
+		return nil, SyntaxError{Offset: 0, Msg: "expected tag " + tag.String() + ", got nothing"}
+	} else if err != nil {
+		return nil, err
+	}
+	if el.Tag != tag {
+		return nil, SyntaxError{Offset: el.Offset, Msg: "expected tag " + tag.String() + ", got " + el.Tag.String()}
+	}
+
+	if _, err := decoder.Next(); err != io.EOF {
+		if err != nil {
+			return nil, err
+		}
+		return nil, SyntaxError{Offset: el.Offset + len(el.Raw), Msg: "trailing data after tag " + tag.String()}
+	}
+	return el.Value, nil
+}
+
+// vim: foldmethod=marker
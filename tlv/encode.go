@@ -0,0 +1,81 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package tlv
+
+import (
+	"io"
+)
+
+// Encode will encode the value with the provided tag, using the shortest
+// form of the length.
+func Encode(tag Tag, value []byte) []byte {
+	ret := append(tag.Bytes(), encodeLength(len(value))...)
+	return append(ret, value...)
+}
+
+// Bytes returns the encoded form of the element. The Raw bytes are not
+// consulted, so the result always uses the shortest form of the length.
+func (t TLV) Bytes() []byte {
+	return Encode(t.Tag, t.Value)
+}
+
+// Bytes returns the encoded form of every element, one after another.
+func (t TLVs) Bytes() []byte {
+	ret := []byte{}
+	for _, el := range t {
+		ret = append(ret, el.Bytes()...)
+	}
+	return ret
+}
+
+// encodeLength returns the shortest encoding of the length.
+func encodeLength(l int) []byte {
+	switch {
+	case l < 0x80:
+		return []byte{byte(l)}
+	case l <= 0xFF:
+		return []byte{0x81, byte(l)}
+	case l <= 0xFFFF:
+		return []byte{0x82, byte(l >> 8), byte(l)}
+	case l <= 0xFFFFFF:
+		return []byte{0x83, byte(l >> 16), byte(l >> 8), byte(l)}
+	default:
+		return []byte{0x84, byte(l >> 24), byte(l >> 16), byte(l >> 8), byte(l)}
+	}
+}
+
+// Encoder writes BER-TLV elements to an io.Writer.
+type Encoder struct {
+	w io.Writer
+}
+
+// NewEncoder creates an Encoder writing to the io.Writer.
+func NewEncoder(w io.Writer) *Encoder {
+	return &Encoder{w: w}
+}
+
+// Encode will write the value with the provided tag.
+func (e *Encoder) Encode(tag Tag, value []byte) error {
+	_, err := e.w.Write(Encode(tag, value))
+	return err
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,128 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+// Package tlv implements the BER-TLV encoding used by PIV data objects, as
+// defined in ISO/IEC 7816-4 and SP 800-73-4. Every PIV container (the CHUID,
+// the CCC, the Discovery Object, the biometric wrappers, and so on) is a run
+// of BER-TLV encoded elements.
+//
+// Unlike encoding/asn1, this package does not assume the data is DER, and
+// will happily parse multi-byte tags and long form lengths without knowing
+// anything about the structure of the data. Decoding may be done in Strict
+// mode, which requires minimal encodings, or in Lenient mode, which accepts
+// anything a card might reasonably return.
+package tlv // import "pault.ag/go/piv/tlv"
+
+import (
+	"fmt"
+)
+
+// Tag is a BER-TLV tag, with the tag's bytes packed big-endian into an
+// integer, such that the tag encoded as 0x5F 0xC1 0x02 is Tag(0x5FC102).
+type Tag uint32
+
+// Bytes returns the encoded form of the Tag.
+func (t Tag) Bytes() []byte {
+	switch {
+	case t <= 0xFF:
+		return []byte{byte(t)}
+	case t <= 0xFFFF:
+		return []byte{byte(t >> 8), byte(t)}
+	case t <= 0xFFFFFF:
+		return []byte{byte(t >> 16), byte(t >> 8), byte(t)}
+	default:
+		return []byte{byte(t >> 24), byte(t >> 16), byte(t >> 8), byte(t)}
+	}
+}
+
+// Constructed returns true if the Tag's constructed bit is set, meaning its
+// value is itself a run of BER-TLV elements.
+func (t Tag) Constructed() bool {
+	return t.Bytes()[0]&0x20 != 0
+}
+
+// String returns the Tag as hex, such as "5FC102".
+func (t Tag) String() string {
+	return fmt.Sprintf("%X", t.Bytes())
+}
+
+// TLV is a single decoded BER-TLV element.
+type TLV struct {
+	Tag   Tag
+	Value []byte
+
+	// Raw is the entire encoded element, including the tag and length.
+	Raw []byte
+
+	// Offset of the start of the element from the start of the data being
+	// decoded.
+	Offset int
+}
+
+// Children will decode the TLV's value as a run of BER-TLV elements.
+func (t TLV) Children(mode Mode) (TLVs, error) {
+	return DecodeMode(t.Value, mode)
+}
+
+// TLVs is a run of BER-TLV elements.
+type TLVs []TLV
+
+// Find returns the first element with the provided tag, or nil if there
+// isn't one.
+func (t TLVs) Find(tag Tag) *TLV {
+	for i := range t {
+		if t[i].Tag == tag {
+			return &t[i]
+		}
+	}
+	return nil
+}
+
+// Mode controls how forgiving the decoder is about the encoding of the
+// data.
+type Mode int
+
+const (
+	// Strict requires tags and lengths to use their shortest encoding,
+	// and forbids anything between elements.
+	Strict Mode = iota
+
+	// Lenient allows tags and lengths with redundant leading bytes, and
+	// skips the 0x00 and 0xFF padding bytes ISO/IEC 7816-4 allows between
+	// elements.
+	Lenient
+)
+
+// SyntaxError is returned when the data is not valid BER-TLV.
+type SyntaxError struct {
+	// Offset of the byte the error was found at, from the start of the
+	// data being decoded.
+	Offset int
+
+	// Description of the problem.
+	Msg string
+}
+
+// Error implements the error interface.
+func (e SyntaxError) Error() string {
+	return fmt.Sprintf("tlv: %s at offset %d", e.Msg, e.Offset)
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,147 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package tlv_test
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv/tlv"
+)
+
+func TestTagBytes(t *testing.T) {
+	for _, test := range []struct {
+		tag  tlv.Tag
+		want []byte
+	}{
+		{0x53, []byte{0x53}},
+		{0x5F2D, []byte{0x5F, 0x2D}},
+		{0x5FC102, []byte{0x5F, 0xC1, 0x02}},
+	} {
+		if got := test.tag.Bytes(); !bytes.Equal(got, test.want) {
+			t.Errorf("%s: got %X, want %X", test.tag, got, test.want)
+		}
+	}
+	if !tlv.Tag(0x7E).Constructed() || tlv.Tag(0x53).Constructed() {
+		t.Error("constructed bit is wrong")
+	}
+}
+
+func TestRoundTrip(t *testing.T) {
+	for _, size := range []int{0, 1, 0x7F, 0x80, 0xFF, 0x100, 0x10000} {
+		value := bytes.Repeat([]byte{0xAB}, size)
+		data := append(tlv.Encode(0x5FC102, value), tlv.Encode(0x53, []byte{0x01})...)
+
+		elements, err := tlv.Decode(data)
+		if err != nil {
+			t.Fatalf("%d: %s", size, err)
+		}
+		if len(elements) != 2 {
+			t.Fatalf("%d: got %d elements", size, len(elements))
+		}
+		first := elements[0]
+		if first.Tag != 0x5FC102 || !bytes.Equal(first.Value, value) || first.Offset != 0 {
+			t.Fatalf("%d: got %s at %d, with a %d byte value", size, first.Tag, first.Offset, len(first.Value))
+		}
+		if second := elements.Find(0x53); second == nil || second.Offset != len(first.Raw) {
+			t.Fatalf("%d: second element is missing or at the wrong offset", size)
+		}
+		if !bytes.Equal(elements.Bytes(), data) {
+			t.Fatalf("%d: re-encoding doesn't match", size)
+		}
+	}
+}
+
+func TestStrictAndLenient(t *testing.T) {
+	for _, test := range []struct {
+		name    string
+		data    []byte
+		lenient bool
+	}{
+		{"redundant tag byte", []byte{0x5F, 0x80, 0x01, 0x00}, true},
+		{"short tag in long form", []byte{0x5F, 0x1E, 0x00}, true},
+		{"short length in long form", []byte{0x53, 0x81, 0x01, 0x00}, true},
+		{"redundant length byte", []byte{0x53, 0x82, 0x00, 0x01, 0x00}, true},
+		{"padding", []byte{0x00, 0xFF, 0x53, 0x01, 0x00, 0xFF}, true},
+		{"indefinite length", []byte{0x53, 0x80, 0x00, 0x00}, false},
+		{"truncated value", []byte{0x53, 0x02, 0x00}, false},
+		{"truncated length", []byte{0x53, 0x82, 0x01}, false},
+		{"five byte tag", []byte{0x5F, 0x81, 0x81, 0x81, 0x01}, false},
+	} {
+		var syntax tlv.SyntaxError
+		if _, err := tlv.Decode(test.data); !errors.As(err, &syntax) {
+			t.Errorf("%s: got %v in strict mode, want a SyntaxError", test.name, err)
+		}
+		_, err := tlv.DecodeMode(test.data, tlv.Lenient)
+		if test.lenient && err != nil {
+			t.Errorf("%s: got %v in lenient mode", test.name, err)
+		} else if !test.lenient && err == nil {
+			t.Errorf("%s: lenient mode accepted it", test.name)
+		}
+	}
+}
+
+func TestUnwrap(t *testing.T) {
+	value, err := tlv.Unwrap([]byte{0x53, 0x02, 0x01, 0x02}, 0x53, tlv.Strict)
+	if err != nil || !bytes.Equal(value, []byte{0x01, 0x02}) {
+		t.Fatalf("got %X, %v", value, err)
+	}
+
+	for _, test := range []struct {
+		name   string
+		data   []byte
+		mode   tlv.Mode
+		offset int
+	}{
+		{"empty", []byte{}, tlv.Strict, 0},
+		{"wrong tag", []byte{0x00, 0x00, 0x7E, 0x00}, tlv.Lenient, 2},
+		{"trailing data", []byte{0x53, 0x00, 0x01, 0x00}, tlv.Strict, 2},
+		{"trailing data after padding", []byte{0xFF, 0xFF, 0x53, 0x01, 0x00, 0x01, 0x00}, tlv.Lenient, 5},
+		{"truncated", []byte{0x53, 0x05, 0x00}, tlv.Strict, 3},
+	} {
+		_, err := tlv.Unwrap(test.data, 0x53, test.mode)
+		var syntax tlv.SyntaxError
+		if !errors.As(err, &syntax) {
+			t.Errorf("%s: got %v, want a SyntaxError", test.name, err)
+			continue
+		}
+		if syntax.Offset != test.offset {
+			t.Errorf("%s: got offset %d, want %d", test.name, syntax.Offset, test.offset)
+		}
+	}
+}
+
+func TestEncoder(t *testing.T) {
+	buf := bytes.Buffer{}
+	encoder := tlv.NewEncoder(&buf)
+	if err := encoder.Encode(0x5FC102, []byte{0x01}); err != nil {
+		t.Fatal(err)
+	}
+	if err := encoder.Encode(0xFE, nil); err != nil {
+		t.Fatal(err)
+	}
+	if want := []byte{0x5F, 0xC1, 0x02, 0x01, 0x01, 0xFE, 0x00}; !bytes.Equal(buf.Bytes(), want) {
+		t.Fatalf("got %X, want %X", buf.Bytes(), want)
+	}
+}
+
+// vim: foldmethod=marker
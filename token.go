@@ -20,11 +20,70 @@
 
 package piv
 
+import (
+	"context"
+	"crypto"
+)
+
+// Token is a PIV card (or something pretending to be one), exposing the
+// Certificates in each of the four PIV key slots, as well as the private
+// keys that correspond to them.
 type Token interface {
 	AuthenticationCertificate() (*Certificate, error)
 	DigitalSignatureCertificate() (*Certificate, error)
 	KeyManagementCertificate() (*Certificate, error)
 	CardAuthenticationCertificate() (*Certificate, error)
+
+	// Private key operations using the PIV Authentication key. This is
+	// usually used for client authentication, such as TLS or SSH.
+	AuthenticationSigner() (crypto.Signer, error)
+
+	// Private key operations using the Digital Signature key. This is
+	// usually used to sign documents or email.
+	DigitalSignatureSigner() (crypto.Signer, error)
+
+	// Private key operations using the Key Management key. This is usually
+	// used to decrypt email or documents encrypted to the cardholder.
+	KeyManagementDecrypter() (crypto.Decrypter, error)
+
+	// Private key operations using the Card Authentication key. Unlike the
+	// other keys, this may be used without the PIN.
+	CardAuthenticationSigner() (crypto.Signer, error)
+
+	// Certificates of the retired Key Management keys that have their
+	// Certificate stored on the card, in slot order, starting with key
+	// reference 0x82. If the card has no Key History Object, this is empty.
+	RetiredKeyManagementCertificates() ([]*Certificate, error)
+
+	// Private key operations using the retired Key Management keys, in the
+	// same order as RetiredKeyManagementCertificates. This is usually used
+	// to decrypt old email encrypted to the cardholder.
+	RetiredKeyManagementDecrypters() ([]crypto.Decrypter, error)
+}
+
+// TokenContext is a Token that may also be used with a context.Context, so
+// that slow reads off the card may be abandoned.
+//
+// Each method does the same as its Token counterpart, but returns early
+// with the context's error, such as context.DeadlineExceeded, if the
+// context is done before the card has answered. Only the lookup of the
+// private keys is covered; operations using the returned crypto.Signer or
+// crypto.Decrypter don't take a context.
+type TokenContext interface {
+	Token
+
+	AuthenticationCertificateContext(ctx context.Context) (*Certificate, error)
+	DigitalSignatureCertificateContext(ctx context.Context) (*Certificate, error)
+	KeyManagementCertificateContext(ctx context.Context) (*Certificate, error)
+	CardAuthenticationCertificateContext(ctx context.Context) (*Certificate, error)
+
+	AuthenticationSignerContext(ctx context.Context) (crypto.Signer, error)
+	DigitalSignatureSignerContext(ctx context.Context) (crypto.Signer, error)
+	KeyManagementDecrypterContext(ctx context.Context) (crypto.Decrypter, error)
+	CardAuthenticationSignerContext(ctx context.Context) (crypto.Signer, error)
+
+	RetiredKeyManagementCertificatesContext(ctx context.Context) ([]*Certificate, error)
+	RetiredKeyManagementDecryptersContext(ctx context.Context) ([]crypto.Decrypter, error)
 }
 
 // vim: foldmethod=marker
@@ -0,0 +1,111 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"crypto/x509"
+	"encoding/hex"
+	"fmt"
+	"strings"
+)
+
+// UUID is an RFC 4122 Universally Unique Identifier. FIPS 201-2 requires
+// every PIV card to have a Card UUID, which is written to the CHUID, as well
+// as the SAN of the PIV Authentication and Card Authentication Certificates.
+type UUID [16]byte
+
+// ParseUUID will parse a UUID in the canonical 8-4-4-4-12 hex string form,
+// such as "f81d4fae-7dec-11d0-a765-00a0c91e6bf6". An optional "urn:uuid:"
+// prefix is allowed.
+//
+// This will reject the nil UUID, and any UUID that isn't of the RFC 4122
+// variant, since neither are valid Card UUIDs.
+func ParseUUID(s string) (UUID, error) {
+	ret := UUID{}
+	s = strings.TrimPrefix(strings.ToLower(s), "urn:uuid:")
+
+	if len(s) != 36 || s[8] != '-' || s[13] != '-' || s[18] != '-' || s[23] != '-' {
+		return ret, fmt.Errorf("piv: UUID %q isn't in the 8-4-4-4-12 form", s)
+	}
+
+	b, err := hex.DecodeString(s[0:8] + s[9:13] + s[14:18] + s[19:23] + s[24:36])
+	if err != nil {
+		return ret, fmt.Errorf("piv: UUID %q isn't hex encoded: %w", s, err)
+	}
+	copy(ret[:], b)
+
+	if err := ret.Validate(); err != nil {
+		return UUID{}, err
+	}
+	return ret, nil
+}
+
+// Validate will ensure this is a UUID that may be used as a Card UUID,
+// which is to say, it's not the nil UUID, and is of the RFC 4122 variant.
+func (u UUID) Validate() error {
+	if u == (UUID{}) {
+		return fmt.Errorf("piv: UUID is the nil UUID")
+	}
+	if u[8]&0xC0 != 0x80 {
+		return fmt.Errorf("piv: UUID %s isn't an RFC 4122 UUID", u)
+	}
+	return nil
+}
+
+// Version returns the RFC 4122 version of the UUID, such as 4 for a random
+// UUID.
+func (u UUID) Version() int {
+	return int(u[6] >> 4)
+}
+
+// String returns the UUID in the canonical 8-4-4-4-12 form.
+func (u UUID) String() string {
+	h := hex.EncodeToString(u[:])
+	return fmt.Sprintf("%s-%s-%s-%s-%s", h[0:8], h[8:12], h[12:16], h[16:20], h[20:32])
+}
+
+// URN returns the UUID as a "urn:uuid:" URI, as it would appear in a
+// Certificate's SAN.
+func (u UUID) URN() string {
+	return "urn:uuid:" + u.String()
+}
+
+// Return the Card UUID from the urn:uuid URI in the Certificate SAN, or
+// nil if there isn't one.
+func cardUUID(cert *x509.Certificate) (*UUID, error) {
+	var ret *UUID
+	for _, uri := range cert.URIs {
+		if uri.Scheme != "urn" || !strings.HasPrefix(strings.ToLower(uri.Opaque), "uuid:") {
+			continue
+		}
+		if ret != nil {
+			return nil, fmt.Errorf("piv: Certificate has more than one urn:uuid SAN")
+		}
+		uuid, err := ParseUUID(uri.Opaque[len("uuid:"):])
+		if err != nil {
+			return nil, err
+		}
+		ret = &uuid
+	}
+	return ret, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,85 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"encoding/hex"
+	"errors"
+	"testing"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestParseUUID(t *testing.T) {
+	u, err := piv.ParseUUID("urn:uuid:3A7A43EF-0A3B-4C5E-9F3D-6B2A1C0D4E5F")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.String() != "3a7a43ef-0a3b-4c5e-9f3d-6b2a1c0d4e5f" || u.Version() != 4 {
+		t.Fatalf("got %s, version %d", u, u.Version())
+	}
+	if u.URN() != "urn:uuid:3a7a43ef-0a3b-4c5e-9f3d-6b2a1c0d4e5f" {
+		t.Fatalf("got URN %s", u.URN())
+	}
+
+	for _, bad := range []string{
+		"3a7a43ef0a3b4c5e9f3d6b2a1c0d4e5f",
+		"00000000-0000-0000-0000-000000000000",
+	} {
+		if _, err := piv.ParseUUID(bad); err == nil {
+			t.Errorf("%s was accepted", bad)
+		}
+	}
+
+	_, err = piv.ParseUUID("zz7a43ef-0a3b-4c5e-9f3d-6b2a1c0d4e5f")
+	var invalid hex.InvalidByteError
+	if !errors.As(err, &invalid) {
+		t.Fatalf("got %v, want a hex.InvalidByteError", err)
+	}
+}
+
+func TestCardUUID(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{UUID: testUUID})
+
+	for _, test := range []struct {
+		name string
+		get  func() (*piv.Certificate, error)
+		want *piv.UUID
+	}{
+		{"authentication", token.AuthenticationCertificate, &testUUID},
+		{"card authentication", token.CardAuthenticationCertificate, &testUUID},
+		{"digital signature", token.DigitalSignatureCertificate, nil},
+	} {
+		cert, err := test.get()
+		if err != nil {
+			t.Fatal(err)
+		}
+		switch {
+		case test.want == nil && cert.CardUUID != nil:
+			t.Errorf("%s: got Card UUID %s, want none", test.name, cert.CardUUID)
+		case test.want != nil && (cert.CardUUID == nil || *cert.CardUUID != *test.want):
+			t.Errorf("%s: got Card UUID %v, want %s", test.name, cert.CardUUID, test.want)
+		}
+	}
+}
+
+// vim: foldmethod=marker
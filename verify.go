@@ -0,0 +1,188 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"fmt"
+	"time"
+)
+
+var (
+	oidPIVContentSigning = asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 6, 7}
+
+	// Policies that a PIV content signer may be issued under.
+	contentSigningPolicies = Policies{fbcaPIVIContentSigning, commonPIVContentSigning}
+)
+
+// VerifyOptions contains parameters for verifying Certificates, and the
+// signatures on PIV data objects.
+type VerifyOptions struct {
+	// Trust anchors to build paths to, such as the Federal Common Policy
+	// CA. If this is nil, the system roots will be used, which are unlikely
+	// to contain any Federal PKI trust anchors.
+	Roots *x509.CertPool
+
+	// Any intermediate certificates that may be needed to build a path,
+	// such as the card issuer's CA.
+	Intermediates *x509.CertPool
+
+	// Time to validate the path at. If this is zero, the current time will
+	// be used.
+	CurrentTime time.Time
+
+	// Acceptable Extended Key Usages. If this is empty, any EKU will be
+	// accepted, unlike crypto/x509, which defaults to TLS server auth.
+	KeyUsages []x509.ExtKeyUsage
+
+	// RFC 5280 policy processing inputs, used to determine which policies
+	// are valid for the path.
+	PolicyOptions
+}
+
+// Verify will attempt to build a path from the Certificate to one of the
+// trusted roots, and process the certificate policies of that path as
+// described in RFC 5280, including any policy mappings and constraints
+// along the way.
+//
+// Unlike Certificate.Policies, which are the policies the Certificate
+// asserts, the returned Policies are the asserted policies that are actually
+// valid for the path that was built. If more than one valid path is found,
+// the returned Policies are those valid in any of them. Policies that are
+// unknown to this package are not returned.
+//
+// The returned Policies are in the Certificate's own domain. To get the
+// policies mapped into the trust anchor's domain, use EffectivePolicies.
+func (c Certificate) Verify(opts VerifyOptions) (Policies, error) {
+	return c.verify(opts, (*policyTree).leafPolicies)
+}
+
+// EffectivePolicies will build and validate a path exactly like Verify,
+// but return the policies mapped into the trust anchor's domain. For a
+// card issued by a cross-certified issuer, such as the DoD, this will return
+// the Common policies that the issuer's policies were mapped to, which can
+// then be compared across issuers, or passed to Policies.HighestAssurance.
+func (c Certificate) EffectivePolicies(opts VerifyOptions) (Policies, error) {
+	return c.verify(opts, (*policyTree).authorityPolicies)
+}
+
+// Build and validate a path, and return the union of the policies picked
+// out of the valid_policy_tree of each valid path.
+func (c Certificate) verify(
+	opts VerifyOptions,
+	policies func(*policyTree) []asn1.ObjectIdentifier,
+) (Policies, error) {
+	keyUsages := opts.KeyUsages
+	if len(keyUsages) == 0 {
+		keyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageAny}
+	}
+
+	chains, err := c.Certificate.Verify(x509.VerifyOptions{
+		Roots:         opts.Roots,
+		Intermediates: opts.Intermediates,
+		CurrentTime:   opts.CurrentTime,
+		KeyUsages:     keyUsages,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		ids   = []asn1.ObjectIdentifier{}
+		seen  = map[string]bool{}
+		valid = false
+	)
+	for _, chain := range chains {
+		tree, chainErr := processPolicies(chain, opts.PolicyOptions)
+		if chainErr != nil {
+			err = chainErr
+			continue
+		}
+		valid = true
+		for _, id := range policies(tree) {
+			if !seen[id.String()] {
+				seen[id.String()] = true
+				ids = append(ids, id)
+			}
+		}
+	}
+	if !valid {
+		return nil, err
+	}
+
+	return ParsePolicies(ids), nil
+}
+
+// Check to see if the Certificate has the given EKU.
+func hasExtKeyUsage(cert *x509.Certificate, id asn1.ObjectIdentifier) bool {
+	for _, eku := range cert.UnknownExtKeyUsage {
+		if eku.Equal(id) {
+			return true
+		}
+	}
+	return false
+}
+
+// Check to see if any of the Policies are in the set of acceptable
+// Policies.
+func (p Policies) intersects(acceptable Policies) bool {
+	for _, policy := range p {
+		for _, el := range acceptable {
+			if policy.ID.Equal(el.ID) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// VerifyContentSigner will ensure the certificate that signed a PIV data
+// object, such as the CHUID or a CBEFF biometric, is a PIV content signer,
+// and chains to a trusted root.
+func VerifyContentSigner(cert *x509.Certificate, opts VerifyOptions) (*Certificate, error) {
+	if !hasExtKeyUsage(cert, oidPIVContentSigning) {
+		return nil, fmt.Errorf("piv: signer isn't a PIV content signer")
+	}
+
+	signer, err := NewCertificate(cert)
+	if err != nil {
+		return nil, err
+	}
+
+	// Content signers from cross-certified issuers will assert their own
+	// content signing policy, which is mapped to one of ours, so either
+	// domain is acceptable here.
+	policies, err := signer.verify(opts, func(t *policyTree) []asn1.ObjectIdentifier {
+		return append(t.leafPolicies(), t.authorityPolicies()...)
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if !policies.intersects(contentSigningPolicies) {
+		return nil, fmt.Errorf("piv: signer wasn't issued under a PIV content signing policy")
+	}
+
+	return signer, nil
+}
+
+// vim: foldmethod=marker
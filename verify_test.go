@@ -0,0 +1,78 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package piv_test
+
+import (
+	"crypto/x509"
+	"encoding/asn1"
+	"testing"
+	"time"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/piv/softtoken"
+)
+
+func TestCertificateVerify(t *testing.T) {
+	token := newSoftToken(t, softtoken.Config{})
+	cert, err := token.AuthenticationCertificate()
+	if err != nil {
+		t.Fatal(err)
+	}
+	opts := piv.VerifyOptions{Roots: token.CA().Pool()}
+
+	policies, err := cert.Verify(opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(policies) != 1 || policies[0].Name != "commonAuth" {
+		t.Fatalf("got policies %v, want commonAuth", policies)
+	}
+	if policies.HighestAssurance() == piv.UnknownAssurance {
+		t.Fatal("commonAuth has no assurance level")
+	}
+
+	other := newSoftToken(t, softtoken.Config{})
+	if _, err := cert.Verify(piv.VerifyOptions{Roots: other.CA().Pool()}); err == nil {
+		t.Fatal("Certificate verified against the wrong CA")
+	}
+
+	expired := opts
+	expired.CurrentTime = cert.NotAfter.Add(time.Hour)
+	if _, err := cert.Verify(expired); err == nil {
+		t.Fatal("expired Certificate verified")
+	}
+
+	email := opts
+	email.KeyUsages = []x509.ExtKeyUsage{x509.ExtKeyUsageEmailProtection}
+	if _, err := cert.Verify(email); err == nil {
+		t.Fatal("Certificate verified for an EKU it doesn't have")
+	}
+
+	// commonHigh isn't asserted, so requiring it leaves no valid policy.
+	explicit := opts
+	explicit.InitialPolicies = []asn1.ObjectIdentifier{{2, 16, 840, 1, 101, 3, 2, 1, 3, 16}}
+	explicit.InitialExplicitPolicy = true
+	if _, err := cert.Verify(explicit); err == nil {
+		t.Fatal("Certificate verified without any of the initial policies")
+	}
+}
+
+// vim: foldmethod=marker
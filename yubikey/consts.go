@@ -0,0 +1,26 @@
+package yubikey
+
+// BER-TLV tags of the PIV data objects, as passed to GET DATA.
+const (
+	chuidObject          int32 = 0x5FC102
+	fingerprintsObject   int32 = 0x5FC103
+	securityObject       int32 = 0x5FC106
+	cccObject            int32 = 0x5FC107
+	facialObject         int32 = 0x5FC108
+	discoveryObject      int32 = 0x7E
+	keyHistory           int32 = 0x5FC10C
+	printedObject        int32 = 0x5FC109
+	irisObject           int32 = 0x5FC121
+	authenticationObject int32 = 0x5FC105
+	signatureObject      int32 = 0x5FC10A
+	keyManagementObject  int32 = 0x5FC10B
+	cardAuthObject       int32 = 0x5FC101
+
+	// Certificate object of the first retired Key Management slot. The
+	// rest follow one after another.
+	retiredKeyManagementObject int32 = 0x5FC10D
+)
+
+// Key reference of the first retired Key Management slot. The rest follow
+// one after another.
+const retiredKeyManagementKey int32 = 0x82
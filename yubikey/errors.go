@@ -0,0 +1,126 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package yubikey
+
+import (
+	"context"
+	"strings"
+
+	"pault.ag/go/piv"
+	"pault.ag/go/ykpiv"
+)
+
+// ykpiv returns its errors as plain strings, carrying the libykpiv (or
+// PC/SC) error message, so they're classified into the piv errors by that
+// message. The first entry with a matching message wins, so more specific
+// messages come first.
+var errorKinds = []struct {
+	messages []string
+	kinds    []error
+}{
+	{
+		[]string{"pin locked", "pin code blocked", "authentication method blocked"},
+		[]error{piv.ErrPINBlocked},
+	},
+	{
+		[]string{"object invalid", "invalid object", "file not found", "data object not found"},
+		[]error{piv.ErrNotFound},
+	},
+	{
+		[]string{"authentication error", "error during authentication", "security status not satisfied"},
+		[]error{piv.ErrAuthRequired, piv.ErrSecurityStatus},
+	},
+	{
+		[]string{
+			"card was removed", "no smart card", "reader unavailable", "unknown reader", "no readers",
+			"scard_w_removed_card", "scard_e_no_smartcard", "scard_e_reader_unavailable", "scard_e_no_readers_available",
+		},
+		[]error{piv.ErrCardRemoved},
+	},
+	{
+		[]string{"error in pcsc call", "pcsc service error"},
+		[]error{piv.ErrCommunication},
+	},
+	{
+		[]string{"not supported", "algorithm error"},
+		[]error{piv.ErrNotSupported},
+	},
+}
+
+// mapError will wrap an error from ykpiv in a piv.Error for each of the
+// matching kinds, so that it may be checked for with errors.Is. The ykpiv
+// error itself is still reachable with errors.As. Any other error,
+// including nil, is returned as is.
+func mapError(err error) error {
+	if err == nil {
+		return nil
+	}
+	message := strings.ToLower(err.Error())
+	for _, el := range errorKinds {
+		for _, m := range el.messages {
+			if !strings.Contains(message, m) {
+				continue
+			}
+			for i := len(el.kinds) - 1; i >= 0; i-- {
+				err = &piv.Error{Kind: el.kinds[i], Err: err}
+			}
+			return err
+		}
+	}
+	return err
+}
+
+// Read a data object off the Yubikey, mapping any error.
+func (y Yubikey) object(id int32) ([]byte, error) {
+	return y.objectContext(context.Background(), id)
+}
+
+// Read a data object off the Yubikey, giving up if the context is done
+// first.
+func (y Yubikey) objectContext(ctx context.Context, id int32) ([]byte, error) {
+	var data []byte
+	err := y.lock.Do(ctx, func() error {
+		var err error
+		data, err = y.GetObject(id)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return data, nil
+}
+
+// Look up a key slot on the Yubikey, mapping any error, and giving up if
+// the context is done first.
+func (y Yubikey) slotContext(ctx context.Context, id ykpiv.SlotId) (*ykpiv.Slot, error) {
+	var slot *ykpiv.Slot
+	err := y.lock.Do(ctx, func() error {
+		var err error
+		slot, err = y.Slot(id)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return slot, nil
+}
+
+// vim: foldmethod=marker
@@ -0,0 +1,88 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package yubikey
+
+import (
+	"context"
+	"crypto"
+	"io"
+
+	"pault.ag/go/piv/internal/cancel"
+	"pault.ag/go/ykpiv"
+)
+
+// privateKey is a key slot on the Yubikey, implementing both crypto.Signer
+// and crypto.Decrypter. The private key operation is done while holding
+// the Yubikey's lock, since libykpiv isn't safe to call from more than one
+// goroutine.
+type privateKey struct {
+	slot *ykpiv.Slot
+	lock cancel.Lock
+}
+
+// Wrap the slot, so that it takes the Yubikey's lock around its use.
+func (y Yubikey) privateKey(slot *ykpiv.Slot) *privateKey {
+	return &privateKey{slot: slot, lock: y.lock}
+}
+
+func (k privateKey) Public() crypto.PublicKey {
+	return k.slot.Public()
+}
+
+func (k privateKey) Sign(rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	return k.SignContext(context.Background(), rand, digest, opts)
+}
+
+// SignContext is Sign, giving up if the context is done before the
+// Yubikey is free, or before it returns.
+func (k privateKey) SignContext(ctx context.Context, rand io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var signature []byte
+	err := k.lock.Do(ctx, func() error {
+		var err error
+		signature, err = k.slot.Sign(rand, digest, opts)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return signature, nil
+}
+
+func (k privateKey) Decrypt(rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	return k.DecryptContext(context.Background(), rand, msg, opts)
+}
+
+// DecryptContext is Decrypt, giving up if the context is done before the
+// Yubikey is free, or before it returns.
+func (k privateKey) DecryptContext(ctx context.Context, rand io.Reader, msg []byte, opts crypto.DecrypterOpts) ([]byte, error) {
+	var plaintext []byte
+	err := k.lock.Do(ctx, func() error {
+		var err error
+		plaintext, err = k.slot.Decrypt(rand, msg, opts)
+		return mapError(err)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return plaintext, nil
+}
+
+// vim: foldmethod=marker
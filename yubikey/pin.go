@@ -0,0 +1,100 @@
+// {{{ Copyright (c) Paul R. Tagliamonte <paultag@gmail.com>, 2019
+//
+// Permission is hereby granted, free of charge, to any person obtaining a copy
+// of this software and associated documentation files (the "Software"), to deal
+// in the Software without restriction, including without limitation the rights
+// to use, copy, modify, merge, publish, distribute, sublicense, and/or sell
+// copies of the Software, and to permit persons to whom the Software is
+// furnished to do so, subject to the following conditions:
+//
+// The above copyright notice and this permission notice shall be included in
+// all copies or substantial portions of the Software.
+//
+// THE SOFTWARE IS PROVIDED "AS IS", WITHOUT WARRANTY OF ANY KIND, EXPRESS OR
+// IMPLIED, INCLUDING BUT NOT LIMITED TO THE WARRANTIES OF MERCHANTABILITY,
+// FITNESS FOR A PARTICULAR PURPOSE AND NONINFRINGEMENT. IN NO EVENT SHALL THE
+// AUTHORS OR COPYRIGHT HOLDERS BE LIABLE FOR ANY CLAIM, DAMAGES OR OTHER
+// LIABILITY, WHETHER IN AN ACTION OF CONTRACT, TORT OR OTHERWISE, ARISING FROM,
+// OUT OF OR IN CONNECTION WITH THE SOFTWARE OR THE USE OR OTHER DEALINGS IN
+// THE SOFTWARE. }}}
+
+package yubikey
+
+import (
+	"context"
+
+	"pault.ag/go/piv"
+)
+
+// ykpiv doesn't say why a PIN was rejected, so compare the retry counter
+// before and after the attempt, and only report a wrong PIN if it went down.
+func (y Yubikey) withRetries(f func() error) error {
+	return y.lock.Do(context.Background(), func() error {
+		return y.checkRetries(f)
+	})
+}
+
+func (y Yubikey) checkRetries(f func() error) error {
+	before, err := y.PINRetries()
+	if err != nil {
+		return mapError(err)
+	}
+	if before == 0 {
+		return piv.ErrPINBlocked
+	}
+
+	err = f()
+	if err == nil {
+		return nil
+	}
+
+	after, retriesErr := y.PINRetries()
+	switch {
+	case retriesErr != nil || after >= before:
+		return err
+	case after == 0:
+		return piv.ErrPINBlocked
+	default:
+		return piv.ErrWrongPIN{Remaining: after}
+	}
+}
+
+// VerifyPIN will log in to the Yubikey with the PIN, unlocking the private
+// keys.
+func (y Yubikey) VerifyPIN(pin string) error {
+	return y.withRetries(func() error {
+		return mapError(y.Login(pin))
+	})
+}
+
+func (y Yubikey) ChangePIN(oldPIN, newPIN string) error {
+	return y.withRetries(func() error {
+		return mapError(y.Yubikey.ChangePIN(oldPIN, newPIN))
+	})
+}
+
+// ResetRetryCounter will unblock the PIN using the PUK, setting a new PIN.
+// A wrong PUK is returned as the error from ykpiv, since the PUK retry
+// counter can't be read.
+func (y Yubikey) ResetRetryCounter(puk, newPIN string) error {
+	return y.lock.Do(context.Background(), func() error {
+		return mapError(y.UnblockPIN(puk, newPIN))
+	})
+}
+
+func (y Yubikey) PINRetriesRemaining() (int, error) {
+	var retries int
+	err := y.lock.Do(context.Background(), func() error {
+		var err error
+		retries, err = y.PINRetries()
+		return mapError(err)
+	})
+	if err != nil {
+		return 0, err
+	}
+	return retries, nil
+}
+
+var _ piv.PINManager = Yubikey{}
+
+// vim: foldmethod=marker
@@ -21,7 +21,15 @@
 package yubikey
 
 import (
+	"context"
+	"crypto"
+	"errors"
+	"fmt"
+
+	"pault.ag/go/cbeff"
 	"pault.ag/go/piv"
+	"pault.ag/go/piv/biometrics"
+	"pault.ag/go/piv/internal/cancel"
 	"pault.ag/go/ykpiv"
 )
 
@@ -30,17 +38,22 @@ func New(opts ykpiv.Options) (*Yubikey, error) {
 	if err != nil {
 		return nil, err
 	}
-	yk := Yubikey{token}
+	yk := Yubikey{Yubikey: token, lock: cancel.NewLock()}
 	return &yk, nil
 }
 
 //
 type Yubikey struct {
 	*ykpiv.Yubikey
+
+	// libykpiv isn't safe to call from more than one goroutine, and calls
+	// abandoned when their context was done keep running, so calls are
+	// made one at a time.
+	lock cancel.Lock
 }
 
-func (y Yubikey) getCertificate(slotId ykpiv.SlotId) (*piv.Certificate, error) {
-	slot, err := y.Slot(slotId)
+func (y Yubikey) getCertificate(ctx context.Context, slotId ykpiv.SlotId) (*piv.Certificate, error) {
+	slot, err := y.slotContext(ctx, slotId)
 	if err != nil {
 		return nil, err
 	}
@@ -49,22 +62,279 @@ func (y Yubikey) getCertificate(slotId ykpiv.SlotId) (*piv.Certificate, error) {
 
 //
 func (y Yubikey) AuthenticationCertificate() (*piv.Certificate, error) {
-	return y.getCertificate(ykpiv.Authentication)
+	return y.AuthenticationCertificateContext(context.Background())
+}
+
+//
+func (y Yubikey) AuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return y.getCertificate(ctx, ykpiv.Authentication)
 }
 
 //
 func (y Yubikey) DigitalSignatureCertificate() (*piv.Certificate, error) {
-	return y.getCertificate(ykpiv.Signature)
+	return y.DigitalSignatureCertificateContext(context.Background())
+}
+
+//
+func (y Yubikey) DigitalSignatureCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return y.getCertificate(ctx, ykpiv.Signature)
 }
 
 //
 func (y Yubikey) KeyManagementCertificate() (*piv.Certificate, error) {
-	return y.getCertificate(ykpiv.KeyManagement)
+	return y.KeyManagementCertificateContext(context.Background())
+}
+
+//
+func (y Yubikey) KeyManagementCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return y.getCertificate(ctx, ykpiv.KeyManagement)
 }
 
 //
 func (y Yubikey) CardAuthenticationCertificate() (*piv.Certificate, error) {
-	return y.getCertificate(ykpiv.CardAuthentication)
+	return y.CardAuthenticationCertificateContext(context.Background())
+}
+
+//
+func (y Yubikey) CardAuthenticationCertificateContext(ctx context.Context) (*piv.Certificate, error) {
+	return y.getCertificate(ctx, ykpiv.CardAuthentication)
+}
+
+//
+func (y Yubikey) CHUID() (*piv.CHUID, error) {
+	data, err := y.object(chuidObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCHUID(data)
+}
+
+//
+func (y Yubikey) CardCapabilityContainer() (*piv.CardCapabilityContainer, error) {
+	data, err := y.object(cccObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseCardCapabilityContainer(data)
+}
+
+//
+func (y Yubikey) DiscoveryObject() (*piv.DiscoveryObject, error) {
+	data, err := y.object(discoveryObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseDiscoveryObject(data)
+}
+
+// The Printed Information may only be read once the PIN has been verified.
+func (y Yubikey) PrintedInformation() (*piv.PrintedInformation, error) {
+	data, err := y.object(printedObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParsePrintedInformation(data)
+}
+
+//
+func (y Yubikey) KeyHistory() (*piv.KeyHistory, error) {
+	data, err := y.object(keyHistory)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseKeyHistory(data)
+}
+
+// Read the biometric data object off the Yubikey, and return the parsed
+// CBEFF.
+func (y Yubikey) cbeff(object int32) (*cbeff.CBEFF, error) {
+	data, err := y.object(object)
+	if err != nil {
+		return nil, err
+	}
+	return biometrics.ParseTLVCBEFF(data)
+}
+
+//
+func (y Yubikey) Facial() (*cbeff.CBEFF, error) {
+	return y.cbeff(facialObject)
+}
+
+// The Fingerprints may only be read once the PIN has been verified.
+func (y Yubikey) Fingerprints() (*cbeff.CBEFF, error) {
+	return y.cbeff(fingerprintsObject)
+}
+
+// As with the Fingerprints, the Iris images may only be read once the PIN
+// has been verified.
+func (y Yubikey) Iris() (*cbeff.CBEFF, error) {
+	return y.cbeff(irisObject)
+}
+
+//
+func (y Yubikey) SecurityObject() (*piv.SecurityObject, error) {
+	data, err := y.object(securityObject)
+	if err != nil {
+		return nil, err
+	}
+	return piv.ParseSecurityObject(data)
+}
+
+// Objects of the data objects that may be covered by the Security Object,
+// by ContainerID.
+var containerObjects = map[piv.ContainerID]int32{
+	piv.ContainerCCC:                           cccObject,
+	piv.ContainerCHUID:                         chuidObject,
+	piv.ContainerAuthenticationCertificate:     authenticationObject,
+	piv.ContainerFingerprints:                  fingerprintsObject,
+	piv.ContainerFacial:                        facialObject,
+	piv.ContainerPrintedInformation:            printedObject,
+	piv.ContainerDigitalSignatureCertificate:   signatureObject,
+	piv.ContainerKeyManagementCertificate:      keyManagementObject,
+	piv.ContainerCardAuthenticationCertificate: cardAuthObject,
+	piv.ContainerDiscoveryObject:               discoveryObject,
+	piv.ContainerKeyHistory:                    keyHistory,
+	piv.ContainerIris:                          irisObject,
+}
+
+// Container will read the raw data object with the given ContainerID off
+// the Yubikey, for checking against the Security Object.
+func (y Yubikey) Container(id piv.ContainerID) ([]byte, error) {
+	object, ok := containerObjects[id]
+	if !ok {
+		return nil, &piv.Error{
+			Kind: piv.ErrNotFound,
+			Err:  fmt.Errorf("piv: yubikey: unknown data object %s", id),
+		}
+	}
+	return y.object(object)
+}
+
+// Return the SlotIds of the retired Key Management keys with a Certificate
+// on the Yubikey. Yubikeys without a Key History Object have no retired
+// keys.
+func (y Yubikey) retiredSlots(ctx context.Context) ([]ykpiv.SlotId, error) {
+	data, err := y.objectContext(ctx, keyHistory)
+	if errors.Is(err, piv.ErrNotFound) {
+		return []ykpiv.SlotId{}, nil
+	} else if err != nil {
+		return nil, err
+	}
+	history, err := piv.ParseKeyHistory(data)
+	if err != nil {
+		return nil, err
+	}
+
+	slots := []ykpiv.SlotId{}
+	for i := 0; i < history.KeysWithOnCardCerts; i++ {
+		slots = append(slots, ykpiv.SlotId{
+			Certificate: retiredKeyManagementObject + int32(i),
+			Key:         retiredKeyManagementKey + int32(i),
+			Name:        fmt.Sprintf("Retired Key Management %d", i+1),
+		})
+	}
+	return slots, nil
+}
+
+//
+func (y Yubikey) RetiredKeyManagementCertificates() ([]*piv.Certificate, error) {
+	return y.RetiredKeyManagementCertificatesContext(context.Background())
+}
+
+//
+func (y Yubikey) RetiredKeyManagementCertificatesContext(ctx context.Context) ([]*piv.Certificate, error) {
+	slots, err := y.retiredSlots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	certs := []*piv.Certificate{}
+	for _, slotId := range slots {
+		cert, err := y.getCertificate(ctx, slotId)
+		if err != nil {
+			return nil, err
+		}
+		certs = append(certs, cert)
+	}
+	return certs, nil
+}
+
+//
+func (y Yubikey) RetiredKeyManagementDecrypters() ([]crypto.Decrypter, error) {
+	return y.RetiredKeyManagementDecryptersContext(context.Background())
+}
+
+//
+func (y Yubikey) RetiredKeyManagementDecryptersContext(ctx context.Context) ([]crypto.Decrypter, error) {
+	slots, err := y.retiredSlots(ctx)
+	if err != nil {
+		return nil, err
+	}
+	keys := []crypto.Decrypter{}
+	for _, slotId := range slots {
+		slot, err := y.slotContext(ctx, slotId)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, y.privateKey(slot))
+	}
+	return keys, nil
+}
+
+// The ykpiv.Slot type implements both crypto.Signer and crypto.Decrypter,
+// doing the private key operation on the Yubikey itself. It's wrapped so
+// that the operation is done while holding the Yubikey's lock.
+func (y Yubikey) getSigner(ctx context.Context, slotId ykpiv.SlotId) (crypto.Signer, error) {
+	slot, err := y.slotContext(ctx, slotId)
+	if err != nil {
+		return nil, err
+	}
+	return y.privateKey(slot), nil
+}
+
+//
+func (y Yubikey) AuthenticationSigner() (crypto.Signer, error) {
+	return y.AuthenticationSignerContext(context.Background())
+}
+
+//
+func (y Yubikey) AuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return y.getSigner(ctx, ykpiv.Authentication)
+}
+
+//
+func (y Yubikey) DigitalSignatureSigner() (crypto.Signer, error) {
+	return y.DigitalSignatureSignerContext(context.Background())
+}
+
+//
+func (y Yubikey) DigitalSignatureSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return y.getSigner(ctx, ykpiv.Signature)
 }
 
+//
+func (y Yubikey) KeyManagementDecrypter() (crypto.Decrypter, error) {
+	return y.KeyManagementDecrypterContext(context.Background())
+}
+
+//
+func (y Yubikey) KeyManagementDecrypterContext(ctx context.Context) (crypto.Decrypter, error) {
+	slot, err := y.slotContext(ctx, ykpiv.KeyManagement)
+	if err != nil {
+		return nil, err
+	}
+	return y.privateKey(slot), nil
+}
+
+//
+func (y Yubikey) CardAuthenticationSigner() (crypto.Signer, error) {
+	return y.CardAuthenticationSignerContext(context.Background())
+}
+
+//
+func (y Yubikey) CardAuthenticationSignerContext(ctx context.Context) (crypto.Signer, error) {
+	return y.getSigner(ctx, ykpiv.CardAuthentication)
+}
+
+var _ piv.TokenContext = Yubikey{}
+
 // vim: foldmethod=marker